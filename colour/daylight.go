@@ -0,0 +1,113 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package colour
+
+// CIE daylight basis functions S0, S1, S2 from 380 to 780nm in 10nm steps (CIE 15:2004).
+const (
+	daylightLambdaMin  = 380
+	daylightLambdaStep = 10
+)
+
+var daylightS = [3][41]float32{
+	{
+		63.4, 65.8, 94.8, 104.8, 105.9, 96.8, 113.9, 125.6, 125.5, 121.3, 121.3, 113.5, 113.1, 110.8,
+		106.5, 108.8, 105.3, 104.4, 100.0, 96.0, 95.1, 89.1, 90.5, 90.3, 88.4, 84.0, 85.1, 81.9, 82.6,
+		84.9, 81.3, 71.9, 74.3, 76.4, 63.3, 71.7, 77.0, 65.2, 47.7, 68.6, 65.0,
+	},
+	{
+		38.5, 35.0, 43.4, 46.3, 43.9, 37.1, 36.7, 35.9, 32.6, 27.9, 24.3, 20.1, 16.2, 13.2, 8.6, 6.1,
+		4.2, 1.9, 0.0, -1.6, -3.5, -3.5, -5.8, -7.2, -8.6, -9.5, -10.9, -10.7, -12.0, -14.0, -13.6,
+		-12.0, -13.3, -12.9, -10.6, -11.6, -12.2, -10.2, -7.8, -11.2, -10.4,
+	},
+	{
+		3.0, 1.2, -1.1, -0.5, -0.7, -1.2, -2.6, -2.9, -2.8, -2.6, -2.6, -1.8, -1.5, -1.3, -1.2, -1.0,
+		-0.5, -0.3, 0.0, 0.2, 0.5, 2.1, 3.2, 4.1, 4.7, 5.1, 6.7, 7.3, 8.6, 9.8, 10.2, 8.3, 9.6, 8.5,
+		7.0, 7.6, 8.0, 6.7, 5.2, 7.4, 6.8,
+	},
+}
+
+// Luminance of each daylight basis function (see SpectralLuminance).
+var daylightY [3]float32
+
+func init() {
+	for k := range daylightY {
+		k := k
+		daylightY[k] = SpectralLuminance(func(lambda float32) float32 { return daylightBasis(k, lambda) })
+	}
+}
+
+// daylightBasis returns basis function Sk at wavelength lambda.
+func daylightBasis(k int, lambda float32) float32 {
+	return Tabulated(daylightS[k][:], daylightLambdaMin, daylightLambdaStep, lambda)
+}
+
+// Tabulated returns the value at wavelength lambda of the spectrum given by the values f at
+// wavelengths lambdaMin, lambdaMin+step, ... by linear interpolation.  It is 0 outside the
+// table.
+func Tabulated(f []float32, lambdaMin, step, lambda float32) float32 {
+	x := (lambda - lambdaMin) / step
+
+	if x < 0 || x > float32(len(f)-1) {
+		return 0
+	}
+
+	i := int(x)
+
+	if i == len(f)-1 {
+		return f[i]
+	}
+
+	t := x - float32(i)
+
+	return (1-t)*f[i] + t*f[i+1]
+}
+
+// SpectralLuminance returns the luminance of the spectrum f relative to the constant spectrum
+// of 1, i.e. the average over the hero wavelengths of the luminance that ToRGB gives them.
+// Scaling f by Y/SpectralLuminance(f) gives the spectrum the same brightness as the RGB
+// colour Y,Y,Y.
+func SpectralLuminance(f func(lambda float32) float32) float32 {
+	var fy, y float64
+
+	for lambda := float32(LambdaMin) + 0.5; lambda < LambdaMax; lambda++ {
+		ybar := float64(cie1931deg2.Y(lambda))
+
+		fy += float64(f(lambda)) * ybar
+		y += ybar
+	}
+
+	return float32(fy / y)
+}
+
+// daylightM returns the weights M1, M2 of the basis functions S1, S2 for the daylight with
+// chromaticity x, y.
+func daylightM(x, y float32) (m1, m2 float32) {
+	d := 0.0241 + 0.2562*x - 0.7341*y
+
+	m1 = (-1.3515 - 1.7703*x + 5.9114*y) / d
+	m2 = (0.0300 - 31.4424*x + 30.0717*y) / d
+
+	return
+}
+
+// FromDaylight sets the spectrum to the CIE daylight (the D series illuminants, e.g. the sky)
+// with chromaticity x, y and luminance Y (see SpectralLuminance).
+func (wv *Spectrum) FromDaylight(x, y, Y float32) {
+	m1, m2 := daylightM(x, y)
+
+	lum := daylightY[0] + m1*daylightY[1] + m2*daylightY[2]
+
+	if !(lum > 0) {
+		wv.SetZero()
+		return
+	}
+
+	for k := 0; k < LambdaN; k++ {
+		lambda := wv.Wavelength(k)
+		S := daylightBasis(0, lambda) + m1*daylightBasis(1, lambda) + m2*daylightBasis(2, lambda)
+
+		wv.C[k] = Y * S / lum
+	}
+}
@@ -53,6 +53,11 @@ func (c RGB) Maxh() float32 {
 	return m.Max(m.Max(c[0], c[1]), c[2])
 }
 
+// Luminance returns the relative luminance (Y) of the linear RGB (Rec. 709 primaries).
+func (c RGB) Luminance() float32 {
+	return 0.2126*c[0] + 0.7152*c[1] + 0.0722*c[2]
+}
+
 // Minh returns the minimum component.
 func (c RGB) Minh() float32 {
 	return m.Min(m.Min(c[0], c[1]), c[2])
@@ -0,0 +1,129 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+// adaptive returns true if the globals stop sampling pixels individually.
+func (g *Globals) adaptive() bool {
+	return g.AdaptiveThreshold > 0 || g.MaxSamples > 0
+}
+
+// updateActive marks the pixels of the frame that still need samples after an iteration and
+// returns how many there are.  A pixel passes the noise test when it and its 8 neighbours are
+// below the threshold, otherwise pixels whose first samples happen to agree (e.g. all black
+// in a region lit by rare paths) would stop too early.
+//
+// Integrators that don't estimate each pixel from its own samples (MLT and SPPM) skip the
+// noise test and stop the whole image at once when every pixel has MaxSamples.
+func (frame *Frame) updateActive(g *Globals) (n int) {
+	fb := frame.rc.framebuf
+
+	if frame.active == nil {
+		frame.active = make([]bool, frame.w*frame.h)
+	}
+
+	perPixel := true
+
+	switch frame.integrator.(type) {
+	case *MLTIntegrator, *SPPMIntegrator:
+		perPixel = false
+	}
+
+	if perPixel && g.AdaptiveThreshold > 0 {
+		if frame.errors == nil {
+			frame.errors = make([]float32, frame.w*frame.h)
+		}
+
+		for y := 0; y < frame.h; y++ {
+			for x := 0; x < frame.w; x++ {
+				frame.errors[x+y*frame.w] = fb.PixelError(x, y)
+			}
+		}
+	}
+
+	done := true
+
+	for y := 0; y < frame.h; y++ {
+		for x := 0; x < frame.w; x++ {
+			idx := x + y*frame.w
+			samples := int(fb.Samples[idx])
+			active := g.MaxSamples <= 0 || samples < g.MaxSamples
+
+			if active && perPixel && g.AdaptiveThreshold > 0 && samples >= g.MinSamples {
+				active = frame.neighbourhoodError(x, y) > g.AdaptiveThreshold
+			}
+
+			frame.active[idx] = active
+
+			if active {
+				n++
+			}
+
+			done = done && !active
+		}
+	}
+
+	if !perPixel && !done {
+		for i := range frame.active {
+			frame.active[i] = true
+		}
+
+		n = len(frame.active)
+	}
+
+	return
+}
+
+// neighbourhoodError returns the largest error of pixel x,y and its neighbours.
+func (frame *Frame) neighbourhoodError(x, y int) (e float32) {
+	for j := y - 1; j <= y+1; j++ {
+		for i := x - 1; i <= x+1; i++ {
+			if i >= 0 && j >= 0 && i < frame.w && j < frame.h && frame.errors[i+j*frame.w] > e {
+				e = frame.errors[i+j*frame.w]
+			}
+		}
+	}
+
+	return
+}
+
+// isActive returns true if pixel x,y should be sampled.
+func (frame *Frame) isActive(x, y int) bool {
+	return frame.active == nil || frame.active[x+y*frame.w]
+}
+
+// tileActive returns true if any pixel of the tile at x,y of size w,h should be sampled.
+func (frame *Frame) tileActive(x, y, w, h int) bool {
+	for j := y; j < y+h; j++ {
+		for i := x; i < x+w; i++ {
+			if frame.isActive(i, j) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sampleStats sets the per-pixel sample count statistics in stats.
+func (fb *FrameBuffer) sampleStats(stats *Stats) {
+	var total uint64
+
+	stats.MinSamples = ^uint32(0)
+	stats.MaxSamples = 0
+
+	for _, n := range fb.Samples[:fb.W*fb.H] {
+		total += uint64(n)
+
+		if n < stats.MinSamples {
+			stats.MinSamples = n
+		}
+
+		if n > stats.MaxSamples {
+			stats.MaxSamples = n
+		}
+	}
+
+	stats.MeanSamples = float64(total) / float64(fb.W*fb.H)
+}
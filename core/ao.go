@@ -0,0 +1,113 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+)
+
+// AOIntegrator renders ambient occlusion, the cosine weighted fraction of the hemisphere above
+// the first hit that is not blocked within MaxDist.  Materials and lights are ignored.
+type AOIntegrator struct {
+	NodeName string  `node:"Name"`
+	MaxDist  float32 // Occluders further than this are ignored, 0 for no limit
+	Samples  int     // Occlusion rays per camera sample
+}
+
+// NewAOIntegrator returns an AOIntegrator set to the defaults.
+func NewAOIntegrator() *AOIntegrator {
+	return &AOIntegrator{Samples: 16}
+}
+
+// Name implements Node.
+func (ao *AOIntegrator) Name() string { return ao.NodeName }
+
+// PreRender implements Node.
+func (ao *AOIntegrator) PreRender(rc *RenderContext) error { return nil }
+
+// PostRender implements Node.
+func (ao *AOIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.
+func (ao *AOIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	maxDist := ao.MaxDist
+
+	if maxDist <= 0 {
+		// Long enough to cross the scene.
+		b := &rc.scene.bounds
+		maxDist = 2 * m.Vec3Length(m.Vec3Sub(m.Vec3(b.Bounds[1]), m.Vec3(b.Bounds[0])))
+	}
+
+	return &aoWorker{ao: ao, maxDist: maxDist, shadow: &RayData{}}
+}
+
+// aoWorker is the IntegratorWorker for the AOIntegrator.
+type aoWorker struct {
+	ao      *AOIntegrator
+	maxDist float32
+	shadow  *RayData // Heap allocated for alignment
+	shadowg ShaderGlobals
+	sg      ShaderGlobals
+}
+
+// Radiance implements IntegratorWorker.
+func (w *aoWorker) Radiance(ray *RayData, aov *AOVSample) colour.RGB {
+	sg := &w.sg
+
+	*sg = ShaderGlobals{
+		X:       ray.X,
+		Y:       ray.Y,
+		Ro:      ray.Ray.P,
+		Rd:      ray.Ray.D,
+		RayType: uint16(ray.Type),
+		Depth:   ray.Level,
+		rnd:     ray.rnd,
+		Lambda:  ray.Lambda,
+		Time:    ray.Time,
+		aov:     aov,
+		pool:    ray.pool,
+	}
+
+	if !TraceProbe(ray, sg) || sg.Shader == nil {
+		return colour.RGB{}
+	}
+
+	sg.ElemID = ray.Result.ElemID
+	sg.Rl = float64(m.Vec3Length(m.Vec3Sub(sg.P, sg.Ro)))
+	sg.writeBuiltinAOVs()
+
+	if w.ao.Samples <= 0 {
+		return colour.RGB{}
+	}
+
+	// Occlusion is computed on the side of the surface facing the camera.
+	flip := m.Vec3Dot(sg.N, sg.Rd) > 0
+	unoccluded := 0
+
+	for i := 0; i < w.ao.Samples; i++ {
+		d := sg.TangentToWorld(sample.CosineHemisphere(sg.rnd.Float64(), sg.rnd.Float64()))
+
+		if flip {
+			d = m.Vec3Neg(d)
+		}
+
+		if (m.Vec3Dot(d, sg.Ng) < 0) == (m.Vec3Dot(sg.Rd, sg.Ng) < 0) {
+			// Below the geometric surface.
+			continue
+		}
+
+		offsetRay(w.shadow, RayShadow, sg, m.Vec3Scale(w.maxDist, d), 1)
+
+		if !TraceProbe(w.shadow, &w.shadowg) {
+			unoccluded++
+		}
+	}
+
+	f := float32(unoccluded) / float32(w.ao.Samples)
+
+	return colour.RGB{f, f, f}
+}
@@ -0,0 +1,177 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// AOVType is the type of an arbitrary output variable.
+type AOVType uint8
+
+// AOV types.
+const (
+	AOVFloat AOVType = iota // Single float
+	AOVVec2                 // Two floats (e.g. UV)
+	AOVRGB                  // Linear RGB colour
+	AOVVec3                 // Vector or point (not normalized after filtering)
+)
+
+// Components returns the number of floats in an AOV of type t.
+func (t AOVType) Components() int {
+	switch t {
+	case AOVVec2:
+		return 2
+	case AOVRGB, AOVVec3:
+		return 3
+	}
+	return 1
+}
+
+// AOV describes an arbitrary output variable (render pass) stored in the frame buffer
+// alongside the beauty image.
+//
+// Most AOVs are filtered in the same way as the beauty image.  Closest AOVs (IDs and
+// depth) take the value from the sample with the highest filter weight as averaging
+// them isn't meaningful.  Where no surface is hit the AOV is 0, IDs are offset by one
+// so that 0 always represents the background.
+type AOV struct {
+	Name    string
+	Type    AOVType
+	Closest bool
+
+	offset int // Offset of the AOV values in AOVSample.Values
+}
+
+// Builtin AOVs are written by Trace for the first hit of each camera ray.  The lobe AOVs
+// (diffuse, specular, transmission and emission) are written by the material.
+var builtinAOVs = []AOV{
+	{Name: "Rl", Type: AOVFloat, Closest: true},
+	{Name: "P", Type: AOVVec3},
+	{Name: "N", Type: AOVVec3},
+	{Name: "Ng", Type: AOVVec3},
+	{Name: "UV", Type: AOVVec2},
+	{Name: "ElemID", Type: AOVFloat, Closest: true},
+	{Name: "PrimID", Type: AOVFloat, Closest: true},
+	{Name: "MaterialID", Type: AOVFloat, Closest: true},
+	{Name: "diffuse", Type: AOVRGB},
+	{Name: "specular", Type: AOVRGB},
+	{Name: "transmission", Type: AOVRGB},
+	{Name: "emission", Type: AOVRGB},
+}
+
+// AOVSample holds the AOV values for a single camera sample.
+type AOVSample struct {
+	Values []float32
+}
+
+// RequestAOV adds the named AOV to the set stored in the frame buffer and returns it.  Output
+// nodes should call this in PreRender for each AOV they will write.  Names other than the
+// builtin AOVs are treated as RGB AOVs written by shaders with ShaderGlobals.AOVSetRGB
+// etc.  Requesting the same name more than once returns the same AOV.
+func (rc *RenderContext) RequestAOV(name string) *AOV {
+	if aov, present := rc.aovIndex[name]; present {
+		return aov
+	}
+
+	aov := &AOV{Name: name, Type: AOVRGB}
+
+	for i := range builtinAOVs {
+		if builtinAOVs[i].Name == name {
+			*aov = builtinAOVs[i]
+		}
+	}
+
+	aov.offset = rc.aovStride
+	rc.aovStride += aov.Type.Components()
+
+	rc.aovs = append(rc.aovs, aov)
+	rc.aovIndex[name] = aov
+
+	return aov
+}
+
+// AOVs returns the requested AOVs.
+func (rc *RenderContext) AOVs() []*AOV {
+	return rc.aovs
+}
+
+// AOVImage returns the resolved image for the named AOV with Type.Components() floats
+// per pixel, or nil if the AOV wasn't requested or rendering hasn't started.
+func (rc *RenderContext) AOVImage(name string) []float32 {
+	aov, present := rc.aovIndex[name]
+
+	if !present || rc.framebuf == nil {
+		return nil
+	}
+
+	out := make([]float32, rc.framebuf.W*rc.framebuf.H*aov.Type.Components())
+	rc.framebuf.ResolveAOV(aov, out)
+
+	return out
+}
+
+// newAOVSample returns a sample with storage for all requested AOVs, or nil if there are none.
+func (rc *RenderContext) newAOVSample() *AOVSample {
+	if len(rc.aovs) == 0 {
+		return nil
+	}
+
+	return &AOVSample{Values: make([]float32, rc.aovStride)}
+}
+
+// Reset clears the sample to the background values.
+func (s *AOVSample) Reset() {
+	for i := range s.Values {
+		s.Values[i] = 0
+	}
+}
+
+func (sg *ShaderGlobals) setAOV(name string, v []float32) {
+	if sg.aov == nil {
+		return
+	}
+
+	aov, present := grc.aovIndex[name]
+
+	if !present {
+		return
+	}
+
+	copy(sg.aov.Values[aov.offset:aov.offset+aov.Type.Components()], v)
+}
+
+// AOVSetFloat writes v to the named AOV if it has been requested.  Only the first hit of
+// a camera ray writes AOVs, for all other shading points this is a no-op.
+func (sg *ShaderGlobals) AOVSetFloat(name string, v float32) {
+	sg.setAOV(name, []float32{v})
+}
+
+// AOVSetRGB writes c to the named AOV if it has been requested.
+func (sg *ShaderGlobals) AOVSetRGB(name string, c colour.RGB) {
+	sg.setAOV(name, c[:])
+}
+
+// AOVSetVec3 writes v to the named AOV if it has been requested.
+func (sg *ShaderGlobals) AOVSetVec3(name string, v m.Vec3) {
+	sg.setAOV(name, v[:])
+}
+
+// writeBuiltinAOVs writes the geometric AOVs for the current shading point.
+func (sg *ShaderGlobals) writeBuiltinAOVs() {
+	if sg.aov == nil {
+		return
+	}
+
+	sg.AOVSetFloat("Rl", float32(sg.Rl))
+	sg.AOVSetVec3("P", sg.P)
+	sg.AOVSetVec3("N", sg.N)
+	sg.AOVSetVec3("Ng", sg.Ng)
+	sg.setAOV("UV", []float32{sg.U, sg.V})
+	sg.AOVSetFloat("ElemID", float32(sg.ElemID)+1)
+	sg.AOVSetFloat("PrimID", float32(sg.PrimID)+1)
+	sg.AOVSetFloat("MaterialID", float32(sg.Shader.ID())+1)
+}
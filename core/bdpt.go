@@ -0,0 +1,785 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// BDPTIntegrator is a bidirectional path tracer (Veach 1997).  For each camera sample a
+// subpath is traced from the camera and another from a randomly chosen light, then every
+// vertex of one is connected to every vertex of the other.  The strategies are combined with
+// multiple importance sampling using the power heuristic.  Light subpaths connected directly
+// to the camera may be seen in any pixel and are splatted into the frame buffer.
+//
+// Globals.MaxDepth limits the number of bounces as for the path tracer, the per-type depth
+// limits and Russian roulette are not used.  The camera must implement LensCamera.
+//
+// Light paths aren't corrected for the non-symmetric scattering of refraction so light passing
+// through dielectrics along them is slightly off (by the square of the relative IOR).
+//
+// Background lights are at infinity, their light vertices are found by direction (camera
+// paths that leave the scene end on them) and the next vertex by the point on the disk facing
+// the scene, so the roles of the area and direction PDFs are swapped (as in PBRT).
+type BDPTIntegrator struct {
+	NodeName string `node:"Name"`
+}
+
+// Name implements Node.
+func (b *BDPTIntegrator) Name() string { return b.NodeName }
+
+// PreRender implements Node.
+func (b *BDPTIntegrator) PreRender(rc *RenderContext) error {
+	if _, ok := rc.FindNode("camera").(LensCamera); !ok {
+		return errors.New("BDPTIntegrator: camera doesn't support light path connections")
+	}
+	return nil
+}
+
+// PostRender implements Node.
+func (b *BDPTIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.
+func (b *BDPTIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	// PBRT's convention, the number of path edges less 2 (direct lighting is depth 1).
+	maxDepth := rc.globals.MaxDepth + 1
+
+	camera, _ := rc.FindNode("camera").(LensCamera)
+
+	return &bdpt{
+		rc:         rc,
+		camera:     camera,
+		cameraPath: make([]bdptVertex, maxDepth+2),
+		lightPath:  make([]bdptVertex, maxDepth+1),
+		maxDepth:   maxDepth,
+		ray:        &RayData{},
+		shadow:     &RayData{},
+	}
+}
+
+// Path vertex types.
+const (
+	vertexCamera = iota
+	vertexLight
+	vertexSurface
+)
+
+// bdptVertex is a vertex of a camera or light subpath.  PDFs are with respect to area, pdfFwd
+// is the density of sampling the vertex from the previous vertex on its own subpath and
+// pdfRev the density of sampling it from the next vertex (i.e. if the path were traced in
+// the other direction).
+type bdptVertex struct {
+	kind     int
+	P, N     m.Vec3          // Position and geometric normal (zero for the camera)
+	beta     colour.Spectrum // Throughput of the subpath up to the vertex
+	pdfFwd   float32
+	pdfRev   float32
+	delta    bool   // Scattered by a specular lobe
+	light    Light  // Light vertices only
+	elem     uint32 // Light vertices only, the element of the light (see LightSample.ElemID)
+	infinite bool   // Light vertices on background lights, P is outside the scene along the direction
+
+	hit     ShaderGlobals // Surface vertices, the globals for the ray hit
+	sg      ShaderGlobals // Surface vertices, the globals after setting up the closure
+	closure Closure       // Surface vertices, set up for the path arriving from the previous vertex
+}
+
+// connectible returns true if the vertex can be connected to another with a shadow ray.
+func (v *bdptVertex) connectible() bool {
+	if v.infinite {
+		return false
+	}
+	return v.kind != vertexSurface || v.closure.NonSpecular()
+}
+
+// f returns the closure at surface vertex v for the direction to the point P, including
+// the cosine.
+func (v *bdptVertex) f(P m.Vec3) (f colour.Spectrum) {
+	omegaO := v.sg.WorldToTangent(m.Vec3Normalize(m.Vec3Sub(P, v.P)))
+
+	// Connections between points on the same plane are tangent to the surface, the BSDFs
+	// aren't defined there (and the cosine is 0 anyway).
+	if omegaO[2] == 0 {
+		f.Lambda = v.sg.Lambda
+		return
+	}
+
+	f, _ = v.closure.Eval(omegaO, ^0)
+	return
+}
+
+// convertDensity converts the solid angle PDF pdf of sampling the direction from vertex
+// from to vertex to into a PDF with respect to area at to.  Vertices at infinity are found by
+// direction so the PDF is left as it is, from them pdf is the PDF with respect to area on the
+// disk facing the scene and is only projected onto to.
+func convertDensity(pdf float32, from, to *bdptVertex) float32 {
+	if to.infinite {
+		return pdf
+	}
+
+	w := m.Vec3Sub(to.P, from.P)
+	dist2 := m.Vec3Length2(w)
+
+	if dist2 == 0 {
+		return 0
+	}
+
+	if to.kind != vertexCamera {
+		pdf *= m.Abs(m.Vec3Dot(to.N, w)) / m.Sqrt(dist2)
+	}
+
+	if from.infinite {
+		return pdf
+	}
+
+	return pdf / dist2
+}
+
+// remap0 maps a zero PDF (a delta or unsampleable vertex) to 1 for the MIS weights.
+func remap0(f float32) float32 {
+	if f == 0 {
+		return 1
+	}
+	return f
+}
+
+// scatterRayType returns the ray type for continuing a path after scattering from a lobe of
+// type ty.
+func scatterRayType(ty int) uint32 {
+	switch {
+	case ty&ScatterTransmission != 0:
+		return RayTransmission
+	case ty&ScatterDiffuse != 0:
+		return RayDiffuse
+	}
+	return RayGlossy
+}
+
+// bdpt is the per-goroutine state of the BDPTIntegrator.
+type bdpt struct {
+	rc       *RenderContext
+	camera   LensCamera
+	maxDepth int
+	tile     *FrameBuffer // Tile being rendered, records the splats
+
+	cameraPath []bdptVertex
+	lightPath  []bdptVertex
+	sampled    bdptVertex // Endpoint resampled for connections with s=1 or t=1
+
+	ray     *RayData // Heap allocated for alignment
+	shadow  *RayData
+	shadowg ShaderGlobals
+	scratch ShaderGlobals
+	lightg  ShaderGlobals // Start of the light subpath
+	closure Closure       // Scratch closure for reverse PDFs
+}
+
+// StartTile implements SplattingWorker.
+func (b *bdpt) StartTile(tile *FrameBuffer, index int) { b.tile = tile }
+
+// Radiance implements IntegratorWorker.
+func (b *bdpt) Radiance(ray *RayData, aov *AOVSample) colour.RGB {
+	sg := &b.lightg
+
+	*sg = ShaderGlobals{
+		Ro:     ray.Ray.P,
+		Rd:     ray.Ray.D,
+		rnd:    ray.rnd,
+		Lambda: ray.Lambda,
+		Time:   ray.Time,
+		pool:   ray.pool,
+	}
+
+	var L colour.Spectrum
+	L.Lambda = ray.Lambda
+
+	b.rc.framebuf.AddLightPaths(1)
+
+	nCamera := b.cameraSubpath(ray, aov)
+	nLight := b.lightSubpath(sg)
+
+	for t := 1; t <= nCamera; t++ {
+		for s := 0; s <= nLight; s++ {
+			depth := s + t - 2
+
+			if (s == 1 && t == 1) || depth < 0 || depth > b.maxDepth {
+				continue
+			}
+
+			if t == 1 {
+				b.splat(s, sg)
+				continue
+			}
+
+			Lpath, weight := b.connect(s, t, sg)
+
+			if weight > 0 {
+				Lpath.Scale(weight)
+				L.Add(Lpath)
+			}
+		}
+	}
+
+	r, g, bl := L.ToRGB()
+	return colour.RGB{r, g, bl}
+}
+
+// cameraSubpath traces the subpath starting with the camera ray and returns the number of
+// vertices.
+func (b *bdpt) cameraSubpath(ray *RayData, aov *AOVSample) int {
+	v := &b.cameraPath[0]
+
+	v.kind = vertexCamera
+	v.P = ray.Ray.P
+	v.N = m.Vec3{}
+	v.beta.Lambda = ray.Lambda
+	v.beta.Set(1)
+	v.pdfFwd, v.pdfRev = 0, 0
+	v.delta = false
+	v.light = nil
+	v.infinite = false
+
+	// The camera vertex carries the ray's time and wavelengths for camera PDFs and light
+	// emission evaluated from it.
+	v.hit = ShaderGlobals{
+		X:       ray.X,
+		Y:       ray.Y,
+		Ro:      ray.Ray.P,
+		Rd:      ray.Ray.D,
+		RayType: uint16(ray.Type),
+		Depth:   ray.Level,
+		rnd:     ray.rnd,
+		Lambda:  ray.Lambda,
+		Time:    ray.Time,
+	}
+
+	_, pdfD := b.camera.PDF(ray.Ray.P, ray.Ray.D, ray.Time)
+
+	return b.randomWalk(b.cameraPath, ray, v.beta, pdfD, aov) + 1
+}
+
+// lightSubpath traces a subpath from a light chosen uniformly and returns the number of
+// vertices.
+func (b *bdpt) lightSubpath(sg *ShaderGlobals) int {
+	lights := grc.scene.lights
+
+	if len(lights) == 0 {
+		return 0
+	}
+
+	pick := 1 / float32(len(lights))
+	light := lights[int(sg.rnd.Float32()*float32(len(lights)))%len(lights)]
+
+	var ls LightSample
+
+	if light.SampleEmission(sg, &ls) != nil || ls.PdfA == 0 || ls.PdfD == 0 {
+		return 0
+	}
+
+	v := &b.lightPath[0]
+
+	v.kind = vertexLight
+	v.P = ls.P
+	v.N = ls.N
+	v.beta = ls.Le
+	v.beta.Scale(1 / (pick * ls.PdfA))
+	v.pdfFwd = pick * ls.PdfA
+	v.pdfRev = 0
+	v.delta = false
+	v.light = light
+	v.elem = ls.ElemID
+	v.infinite = isBackground(light)
+
+	beta := ls.Le
+	beta.Scale(m.Abs(m.Vec3Dot(ls.N, ls.D)) / (pick * ls.PdfA * ls.PdfD))
+
+	pdf := ls.PdfD
+
+	if v.infinite {
+		v.pdfFwd, pdf = pick*ls.PdfD, ls.PdfA
+	}
+
+	emissionRay(b.ray, &ls, sg)
+
+	return b.randomWalk(b.lightPath, b.ray, beta, pdf, nil) + 1
+}
+
+// randomWalk extends the subpath in path (which has its first vertex set up) by tracing ray
+// and sampling the closures.  beta is the throughput and pdf the solid angle PDF of ray.
+// Camera paths that leave the scene end on a vertex at infinity if there are background
+// lights.  Returns the number of vertices added.
+func (b *bdpt) randomWalk(path []bdptVertex, ray *RayData, beta colour.Spectrum, pdf float32, aov *AOVSample) int {
+	n := 0
+
+	for n+1 < len(path) {
+		prev := &path[n]
+		v := &path[n+1]
+
+		hit := &v.hit
+		*hit = ShaderGlobals{
+			X:       ray.X,
+			Y:       ray.Y,
+			Ro:      ray.Ray.P,
+			Rd:      ray.Ray.D,
+			RayType: uint16(ray.Type),
+			Depth:   ray.Level,
+			rnd:     ray.rnd,
+			Lambda:  ray.Lambda,
+			Time:    ray.Time,
+		}
+
+		if n == 0 {
+			hit.aov = aov
+		}
+
+		if !TraceProbe(ray, hit) {
+			if path[0].kind == vertexCamera && len(grc.scene.background) > 0 {
+				hit.aov = nil
+
+				v.kind = vertexLight
+				v.P = m.Vec3Mad(ray.Ray.P, ray.Ray.D, sceneDiameter())
+				v.N = m.Vec3Neg(ray.Ray.D)
+				v.beta = beta
+				v.infinite = true
+				v.pdfFwd = convertDensity(pdf, prev, v)
+				v.pdfRev = 0
+				v.delta = false
+				v.light = nil
+				v.elem = 0
+
+				n++
+			}
+			break
+		}
+
+		if hit.Shader == nil {
+			break
+		}
+
+		hit.ElemID = ray.Result.ElemID
+		hit.Rl = float64(m.Vec3Length(m.Vec3Sub(hit.P, hit.Ro)))
+		hit.writeBuiltinAOVs()
+		hit.aov = nil
+
+		v.kind = vertexSurface
+		v.P = hit.P
+		v.N = hit.Ng
+		v.beta = beta
+		v.infinite = false
+		v.pdfFwd = convertDensity(pdf, prev, v)
+		v.pdfRev = 0
+		v.delta = false
+		v.light = nil
+
+		v.sg = *hit
+		v.closure.Reset(&v.sg)
+		v.sg.Shader.Closure(&v.sg, &v.closure)
+
+		n++
+
+		if len(v.closure.Lobes) == 0 || n+1 >= len(path) {
+			break
+		}
+
+		omegaO, f, fpdf, ty := v.closure.Sample(v.sg.rnd.Float64(), v.sg.rnd.Float64(), v.sg.rnd.Float64())
+
+		if !(fpdf > 0) {
+			break
+		}
+
+		wi := v.sg.TangentToWorld(omegaO)
+
+		f.Scale(1 / fpdf)
+		beta.Mul(f)
+
+		pdf = fpdf
+		pdfRev := float32(0)
+
+		if ty&ScatterSpecular != 0 {
+			v.delta = true
+			pdf = 0
+		} else {
+			pdfRev = b.pdfReverse(v, wi, m.Vec3Sub(prev.P, v.P))
+		}
+
+		prev.pdfRev = convertDensity(pdfRev, v, prev)
+
+		v.sg.Depth = uint8(n)
+		offsetRay(ray, scatterRayType(ty), &v.sg, wi, m.Inf(1))
+	}
+
+	return n
+}
+
+// pdfReverse returns the solid angle PDF of surface vertex v scattering into direction to
+// when the path arrives from direction from (pointing away from v).  The closure depends on
+// the direction it is set up for so a new one is set up for from.
+func (b *bdpt) pdfReverse(v *bdptVertex, from, to m.Vec3) float32 {
+	sg := &b.scratch
+	*sg = v.hit
+	sg.Rd = m.Vec3Neg(m.Vec3Normalize(from))
+
+	b.closure.Reset(sg)
+	sg.Shader.Closure(sg, &b.closure)
+
+	omegaO := sg.WorldToTangent(m.Vec3Normalize(to))
+
+	if omegaO[2] == 0 {
+		return 0
+	}
+
+	_, pdf := b.closure.Eval(omegaO, ^0)
+
+	return pdf
+}
+
+// pdf returns the area PDF of vertex v sampling vertex next, when the path arrived at v from
+// prev (nil for the subpath endpoints).
+func (b *bdpt) pdf(v, prev, next *bdptVertex) float32 {
+	dir := m.Vec3Normalize(m.Vec3Sub(next.P, v.P))
+
+	switch v.kind {
+	case vertexLight:
+		if v.infinite {
+			return convertDensity(b.lightEmission(v, next).PdfA, v, next)
+		}
+		return convertDensity(b.lightEmission(v, next).PdfD, v, next)
+	case vertexCamera:
+		_, pdfD := b.camera.PDF(v.P, dir, v.hit.Time)
+		return convertDensity(pdfD, v, next)
+	}
+
+	if v.light != nil && prev == nil {
+		// Camera path hit a light, v is the start of the light path.
+		return convertDensity(b.lightEmission(v, next).PdfD, v, next)
+	}
+
+	return convertDensity(b.pdfReverse(v, m.Vec3Sub(prev.P, v.P), dir), v, next)
+}
+
+// lightEmission returns the emission from light vertex (or emissive surface vertex) v
+// towards vertex to.
+func (b *bdpt) lightEmission(v, to *bdptVertex) LightSample {
+	ls := LightSample{P: v.P, D: m.Vec3Normalize(m.Vec3Sub(to.P, v.P)), ElemID: v.hit.ElemID}
+	sg := &b.scratch
+	*sg = v.hit
+
+	if v.kind == vertexLight {
+		sg = &b.cameraPath[0].hit
+		ls.ElemID = v.elem
+	}
+
+	v.light.EvalEmission(sg, &ls)
+
+	return ls
+}
+
+// sceneDiameter returns a distance that takes a point in the scene outside it.
+func sceneDiameter() float32 {
+	bounds := grc.scene.bounds
+
+	return 2*m.Vec3Length(m.Vec3Sub(m.Vec3(bounds.Bounds[1]), m.Vec3(bounds.Bounds[0]))) + 1
+}
+
+// findLight returns the light that the surface vertex v is on, or nil.
+func findLight(v *bdptVertex) Light {
+	for _, light := range grc.scene.lights {
+		if light.PDF(&v.hit) > 0 {
+			return light
+		}
+	}
+	return nil
+}
+
+// visible returns true if the point P can be seen from surface vertex v.
+func (b *bdpt) visible(v *bdptVertex, P m.Vec3) bool {
+	offsetRay(b.shadow, RayShadow, &v.sg, m.Vec3Scale(1.0-VisRayEpsilon, m.Vec3Sub(P, v.P)), 1.0)
+
+	return !TraceProbe(b.shadow, &b.shadowg)
+}
+
+// connect returns the contribution of the strategy with s light and t camera vertices
+// (t > 1) and its MIS weight.
+func (b *bdpt) connect(s, t int, sg *ShaderGlobals) (L colour.Spectrum, weight float32) {
+	L.Lambda = sg.Lambda
+	pt := &b.cameraPath[t-1]
+
+	switch {
+	case s == 0 && pt.infinite:
+		// The camera path left the scene, each background light is weighted separately.
+		for _, light := range grc.scene.background {
+			Le := light.Background(&pt.hit, t == 2)
+
+			if isBlack(Le) {
+				continue
+			}
+
+			Le.Mul(pt.beta)
+
+			pt.light = light
+
+			if b.lightEmission(pt, &b.cameraPath[t-2]).PdfD > 0 {
+				Le.Scale(b.misWeight(s, t))
+			}
+
+			L.Add(Le)
+		}
+
+		pt.light = nil
+
+		return L, 1
+
+	case s == 0:
+		// The camera path hit a light.
+		if !pt.hit.Shader.HasEDF() {
+			return
+		}
+
+		E := pt.hit.Shader.Emission(&pt.hit, pt.hit.ViewDirection())
+
+		if E.Maxh() <= 0 {
+			return
+		}
+
+		L.FromRGB(E[0], E[1], E[2])
+		L.Mul(pt.beta)
+
+		if pt.light = findLight(pt); pt.light == nil {
+			// Not a light so no other strategy can find it.
+			return L, 1
+		}
+
+		weight = b.misWeight(s, t)
+		pt.light = nil
+
+	case s == 1:
+		// Resample a point on a light for the camera vertex (next event estimation).
+		lights := grc.scene.lights
+
+		if len(lights) == 0 || !pt.connectible() {
+			return
+		}
+
+		pick := 1 / float32(len(lights))
+		light := lights[int(sg.rnd.Float32()*float32(len(lights)))%len(lights)]
+
+		if light.SampleArea(&pt.sg) != nil {
+			return
+		}
+
+		P := m.Vec3Add(pt.P, m.Vec3Scale(pt.sg.Ldist, pt.sg.Ld))
+
+		L = pt.f(P)
+		L.Mul(pt.beta)
+		L.Mul(pt.sg.Liu)
+		L.Scale(pt.sg.Weight / pick)
+
+		if !isBlack(L) && b.visible(pt, P) {
+			ls := LightSample{P: P, D: m.Vec3Neg(pt.sg.Ld), ElemID: pt.sg.Lelem}
+			light.EvalEmission(&pt.sg, &ls)
+
+			q := &b.sampled
+			q.kind = vertexLight
+			q.P = P
+			q.N = ls.N
+			q.light = light
+			q.elem = ls.ElemID
+			q.delta = false
+			q.infinite = isBackground(light)
+			q.pdfFwd = pick * ls.PdfA
+
+			if q.infinite {
+				q.pdfFwd = pick * ls.PdfD
+			}
+
+			weight = b.misWeight(s, t)
+		}
+
+	default:
+		qs := &b.lightPath[s-1]
+
+		if !qs.connectible() || !pt.connectible() {
+			return
+		}
+
+		L = qs.f(pt.P)
+		L.Mul(qs.beta)
+		L.Mul(pt.f(qs.P))
+		L.Mul(pt.beta)
+		L.Scale(1 / m.Vec3Length2(m.Vec3Sub(qs.P, pt.P)))
+
+		if !isBlack(L) && b.visible(pt, qs.P) {
+			weight = b.misWeight(s, t)
+		}
+	}
+
+	return
+}
+
+// splat connects the light subpath with s vertices to the camera and splats the weighted
+// contribution into the pixel it is seen in.
+func (b *bdpt) splat(s int, sg *ShaderGlobals) {
+	qs := &b.lightPath[s-1]
+
+	if qs.kind != vertexSurface || !qs.connectible() {
+		return
+	}
+
+	lens, u, v, We, pdf, ok := b.camera.SampleLens(qs.P, sg.Time, sg.rnd)
+
+	if !ok || pdf == 0 || We == 0 {
+		return
+	}
+
+	L := qs.f(lens)
+	L.Mul(qs.beta)
+	L.Scale(We / pdf)
+
+	if isBlack(L) || !b.visible(qs, lens) {
+		return
+	}
+
+	c := &b.sampled
+	c.kind = vertexCamera
+	c.P = lens
+	c.N = m.Vec3{}
+	c.delta = false
+	c.infinite = false
+	c.hit.Time = sg.Time
+
+	L.Scale(b.misWeight(s, 1))
+
+	r, g, bl := L.ToRGB()
+
+	x, y := b.rc.raster(u, v)
+
+	b.tile.SplatLight(x, y, colour.RGB{r, g, bl})
+}
+
+// misWeight returns the power heuristic weight for the strategy with s light and t camera
+// vertices (Veach 1997, sec. 10.2).  The ratios of the PDFs of the other strategies that
+// could have generated the same path are accumulated walking out from the connection, only
+// the reverse PDFs of the vertices at and next to the connection change from those stored.
+// For s=1 or t=1 the endpoint is b.sampled.
+func (b *bdpt) misWeight(s, t int) float32 {
+	if s+t == 2 {
+		return 1
+	}
+
+	light := func(i int) *bdptVertex {
+		if s == 1 && i == 0 {
+			return &b.sampled
+		}
+		return &b.lightPath[i]
+	}
+
+	camera := func(i int) *bdptVertex {
+		if t == 1 && i == 0 {
+			return &b.sampled
+		}
+		return &b.cameraPath[i]
+	}
+
+	var qs, pt, qsMinus, ptMinus *bdptVertex
+
+	if s > 0 {
+		qs = light(s - 1)
+	}
+	if s > 1 {
+		qsMinus = light(s - 2)
+	}
+
+	pt = camera(t - 1)
+
+	if t > 1 {
+		ptMinus = camera(t - 2)
+	}
+
+	// Reverse PDFs of the vertices around the connection.
+	var ptRev, ptMinusRev, qsRev, qsMinusRev float32
+
+	if s > 0 {
+		ptRev = b.pdf(qs, qsMinus, pt)
+	} else if ls := b.lightEmission(pt, ptMinus); pt.infinite {
+		ptRev = ls.PdfD / float32(len(grc.scene.lights))
+	} else if ls.PdfA > 0 {
+		ptRev = ls.PdfA / float32(len(grc.scene.lights))
+	}
+
+	if ptMinus != nil {
+		if s > 0 {
+			ptMinusRev = b.pdf(pt, qs, ptMinus)
+		} else {
+			ptMinusRev = b.pdf(pt, nil, ptMinus)
+		}
+	}
+
+	if qs != nil {
+		qsRev = b.pdf(pt, ptMinus, qs)
+	}
+
+	if qsMinus != nil {
+		qsMinusRev = b.pdf(qs, pt, qsMinus)
+	}
+
+	sumRi := float32(0)
+
+	ri := float32(1)
+	for i := t - 1; i > 0; i-- {
+		v := camera(i)
+		pdfRev := v.pdfRev
+
+		switch i {
+		case t - 1:
+			pdfRev = ptRev
+		case t - 2:
+			pdfRev = ptMinusRev
+		}
+
+		ri *= remap0(pdfRev) / remap0(v.pdfFwd)
+
+		if (i == t-1 || !v.delta) && !camera(i-1).delta {
+			sumRi += ri * ri
+		}
+	}
+
+	ri = 1
+	for i := s - 1; i >= 0; i-- {
+		v := light(i)
+		pdfRev := v.pdfRev
+
+		switch i {
+		case s - 1:
+			pdfRev = qsRev
+		case s - 2:
+			pdfRev = qsMinusRev
+		}
+
+		ri *= remap0(pdfRev) / remap0(v.pdfFwd)
+
+		if i == 0 && isDelta(v.light) {
+			// Rays can't hit the light so there is no s=0 strategy.
+			break
+		}
+
+		if (i == s-1 || !v.delta) && (i == 0 || !light(i-1).delta) {
+			sumRi += ri * ri
+		}
+	}
+
+	return 1 / (1 + sumRi)
+}
+
+// isBlack returns true if all the components of s are 0.
+func isBlack(s colour.Spectrum) bool {
+	for _, c := range s.C {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -5,6 +5,7 @@
 package core
 
 import (
+	m "github.com/jamiec7919/vermeer/math"
 	"math/rand"
 )
 
@@ -15,3 +16,20 @@ type Camera interface {
 	// ComputeRay should return a world-space ray.
 	ComputeRay(u, v, time float32, rnd *rand.Rand, ray *RayData, sg *ShaderGlobals)
 }
+
+// LensCamera is implemented by cameras that light paths can be connected to, as needed by
+// bidirectional integrators.  Screen positions u,v are in [-1,1] as for ComputeRay.  The
+// importance is normalized so that it integrates to 1 over the whole screen.
+type LensCamera interface {
+	Camera
+
+	// SampleLens samples a point on the lens to connect the world space point P to at the
+	// given time.  Returns the lens point, the screen position P is seen at, the importance
+	// arriving at the lens from P and the PDF of the lens point with respect to solid angle
+	// at P.  ok is false if P can't be seen.
+	SampleLens(P m.Vec3, time float32, rnd *rand.Rand) (lens m.Vec3, u, v, We, pdf float32, ok bool)
+
+	// PDF returns the PDFs with which ComputeRay would generate a ray from lens point P
+	// in direction D, with respect to area on the lens and solid angle.
+	PDF(P, D m.Vec3, time float32) (pdfA, pdfD float32)
+}
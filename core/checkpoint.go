@@ -0,0 +1,253 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// CheckpointVersion is the version of the checkpoint format written, checkpoints of other
+// versions can't be resumed.
+const CheckpointVersion = 2
+
+// checkpointMagic identifies checkpoint files.
+const checkpointMagic = "VERMEER-CHECKPOINT"
+
+// Checkpoint errors.
+var (
+	ErrCheckpointFormat  = errors.New("not a checkpoint file")
+	ErrCheckpointVersion = errors.New("checkpoint version not supported")
+	ErrCheckpointScene   = errors.New("checkpoint was written for a different scene")
+	ErrCheckpointSampler = errors.New("checkpoint was written with different Sampler, Seed or MaxSamples")
+	ErrCheckpointFrame   = errors.New("checkpoint doesn't match the frame size or AOVs")
+	ErrNotResumable      = errors.New("integrator can't be resumed from a checkpoint")
+)
+
+// checkpointHeader is written first so the version and scene can be checked before reading
+// the rest.
+type checkpointHeader struct {
+	Magic      string
+	Version    int
+	SceneHash  []byte
+	Assets     []checkpointAsset
+	Sampler    string
+	Seed       int
+	MaxSamples int
+}
+
+// checkpointAsset identifies the version of a file read by the scene.
+type checkpointAsset struct {
+	Filename string
+	Size     int64 // -1 if the file can't be read
+	ModTime  int64 // Unix nanoseconds
+}
+
+// checkpoint is the state needed to continue a render.  The samplers only depend on the
+// iteration so the accumulated frame buffer and iteration are enough to carry on as if the
+// render hadn't stopped.
+type checkpoint struct {
+	Iteration int           // Next iteration to render
+	Elapsed   time.Duration // Render time so far, for Globals.TimeLimit
+
+	W, H, Stride int
+	RGB          []float32
+	Weight       []float32
+	Samples      []uint32
+	Lum, Lum2    []float32
+	AOV          []float32
+	AOVWeight    []float32
+	Light        []float32
+	LightPaths   uint64
+	Rejected     uint64
+}
+
+// SetSceneHash sets the hash identifying the scene (e.g. of the scene file).  It is stored in
+// checkpoints so that they are only resumed with the scene they were written for.
+func (rc *RenderContext) SetSceneHash(hash []byte) {
+	rc.sceneHash = hash
+}
+
+// AddAsset records a file read by the scene besides the scene file, e.g. a mesh or texture.
+// The size and modification time of the assets are stored in checkpoints so that they aren't
+// resumed once an asset has changed.
+func (rc *RenderContext) AddAsset(filename string) {
+	rc.assets = append(rc.assets, filename)
+}
+
+// checkpointAssets returns the assets in name order with their current size and modification
+// time.
+func (rc *RenderContext) checkpointAssets() (assets []checkpointAsset) {
+	names := append([]string(nil), rc.assets...)
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+
+		a := checkpointAsset{Filename: name, Size: -1}
+
+		if fi, err := os.Stat(name); err == nil {
+			a.Size, a.ModTime = fi.Size(), fi.ModTime().UnixNano()
+		}
+
+		assets = append(assets, a)
+	}
+
+	return assets
+}
+
+// Resume makes Render continue from the checkpoint named by Globals.Checkpoint.
+func (rc *RenderContext) Resume() {
+	rc.resume = true
+}
+
+// resumable returns true if the integrator keeps no state between iterations outside of the
+// frame buffer.
+func resumable(integrator Integrator) bool {
+	switch integrator.(type) {
+	case *MLTIntegrator, *SPPMIntegrator:
+		return false
+	}
+	return true
+}
+
+// writeCheckpoint writes the state of the render after iteration iter to the checkpoint file.
+// The file is replaced atomically so a crash while writing leaves the previous checkpoint.
+func (rc *RenderContext) writeCheckpoint(iter int, elapsed time.Duration) error {
+	fb := rc.framebuf
+	filename := rc.globals.Checkpoint
+	tmp := filename + ".tmp"
+
+	f, err := os.Create(tmp)
+
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := gob.NewEncoder(w)
+
+	err = enc.Encode(checkpointHeader{
+		Magic:      checkpointMagic,
+		Version:    CheckpointVersion,
+		SceneHash:  rc.sceneHash,
+		Assets:     rc.checkpointAssets(),
+		Sampler:    rc.globals.Sampler,
+		Seed:       rc.globals.Seed,
+		MaxSamples: rc.globals.MaxSamples,
+	})
+
+	if err == nil {
+		err = enc.Encode(checkpoint{
+			Iteration:  iter + 1,
+			Elapsed:    elapsed,
+			W:          fb.W,
+			H:          fb.H,
+			Stride:     fb.stride,
+			RGB:        fb.RGB,
+			Weight:     fb.Weight,
+			Samples:    fb.Samples,
+			Lum:        fb.Lum,
+			Lum2:       fb.Lum2,
+			AOV:        fb.AOV,
+			AOVWeight:  fb.aovWeight,
+			Light:      fb.Light,
+			LightPaths: fb.LightPaths,
+			Rejected:   fb.Rejected,
+		})
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
+
+// readCheckpoint loads the checkpoint file into the frame buffer and returns the iteration to
+// continue from and the time already spent rendering.
+func (rc *RenderContext) readCheckpoint() (iter int, elapsed time.Duration, err error) {
+	fb := rc.framebuf
+
+	f, err := os.Open(rc.globals.Checkpoint)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+
+	var hdr checkpointHeader
+
+	if err := dec.Decode(&hdr); err != nil || hdr.Magic != checkpointMagic {
+		return 0, 0, ErrCheckpointFormat
+	}
+
+	if hdr.Version != CheckpointVersion {
+		return 0, 0, fmt.Errorf("%v: %v", ErrCheckpointVersion, hdr.Version)
+	}
+
+	if !bytes.Equal(hdr.SceneHash, rc.sceneHash) {
+		return 0, 0, ErrCheckpointScene
+	}
+
+	assets := rc.checkpointAssets()
+
+	if len(hdr.Assets) != len(assets) {
+		return 0, 0, ErrCheckpointScene
+	}
+
+	for i := range assets {
+		if hdr.Assets[i] != assets[i] {
+			return 0, 0, fmt.Errorf("%v: %v has changed", ErrCheckpointScene, assets[i].Filename)
+		}
+	}
+
+	if hdr.Sampler != rc.globals.Sampler || hdr.Seed != rc.globals.Seed || hdr.MaxSamples != rc.globals.MaxSamples {
+		return 0, 0, ErrCheckpointSampler
+	}
+
+	var c checkpoint
+
+	if err := dec.Decode(&c); err != nil {
+		return 0, 0, err
+	}
+
+	if c.W != fb.W || c.H != fb.H || c.Stride != fb.stride {
+		return 0, 0, ErrCheckpointFrame
+	}
+
+	copy(fb.RGB, c.RGB)
+	copy(fb.Weight, c.Weight)
+	copy(fb.Samples, c.Samples)
+	copy(fb.Lum, c.Lum)
+	copy(fb.Lum2, c.Lum2)
+	copy(fb.AOV, c.AOV)
+	copy(fb.aovWeight, c.AOVWeight)
+	copy(fb.Light, c.Light)
+	fb.LightPaths = c.LightPaths
+	fb.Rejected = c.Rejected
+
+	return c.Iteration, c.Elapsed, nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Scatter type bit flags classify BSDF lobes (and so path vertices).
+const (
+	ScatterDiffuse = (1 << iota)
+	ScatterGlossy
+	ScatterSpecular // Perfect specular (delta) lobe, can't be evaluated for arbitrary directions
+	ScatterTransmission
+)
+
+// Lobe is a single weighted BSDF of a Closure.
+type Lobe struct {
+	BSDF   BSDF
+	Weight colour.Spectrum
+	Type   int // Combination of Scatter flags
+
+	prob float32 // Probability of sampling this lobe
+}
+
+// Closure represents the scattering at a surface point as a weighted sum of BSDF lobes.  All
+// directions are in the tangent space of the shading point (see ShaderGlobals.WorldToTangent)
+// and the view direction must have positive z, shaders should face-forward the normals
+// before creating the lobes.
+type Closure struct {
+	Lobes  []Lobe
+	Lambda float32
+}
+
+// Reset clears the closure for use at the point in sg.
+func (c *Closure) Reset(sg *ShaderGlobals) {
+	c.Lobes = c.Lobes[:0]
+	c.Lambda = sg.Lambda
+}
+
+// Add adds the lobe bsdf with the given weight and type (combination of Scatter flags).
+func (c *Closure) Add(bsdf BSDF, weight colour.RGB, ty int) {
+	if weight.Maxh() <= 0 {
+		return
+	}
+
+	lobe := Lobe{BSDF: bsdf, Type: ty}
+	lobe.Weight.Lambda = c.Lambda
+	lobe.Weight.FromRGB(weight[0], weight[1], weight[2])
+
+	c.Lobes = append(c.Lobes, lobe)
+
+	// Lobes are chosen in proportion to their maximum weight.
+	total := float32(0)
+
+	for i := range c.Lobes {
+		total += c.lobeWeight(i)
+	}
+
+	for i := range c.Lobes {
+		c.Lobes[i].prob = c.lobeWeight(i) / total
+	}
+}
+
+func (c *Closure) lobeWeight(i int) float32 {
+	return m.Max(m.Max(c.Lobes[i].Weight.C[0], c.Lobes[i].Weight.C[1]), m.Max(c.Lobes[i].Weight.C[2], c.Lobes[i].Weight.C[3]))
+}
+
+// NonSpecular returns true if the closure has any lobes that can be evaluated (i.e. that
+// light sampling is useful for).
+func (c *Closure) NonSpecular() bool {
+	for i := range c.Lobes {
+		if c.Lobes[i].Type&ScatterSpecular == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Eval returns the sum of the non-specular lobes matching mask for direction omegaO (multiplied
+// by the cosine with the normal) and the combined PDF of sampling omegaO.  A lobe matches if
+// its type has any of the bits in mask, transmission lobes only match if mask includes
+// ScatterTransmission.  Use ^0 for all lobes.
+func (c *Closure) Eval(omegaO m.Vec3, mask int) (f colour.Spectrum, pdf float32) {
+	f.Lambda = c.Lambda
+
+	transmit := omegaO[2] < 0
+
+	for i := range c.Lobes {
+		l := &c.Lobes[i]
+
+		if l.Type&ScatterSpecular != 0 || transmit != (l.Type&ScatterTransmission != 0) {
+			continue
+		}
+
+		p := float32(l.BSDF.PDF(omegaO))
+
+		if !(p > 0) {
+			continue
+		}
+
+		pdf += l.prob * p
+
+		if l.Type&mask != 0 && (l.Type&ScatterTransmission == 0 || mask&ScatterTransmission != 0) {
+			rho := l.BSDF.Eval(omegaO)
+			rho.Mul(l.Weight)
+			f.Add(rho)
+		}
+	}
+
+	return
+}
+
+// Sample chooses a lobe using r0 and samples a direction from it using r1, r2.  Returns the
+// direction, the value of the closure and the PDF as for Eval, and the type of the chosen
+// lobe.  For specular lobes f/pdf is the lobe weight.  A PDF of 0 indicates failure.
+func (c *Closure) Sample(r0, r1, r2 float64) (omegaO m.Vec3, f colour.Spectrum, pdf float32, ty int) {
+	if len(c.Lobes) == 0 {
+		return
+	}
+
+	k := 0
+
+	for r := float32(r0); k < len(c.Lobes)-1; k++ {
+		if r < c.Lobes[k].prob {
+			break
+		}
+		r -= c.Lobes[k].prob
+	}
+
+	l := &c.Lobes[k]
+
+	omegaO = l.BSDF.Sample(r1, r2)
+
+	if !(m.Vec3Length(omegaO) > 0.9) {
+		return
+	}
+
+	ty = l.Type
+
+	if l.Type&ScatterSpecular != 0 {
+		f = l.BSDF.Eval(omegaO)
+		f.Mul(l.Weight)
+		pdf = l.prob
+		return
+	}
+
+	f, pdf = c.Eval(omegaO, ^0)
+	return
+}
+
+// PowerHeuristic returns the multiple importance sampling weight for a sample with PDF pdfA
+// from one strategy combined with a strategy with PDF pdfB (Veach's power heuristic, beta=2).
+func PowerHeuristic(pdfA, pdfB float32) float32 {
+	a, b := pdfA*pdfA, pdfB*pdfB
+
+	if a+b == 0 {
+		return 0
+	}
+
+	return a / (a + b)
+}
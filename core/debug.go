@@ -0,0 +1,213 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"strings"
+	"time"
+)
+
+// ErrUnknownDebugMode is returned by ParseDebugMode if the mode name isn't recognised.
+var ErrUnknownDebugMode = errors.New("Unknown debug mode")
+
+// DebugMode selects the quantity shown by the DebugIntegrator and debug shaders.
+type DebugMode int
+
+// Debug modes.  DebugCost and DebugTime need the ray so are only available to the
+// DebugIntegrator.
+const (
+	DebugFacingRatio DebugMode = iota // |N.V| as grey
+	DebugN                            // Shading normal mapped to [0,1]
+	DebugNg                           // Geometric normal mapped to [0,1]
+	DebugUV                           // Surface parameters as red and green
+	DebugBary                         // Barycentric coordinates Bu, Bv and 1-Bu-Bv
+	DebugWireframe                    // Triangle edges over the facing ratio
+	DebugPrimID                       // Random colour per primitive
+	DebugElemID                       // Random colour per element (triangle)
+	DebugCost                         // Acceleration structure nodes visited by the camera ray
+	DebugTime                         // Time taken to path trace the sample
+)
+
+// debugWireWidth is the barycentric distance from an edge drawn as wireframe.
+const debugWireWidth = 0.02
+
+// ParseDebugMode returns the mode with the given name ("facing", "n", "ng", "uv", "bary",
+// "wireframe", "primid", "elemid", "cost" or "time").
+func ParseDebugMode(name string) (DebugMode, error) {
+	switch strings.ToLower(name) {
+	case "facing", "facingratio":
+		return DebugFacingRatio, nil
+	case "n":
+		return DebugN, nil
+	case "ng":
+		return DebugNg, nil
+	case "uv":
+		return DebugUV, nil
+	case "bary", "barycentric":
+		return DebugBary, nil
+	case "wireframe", "wire":
+		return DebugWireframe, nil
+	case "primid":
+		return DebugPrimID, nil
+	case "elemid":
+		return DebugElemID, nil
+	case "cost":
+		return DebugCost, nil
+	case "time":
+		return DebugTime, nil
+	}
+
+	return 0, ErrUnknownDebugMode
+}
+
+// DebugColour returns the colour for the surface modes at the hit in sg.  Returns black for
+// DebugCost and DebugTime.
+func DebugColour(mode DebugMode, sg *ShaderGlobals) colour.RGB {
+	switch mode {
+	case DebugFacingRatio:
+		f := m.Abs(m.Vec3Dot(sg.N, m.Vec3Normalize(sg.Rd)))
+		return colour.RGB{f, f, f}
+	case DebugN:
+		return vectorColour(sg.N)
+	case DebugNg:
+		return vectorColour(sg.Ng)
+	case DebugUV:
+		return colour.RGB{sg.U, sg.V, 0}
+	case DebugBary:
+		return colour.RGB{sg.Bu, sg.Bv, 1 - sg.Bu - sg.Bv}
+	case DebugWireframe:
+		f := m.Abs(m.Vec3Dot(sg.N, m.Vec3Normalize(sg.Rd)))
+
+		if m.Min(m.Min(sg.Bu, sg.Bv), 1-sg.Bu-sg.Bv) < debugWireWidth {
+			return colour.RGB{}
+		}
+		return colour.RGB{f, f, f}
+	case DebugPrimID:
+		return idColour(uint32(sg.PrimID))
+	case DebugElemID:
+		return idColour(sg.ElemID)
+	}
+
+	return colour.RGB{}
+}
+
+// vectorColour maps the unit vector v to a colour.
+func vectorColour(v m.Vec3) colour.RGB {
+	return colour.RGB{v[0]*0.5 + 0.5, v[1]*0.5 + 0.5, v[2]*0.5 + 0.5}
+}
+
+// idColour returns a random but repeatable colour for the ID.
+func idColour(id uint32) colour.RGB {
+	h := (id + 1) * 2654435761
+	h ^= h >> 15
+
+	return colour.RGB{
+		0.2 + 0.8*float32(h&0xff)/255,
+		0.2 + 0.8*float32((h>>8)&0xff)/255,
+		0.2 + 0.8*float32((h>>16)&0xff)/255,
+	}
+}
+
+// heatColour maps t in [0,1] through blue, green, yellow and red, values outside the range are
+// clamped.
+func heatColour(t float32) colour.RGB {
+	t = m.Clamp(t, 0, 1) * 3
+
+	switch {
+	case t < 1:
+		return colour.RGB{0, t, 1 - t}
+	case t < 2:
+		return colour.RGB{t - 1, 1, 0}
+	}
+	return colour.RGB{1, 3 - t, 0}
+}
+
+// DebugIntegrator shows geometric quantities or render statistics without any lighting, for
+// checking models and finding slow areas of the image.
+type DebugIntegrator struct {
+	NodeName string  `node:"Name"`
+	Mode     string  // Name of the mode (see ParseDebugMode)
+	Scale    float32 // Value shown as red by the cost (nodes) and time (microseconds) modes
+
+	mode DebugMode
+}
+
+// NewDebugIntegrator returns a DebugIntegrator set to the defaults.
+func NewDebugIntegrator() *DebugIntegrator {
+	return &DebugIntegrator{
+		Mode:  "facing",
+		Scale: 100,
+	}
+}
+
+// Name implements Node.
+func (d *DebugIntegrator) Name() string { return d.NodeName }
+
+// PreRender implements Node.
+func (d *DebugIntegrator) PreRender(rc *RenderContext) (err error) {
+	d.mode, err = ParseDebugMode(d.Mode)
+	return
+}
+
+// PostRender implements Node.
+func (d *DebugIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.
+func (d *DebugIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	return &debugWorker{debug: d, tracer: newPathTracer(&rc.globals)}
+}
+
+// debugWorker is the IntegratorWorker for the DebugIntegrator.
+type debugWorker struct {
+	debug  *DebugIntegrator
+	tracer *pathTracer // Used by DebugTime
+	sg     ShaderGlobals
+}
+
+// Radiance implements IntegratorWorker.
+func (w *debugWorker) Radiance(ray *RayData, aov *AOVSample) colour.RGB {
+	if w.debug.mode == DebugTime {
+		start := time.Now()
+		w.tracer.Radiance(ray, aov)
+
+		return heatColour(float32(time.Since(start).Seconds()*1e6) / w.debug.Scale)
+	}
+
+	sg := &w.sg
+
+	*sg = ShaderGlobals{
+		X:       ray.X,
+		Y:       ray.Y,
+		Ro:      ray.Ray.P,
+		Rd:      ray.Ray.D,
+		RayType: uint16(ray.Type),
+		Depth:   ray.Level,
+		rnd:     ray.rnd,
+		Lambda:  ray.Lambda,
+		Time:    ray.Time,
+		aov:     aov,
+		pool:    ray.pool,
+	}
+
+	hit := TraceProbe(ray, sg) && sg.Shader != nil
+
+	if w.debug.mode == DebugCost {
+		// Misses can be expensive too.
+		return heatColour(float32(ray.Stats.Nnodes) / w.debug.Scale)
+	}
+
+	if !hit {
+		return colour.RGB{}
+	}
+
+	sg.ElemID = ray.Result.ElemID
+	sg.Rl = float64(m.Vec3Length(m.Vec3Sub(sg.P, sg.Ro)))
+	sg.writeBuiltinAOVs()
+
+	return DebugColour(w.debug.mode, sg)
+}
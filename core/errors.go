@@ -17,4 +17,7 @@ var (
 	ErrNoSample = errors.New("No sample")
 
 	ErrNoCamera = errors.New("No camera")
+
+	ErrCrop     = errors.New("Crop window is invalid or outside the image")
+	ErrOverscan = errors.New("Overscan needs 1 or 4 non-negative values")
 )
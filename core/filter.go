@@ -0,0 +1,160 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"errors"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"strings"
+)
+
+// ErrUnknownFilter is returned by NewFilter if the filter name isn't recognised.
+var ErrUnknownFilter = errors.New("Unknown filter")
+
+// Filter represents a separable pixel reconstruction filter.  Samples are splatted into
+// every pixel whose centre is within Radius of the sample position.
+type Filter interface {
+	// Radius returns the half-width of the filter support in pixels.
+	Radius() float32
+
+	// Eval returns the filter weight for a sample at offset x,y (in pixels) from a pixel
+	// centre. May be negative for filters with negative lobes.
+	Eval(x, y float32) float32
+}
+
+// NewFilter returns the filter with the given name ("box", "triangle", "gaussian",
+// "mitchell" or "blackman-harris") and total width in pixels.  A width of 0 selects the
+// default width for the filter.
+func NewFilter(name string, width float32) (Filter, error) {
+	switch strings.ToLower(name) {
+	case "", "box":
+		return &BoxFilter{defaultWidth(width, 1) / 2}, nil
+	case "triangle", "tent":
+		return &TriangleFilter{defaultWidth(width, 2) / 2}, nil
+	case "gaussian":
+		return NewGaussianFilter(defaultWidth(width, 2)/2, 2), nil
+	case "mitchell", "mitchell-netravali":
+		return &MitchellFilter{defaultWidth(width, 4) / 2, 1.0 / 3, 1.0 / 3}, nil
+	case "blackman-harris", "blackmanharris":
+		return &BlackmanHarrisFilter{defaultWidth(width, 3) / 2}, nil
+	}
+
+	return nil, ErrUnknownFilter
+}
+
+func defaultWidth(width, def float32) float32 {
+	if width <= 0 {
+		return def
+	}
+	return width
+}
+
+// BoxFilter weights all samples within the radius equally.
+type BoxFilter struct {
+	R float32
+}
+
+// Radius implements Filter.
+func (f *BoxFilter) Radius() float32 { return f.R }
+
+// Eval implements Filter.
+func (f *BoxFilter) Eval(x, y float32) float32 {
+	if m.Abs(x) <= f.R && m.Abs(y) <= f.R {
+		return 1
+	}
+	return 0
+}
+
+// TriangleFilter is the separable tent filter, falling linearly to zero at the radius.
+type TriangleFilter struct {
+	R float32
+}
+
+// Radius implements Filter.
+func (f *TriangleFilter) Radius() float32 { return f.R }
+
+// Eval implements Filter.
+func (f *TriangleFilter) Eval(x, y float32) float32 {
+	return m.Max(0, f.R-m.Abs(x)) * m.Max(0, f.R-m.Abs(y))
+}
+
+// GaussianFilter is a truncated Gaussian, offset so that it reaches zero at the radius.
+type GaussianFilter struct {
+	R     float32
+	Alpha float32 // Falloff rate
+	expR  float32 // Value of the gaussian at the radius
+}
+
+// NewGaussianFilter returns a Gaussian filter with the given radius and falloff.
+func NewGaussianFilter(radius, alpha float32) *GaussianFilter {
+	return &GaussianFilter{radius, alpha, float32(math.Exp(float64(-alpha * radius * radius)))}
+}
+
+func (f *GaussianFilter) gaussian(x float32) float32 {
+	return m.Max(0, float32(math.Exp(float64(-f.Alpha*x*x)))-f.expR)
+}
+
+// Radius implements Filter.
+func (f *GaussianFilter) Radius() float32 { return f.R }
+
+// Eval implements Filter.
+func (f *GaussianFilter) Eval(x, y float32) float32 {
+	return f.gaussian(x) * f.gaussian(y)
+}
+
+// MitchellFilter is the Mitchell-Netravali cubic filter with parameters B and C.
+type MitchellFilter struct {
+	R    float32
+	B, C float32
+}
+
+func (f *MitchellFilter) mitchell(x float32) float32 {
+	x = m.Abs(2 * x / f.R) // Map onto [0,2]
+
+	if x > 2 {
+		return 0
+	}
+
+	if x > 1 {
+		return ((-f.B-6*f.C)*x*x*x + (6*f.B+30*f.C)*x*x +
+			(-12*f.B-48*f.C)*x + (8*f.B + 24*f.C)) * (1.0 / 6)
+	}
+
+	return ((12-9*f.B-6*f.C)*x*x*x + (-18+12*f.B+6*f.C)*x*x +
+		(6 - 2*f.B)) * (1.0 / 6)
+}
+
+// Radius implements Filter.
+func (f *MitchellFilter) Radius() float32 { return f.R }
+
+// Eval implements Filter.
+func (f *MitchellFilter) Eval(x, y float32) float32 {
+	return f.mitchell(x) * f.mitchell(y)
+}
+
+// BlackmanHarrisFilter is the 4-term Blackman-Harris window.
+type BlackmanHarrisFilter struct {
+	R float32
+}
+
+func (f *BlackmanHarrisFilter) window(x float32) float32 {
+	if m.Abs(x) > f.R {
+		return 0
+	}
+
+	// Window is defined over [0,1], centred on 0.5
+	t := 2 * math.Pi * (0.5 + float64(x)/float64(2*f.R))
+
+	return float32(0.35875 - 0.48829*math.Cos(t) + 0.14128*math.Cos(2*t) - 0.01168*math.Cos(3*t))
+}
+
+// Radius implements Filter.
+func (f *BlackmanHarrisFilter) Radius() float32 { return f.R }
+
+// Eval implements Filter.
+func (f *BlackmanHarrisFilter) Eval(x, y float32) float32 {
+	return f.window(x) * f.window(y)
+}
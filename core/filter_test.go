@@ -0,0 +1,156 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"testing"
+)
+
+func TestFilterDefaultWidths(t *testing.T) {
+	tests := []struct {
+		name   string
+		width  float32
+		radius float32
+	}{
+		{"", 0, 0.5},
+		{"box", 0, 0.5},
+		{"triangle", 0, 1},
+		{"gaussian", 0, 1},
+		{"mitchell", 0, 2},
+		{"blackman-harris", 0, 1.5},
+		{"Mitchell", 3, 1.5},
+		{"tent", 5, 2.5},
+	}
+
+	for _, test := range tests {
+		f, err := NewFilter(test.name, test.width)
+
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if r := f.Radius(); r != test.radius {
+			t.Errorf("%v width %v: radius %v, want %v", test.name, test.width, r, test.radius)
+		}
+	}
+
+	if _, err := NewFilter("sinc", 0); err != ErrUnknownFilter {
+		t.Errorf("unknown filter: %v", err)
+	}
+}
+
+func TestFilterSupport(t *testing.T) {
+	for _, name := range []string{"box", "triangle", "gaussian", "mitchell", "blackman-harris"} {
+		for _, width := range []float32{0, 1, 2.5, 4} {
+			f, _ := NewFilter(name, width)
+			r := f.Radius()
+			peak := f.Eval(0, 0)
+
+			if !(peak > 0) {
+				t.Errorf("%v width %v: Eval(0,0) = %v", name, width, peak)
+			}
+
+			for _, x := range []float32{-1.5, -1.01, -0.75, -0.5, -0.1, 0.1, 0.5, 0.75, 1.01, 1.5} {
+				for _, y := range []float32{0, 0.3, 1.2} {
+					v := f.Eval(x*r, y*r)
+
+					if (m.Abs(x) > 1 || y > 1) && v != 0 {
+						t.Errorf("%v width %v: Eval(%v,%v) = %v outside the radius", name, width, x*r, y*r, v)
+					}
+
+					if v > peak {
+						t.Errorf("%v width %v: Eval(%v,%v) = %v above the peak %v", name, width, x*r, y*r, v, peak)
+					}
+
+					if f.Eval(-x*r, y*r) != v || f.Eval(y*r, x*r) != v {
+						t.Errorf("%v width %v: not symmetric at %v,%v", name, width, x*r, y*r)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestFilterValues compares with the filters of PBRT (3rd ed., sec. 7.8) at the default widths,
+// the Mitchell filter maps x onto [0,2] with 2*x/R.
+func TestFilterValues(t *testing.T) {
+	e2 := math.Exp(-2)
+
+	tests := []struct {
+		name string
+		x, y float32
+		want float64
+	}{
+		{"box", 0.5, -0.5, 1},
+		{"box", 0.3, 0.1, 1},
+		{"triangle", 0, 0, 1},
+		{"triangle", 0.5, 0, 0.5},
+		{"triangle", 0.5, 0.25, 0.5 * 0.75},
+		{"gaussian", 0, 0, (1 - e2) * (1 - e2)},
+		{"gaussian", 0.5, 0, (math.Exp(-0.5) - e2) * (1 - e2)},
+		{"gaussian", 1, 0, 0},
+		{"mitchell", 0, 0, (8.0 / 9) * (8.0 / 9)},
+		{"mitchell", 1, 0, (1.0 / 18) * (8.0 / 9)},     // 2x/R = 1, B/6
+		{"mitchell", 1.5, 0, (-5.0 / 144) * (8.0 / 9)}, // Negative lobe
+		{"mitchell", 2, 0, 0},
+		{"blackman-harris", 0, 0, 1},
+		{"blackman-harris", 0.75, 0, 0.35875 - 0.14128}, // Half way to the radius
+		{"blackman-harris", -0.75, 0.75, (0.35875 - 0.14128) * (0.35875 - 0.14128)},
+		{"blackman-harris", 1.5, 0, 0.35875 - 0.48829 + 0.14128 - 0.01168},
+	}
+
+	for _, test := range tests {
+		f, _ := NewFilter(test.name, 0)
+
+		if v := f.Eval(test.x, test.y); math.Abs(float64(v)-test.want) > 1e-5 {
+			t.Errorf("%v: Eval(%v,%v) = %v, want %v", test.name, test.x, test.y, v, test.want)
+		}
+	}
+}
+
+// TestFilterTileSplat checks that samples near a tile edge give the same weights when
+// splatted into the tile with its border and merged as when splatted into the frame.
+func TestFilterTileSplat(t *testing.T) {
+	const size = 8
+
+	for _, name := range []string{"box", "triangle", "gaussian", "mitchell", "blackman-harris"} {
+		for _, width := range []float32{0, 3, 5} {
+			f, _ := NewFilter(name, width)
+			border := int(math.Ceil(float64(f.Radius())))
+
+			frame := NewFrameBuffer(2*size, 2*size, nil)
+			merged := NewFrameBuffer(2*size, 2*size, nil)
+			tile := NewFrameBuffer(size+2*border, size+2*border, nil)
+
+			for _, s := range [][2]float32{{7.9, 8.2}, {7.5, 7.5}, {0.1, 15.9}, {8.0, 3.3}} {
+				x, y := int(s[0]), int(s[1])
+				c := colour.RGB{1, 2, 3}
+
+				frame.Splat(x, y, s[0], s[1], c, nil, f)
+
+				// The tile containing the sample.
+				tile.Reset(x/size*size-border, y/size*size-border, size+2*border, size+2*border)
+				tile.Splat(x, y, s[0], s[1], c, nil, f)
+				merged.Merge(tile)
+			}
+
+			for i := range frame.Weight {
+				if frame.Weight[i] != merged.Weight[i] || frame.RGB[i*3] != merged.RGB[i*3] || frame.Samples[i] != merged.Samples[i] {
+					t.Errorf("%v width %v: pixel %v,%v weight %v merged %v", name, width, i%(2*size), i/(2*size), frame.Weight[i], merged.Weight[i])
+					break
+				}
+			}
+
+			// Samples near the edge reach the next tile.
+			if width >= 3 && frame.Weight[size+size*2*size] == 0 {
+				t.Errorf("%v width %v: no weight across the tile edge", name, width)
+			}
+		}
+	}
+}
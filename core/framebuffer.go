@@ -0,0 +1,423 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// FrameBuffer accumulates unclamped linear radiance samples for a rectangle of the frame.
+// Each pixel stores the weighted sum of its samples along with the total weight and number
+// of samples, the pixel value is the weighted sum divided by the total weight.
+//
+// The rectangle starts at X,Y in frame (raster) coordinates, all methods take frame
+// coordinates.  Tiles are rendered into their own FrameBuffer (including a border for
+// the filter) which is then merged into the frame.
+//
+// Any AOVs are stored interleaved in AOV, filtered AOVs share Weight with the beauty image.
+//
+// Bidirectional integrators also splat the contributions of light paths into Light, these
+// may land on any pixel.  They are recorded by the tile being rendered and added to the frame
+// when the tile is merged.  Each light path estimates the whole image so the pixel value adds
+// Light scaled by the number of pixels over the number of light paths traced.  Integrators
+// that keep their own per-pixel estimates set Light directly with LightPaths equal to the
+// number of pixels.
+//
+// The sums of the luminance of each pixel's own samples and of its square (unfiltered) give
+// the noise estimate for adaptive sampling.
+type FrameBuffer struct {
+	X, Y       int
+	W, H       int
+	RGB        []float32 // Weighted sum of linear RGB radiance, 3 floats per pixel
+	Weight     []float32 // Sum of sample weights
+	Samples    []uint32  // Number of samples taken
+	Lum        []float32 // Sum of sample luminance
+	Lum2       []float32 // Sum of squared sample luminance
+	AOV        []float32 // AOV values, stride floats per pixel
+	Light      []float32 // Sum of light path contributions, 3 floats per pixel
+	LightPaths uint64    // Number of light paths traced
+
+	aovs      []*AOV
+	stride    int
+	aovWeight []float32    // Highest filter weight seen for the closest AOVs
+	splats    []lightSplat // Light contributions recorded by SplatLight
+
+	// Rejected counts samples that were discarded as not finite (NaN or Inf).
+	Rejected uint64
+
+	mu sync.Mutex // Held while merging tiles
+}
+
+// NewFrameBuffer allocates an empty frame buffer of the given size with storage for aovs.
+func NewFrameBuffer(w, h int, aovs []*AOV) *FrameBuffer {
+	fb := &FrameBuffer{
+		W:       w,
+		H:       h,
+		RGB:     make([]float32, w*h*3),
+		Weight:  make([]float32, w*h),
+		Samples: make([]uint32, w*h),
+		Lum:     make([]float32, w*h),
+		Lum2:    make([]float32, w*h),
+		Light:   make([]float32, w*h*3),
+		aovs:    aovs,
+	}
+
+	for _, aov := range aovs {
+		fb.stride += aov.Type.Components()
+	}
+
+	if fb.stride > 0 {
+		fb.AOV = make([]float32, w*h*fb.stride)
+		fb.aovWeight = make([]float32, w*h)
+	}
+
+	return fb
+}
+
+// Reset clears the buffer and moves it to cover the rectangle at x,y of size w,h.  The
+// buffer must have been allocated with at least w*h pixels.
+func (fb *FrameBuffer) Reset(x, y, w, h int) {
+	fb.X, fb.Y = x, y
+	fb.W, fb.H = w, h
+
+	for i := range fb.RGB[:w*h*3] {
+		fb.RGB[i] = 0
+		fb.Light[i] = 0
+	}
+
+	fb.LightPaths = 0
+	fb.splats = fb.splats[:0]
+
+	for i := range fb.Weight[:w*h] {
+		fb.Weight[i] = 0
+		fb.Samples[i] = 0
+		fb.Lum[i] = 0
+		fb.Lum2[i] = 0
+	}
+
+	if fb.stride > 0 {
+		for i := range fb.AOV[:w*h*fb.stride] {
+			fb.AOV[i] = 0
+		}
+
+		for i := range fb.aovWeight[:w*h] {
+			fb.aovWeight[i] = 0
+		}
+	}
+}
+
+// Contains returns true if the pixel x,y is inside the buffer.
+func (fb *FrameBuffer) Contains(x, y int) bool {
+	return x >= fb.X && y >= fb.Y && x < fb.X+fb.W && y < fb.Y+fb.H
+}
+
+func isFinite(v float32) bool {
+	return !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0)
+}
+
+// AddSample accumulates the radiance c with the given weight into pixel x,y.  Samples
+// containing NaN or Inf are rejected.  Not safe for concurrent use on the same pixel.
+func (fb *FrameBuffer) AddSample(x, y int, c colour.RGB, weight float32) {
+	idx := (x - fb.X) + (y-fb.Y)*fb.W
+
+	if !isFinite(c[0]) || !isFinite(c[1]) || !isFinite(c[2]) {
+		atomic.AddUint64(&fb.Rejected, 1)
+		return
+	}
+
+	fb.RGB[idx*3+0] += c[0] * weight
+	fb.RGB[idx*3+1] += c[1] * weight
+	fb.RGB[idx*3+2] += c[2] * weight
+	fb.Weight[idx] += weight
+	fb.count(idx, c)
+}
+
+// count counts the sample c against pixel index idx.
+func (fb *FrameBuffer) count(idx int, c colour.RGB) {
+	l := c.Luminance()
+
+	fb.Samples[idx]++
+	fb.Lum[idx] += l
+	fb.Lum2[idx] += l * l
+}
+
+// Splat accumulates the radiance c for a sample taken at raster position sx,sy into every
+// pixel of the buffer within the support of filter.  The sample is counted against pixel
+// x,y which should be the pixel the sample was generated for.  If aov is non-nil the AOV
+// values are accumulated in the same way.
+func (fb *FrameBuffer) Splat(x, y int, sx, sy float32, c colour.RGB, aov *AOVSample, filter Filter) {
+	if !isFinite(c[0]) || !isFinite(c[1]) || !isFinite(c[2]) {
+		atomic.AddUint64(&fb.Rejected, 1)
+		return
+	}
+
+	if fb.Contains(x, y) {
+		fb.count((x-fb.X)+(y-fb.Y)*fb.W, c)
+	}
+
+	r := filter.Radius()
+
+	// Pixel centres are at i+0.5
+	x0 := int(m.Ceil(sx - 0.5 - r))
+	x1 := int(m.Floor(sx - 0.5 + r))
+	y0 := int(m.Ceil(sy - 0.5 - r))
+	y1 := int(m.Floor(sy - 0.5 + r))
+
+	if x0 < fb.X {
+		x0 = fb.X
+	}
+	if y0 < fb.Y {
+		y0 = fb.Y
+	}
+	if x1 >= fb.X+fb.W {
+		x1 = fb.X + fb.W - 1
+	}
+	if y1 >= fb.Y+fb.H {
+		y1 = fb.Y + fb.H - 1
+	}
+
+	for j := y0; j <= y1; j++ {
+		for i := x0; i <= x1; i++ {
+			weight := filter.Eval(float32(i)+0.5-sx, float32(j)+0.5-sy)
+
+			if weight == 0 {
+				continue
+			}
+
+			idx := (i - fb.X) + (j-fb.Y)*fb.W
+
+			fb.RGB[idx*3+0] += c[0] * weight
+			fb.RGB[idx*3+1] += c[1] * weight
+			fb.RGB[idx*3+2] += c[2] * weight
+			fb.Weight[idx] += weight
+
+			if aov != nil && fb.stride > 0 {
+				fb.splatAOV(idx, aov, weight)
+			}
+		}
+	}
+}
+
+// lightSplat is a light path contribution recorded by SplatLight.
+type lightSplat struct {
+	x, y int
+	c    colour.RGB
+}
+
+// SplatLight records the light path contribution c to pixel x,y, which may be outside the
+// buffer.  The contributions are added to Light of the buffer the tile is merged into, in the
+// order they were recorded, so the sums don't depend on which goroutines rendered the tiles.
+func (fb *FrameBuffer) SplatLight(x, y int, c colour.RGB) {
+	if !isFinite(c[0]) || !isFinite(c[1]) || !isFinite(c[2]) {
+		atomic.AddUint64(&fb.Rejected, 1)
+		return
+	}
+
+	fb.splats = append(fb.splats, lightSplat{x, y, c})
+}
+
+// SetLight sets the light contribution of pixel x,y to c, pixels outside the buffer are
+// ignored.
+func (fb *FrameBuffer) SetLight(x, y int, c colour.RGB) {
+	if !fb.Contains(x, y) {
+		return
+	}
+
+	idx := (x - fb.X) + (y-fb.Y)*fb.W
+
+	copy(fb.Light[idx*3:idx*3+3], c[:])
+}
+
+// AddLightPaths counts n light paths towards the normalization of the splatted light.
+// Safe for concurrent use.
+func (fb *FrameBuffer) AddLightPaths(n uint64) {
+	atomic.AddUint64(&fb.LightPaths, n)
+}
+
+// lightScale returns the scale for the splatted light contributions.
+func (fb *FrameBuffer) lightScale() float32 {
+	if n := atomic.LoadUint64(&fb.LightPaths); n > 0 {
+		return float32(fb.W*fb.H) / float32(n)
+	}
+	return 0
+}
+
+func (fb *FrameBuffer) splatAOV(idx int, aov *AOVSample, weight float32) {
+	closest := weight > fb.aovWeight[idx]
+
+	if closest {
+		fb.aovWeight[idx] = weight
+	}
+
+	dst := fb.AOV[idx*fb.stride:]
+
+	for _, a := range fb.aovs {
+		for k := a.offset; k < a.offset+a.Type.Components(); k++ {
+			if !a.Closest {
+				dst[k] += aov.Values[k] * weight
+			} else if closest {
+				dst[k] = aov.Values[k]
+			}
+		}
+	}
+}
+
+// Merge adds the contents of the buffer tile into fb, including the light splats recorded by
+// tile.  Pixels outside of fb are ignored.  Safe for concurrent use.
+func (fb *FrameBuffer) Merge(tile *FrameBuffer) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	for j := 0; j < tile.H; j++ {
+		y := tile.Y + j
+
+		if y < fb.Y || y >= fb.Y+fb.H {
+			continue
+		}
+
+		for i := 0; i < tile.W; i++ {
+			x := tile.X + i
+
+			if x < fb.X || x >= fb.X+fb.W {
+				continue
+			}
+
+			src := i + j*tile.W
+			dst := (x - fb.X) + (y-fb.Y)*fb.W
+
+			fb.RGB[dst*3+0] += tile.RGB[src*3+0]
+			fb.RGB[dst*3+1] += tile.RGB[src*3+1]
+			fb.RGB[dst*3+2] += tile.RGB[src*3+2]
+			fb.Weight[dst] += tile.Weight[src]
+			fb.Samples[dst] += tile.Samples[src]
+			fb.Lum[dst] += tile.Lum[src]
+			fb.Lum2[dst] += tile.Lum2[src]
+
+			if fb.stride > 0 {
+				fb.mergeAOV(dst, tile, src)
+			}
+		}
+	}
+
+	for _, sp := range tile.splats {
+		if !fb.Contains(sp.x, sp.y) {
+			continue
+		}
+
+		dst := (sp.x - fb.X) + (sp.y-fb.Y)*fb.W
+
+		fb.Light[dst*3+0] += sp.c[0]
+		fb.Light[dst*3+1] += sp.c[1]
+		fb.Light[dst*3+2] += sp.c[2]
+	}
+
+	fb.Rejected += atomic.SwapUint64(&tile.Rejected, 0)
+}
+
+func (fb *FrameBuffer) mergeAOV(dst int, tile *FrameBuffer, src int) {
+	closest := tile.aovWeight[src] > fb.aovWeight[dst]
+
+	if closest {
+		fb.aovWeight[dst] = tile.aovWeight[src]
+	}
+
+	d := fb.AOV[dst*fb.stride:]
+	s := tile.AOV[src*tile.stride:]
+
+	for _, a := range fb.aovs {
+		for k := a.offset; k < a.offset+a.Type.Components(); k++ {
+			if !a.Closest {
+				d[k] += s[k]
+			} else if closest {
+				d[k] = s[k]
+			}
+		}
+	}
+}
+
+// PixelError returns the estimated relative standard error of pixel x,y, the standard error
+// of the mean luminance of its samples over the mean.  Pixels darker than 0.01 are compared to
+// 0.01 so that nearly black pixels don't need huge numbers of samples.  Returns +Inf for pixels
+// with fewer than 2 samples.
+func (fb *FrameBuffer) PixelError(x, y int) float32 {
+	idx := (x - fb.X) + (y-fb.Y)*fb.W
+	n := float32(fb.Samples[idx])
+
+	if n < 2 {
+		return m.Inf(1)
+	}
+
+	mean := fb.Lum[idx] / n
+	variance := (fb.Lum2[idx]/n - mean*mean) * n / (n - 1)
+
+	if variance <= 0 {
+		return 0
+	}
+
+	return m.Sqrt(variance/n) / m.Max(mean, 0.01)
+}
+
+// Pixel returns the current linear radiance estimate for pixel x,y.
+func (fb *FrameBuffer) Pixel(x, y int) (c colour.RGB) {
+	idx := (x - fb.X) + (y-fb.Y)*fb.W
+
+	if w := fb.Weight[idx]; w != 0 {
+		c[0] = fb.RGB[idx*3+0] / w
+		c[1] = fb.RGB[idx*3+1] / w
+		c[2] = fb.RGB[idx*3+2] / w
+	}
+
+	scale := fb.lightScale()
+
+	c[0] += fb.Light[idx*3+0] * scale
+	c[1] += fb.Light[idx*3+1] * scale
+	c[2] += fb.Light[idx*3+2] * scale
+
+	return
+}
+
+// Resolve writes the normalized linear RGB image into out, which should have length
+// at least W*H*3.
+func (fb *FrameBuffer) Resolve(out []float32) {
+	scale := fb.lightScale()
+
+	for idx := 0; idx < fb.W*fb.H; idx++ {
+		w := fb.Weight[idx]
+
+		for k := 0; k < 3; k++ {
+			out[idx*3+k] = fb.Light[idx*3+k] * scale
+
+			if w != 0 {
+				out[idx*3+k] += fb.RGB[idx*3+k] / w
+			}
+		}
+	}
+}
+
+// ResolveAOV writes the image for aov into out, which should have length at least
+// W*H*aov.Type.Components().
+func (fb *FrameBuffer) ResolveAOV(aov *AOV, out []float32) {
+	n := aov.Type.Components()
+
+	for idx := 0; idx < fb.W*fb.H; idx++ {
+		src := fb.AOV[idx*fb.stride+aov.offset:]
+		w := fb.Weight[idx]
+
+		for k := 0; k < n; k++ {
+			switch {
+			case aov.Closest:
+				out[idx*n+k] = src[k]
+			case w != 0:
+				out[idx*n+k] = src[k] / w
+			default:
+				out[idx*n+k] = 0
+			}
+		}
+	}
+}
@@ -4,11 +4,150 @@
 
 package core
 
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"runtime"
+)
+
 // Globals is a node representing the global render settings.
 type Globals struct {
 	XRes, YRes    int
 	UseProgress   bool
-	MaxGoRoutines int
+	MaxGoRoutines int     // Render goroutines, 0 for one per CPU or negative to leave CPUs free
+	Exposure      float32 // Exposure in stops applied when tonemapping the preview
+	Filter        string  // Pixel reconstruction filter name (see NewFilter)
+	FilterWidth   float32 // Filter width in pixels, 0 for the filter default
+	Integrator    string  // Name of the Integrator node, "" for the default
+	Sampler       string  // Sample generator name (see NewSampler)
+	Seed          int     // Seed for the sampler, renders with the same seed are identical
+	TileSize      int     // Width and height of the tiles in pixels
+	BucketOrder   string  // Order tiles are rendered in each iteration (see tileOrder)
+
+	// Path depth limits, the number of bounces of each type after the first hit.  0 gives
+	// direct lighting only.
+	MaxDepth             int // Total bounces
+	MaxDiffuseDepth      int
+	MaxGlossyDepth       int // Glossy and specular reflection
+	MaxTransmissionDepth int
+	RRDepth              int // Total bounces before Russian roulette may terminate paths
+
+	// Adaptive sampling, pixels stop being sampled once the relative standard error of their
+	// samples falls below AdaptiveThreshold or they reach MaxSamples.  The render ends when
+	// every pixel has stopped or after TimeLimit.
+	AdaptiveThreshold float32 // 0 disables the noise test
+	MinSamples        int     // Samples before a pixel may pass the noise test
+	MaxSamples        int     // Samples per pixel, 0 for no limit
+	TimeLimit         float32 // Seconds, 0 for no limit
+
+	Checkpoint         string  // File to save the render state to so it can be resumed, "" for none
+	CheckpointInterval float32 // Seconds between checkpoints
+
+	// Region of the image rendered and written.  Crop is x0 y0 x1 y1 in pixels from the top
+	// left (x1 and y1 exclusive) and CropWindow the same as fractions of the image including
+	// the overscan, used if Crop isn't set.  Overscan adds pixels outside the image, either one
+	// value for every edge or left top right bottom, the crop may extend into it.
+	Crop       []int32
+	CropWindow []float32
+	Overscan   []int32
+}
+
+// NewGlobals returns Globals set to the defaults.
+func NewGlobals() *Globals {
+	return &Globals{
+		XRes:                 256,
+		YRes:                 256,
+		TileSize:             TILESIZE,
+		MaxDepth:             8,
+		MaxDiffuseDepth:      4,
+		MaxGlossyDepth:       4,
+		MaxTransmissionDepth: 8,
+		RRDepth:              3,
+		MinSamples:           16,
+		CheckpointInterval:   300,
+	}
+}
+
+// Limited returns true if the settings end the render without an iteration limit.
+func (g *Globals) Limited() bool {
+	return g.AdaptiveThreshold > 0 || g.MaxSamples > 0 || g.TimeLimit > 0
+}
+
+// Threads returns the number of render goroutines given by MaxGoRoutines.  0 uses every CPU
+// and negative values leave that many CPUs free, there is always at least one.
+func (g *Globals) Threads() int {
+	n := g.MaxGoRoutines
+
+	if n <= 0 {
+		n += runtime.NumCPU()
+	}
+
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+// window returns the region of the image to render (see Crop) as the position of its top
+// left pixel relative to the top left of the XRes x YRes image, and its size.  x and y are
+// negative for overscan at the left or top.
+func (g *Globals) window() (x, y, w, h int, err error) {
+	x0, y0, x1, y1 := 0, 0, g.XRes, g.YRes
+
+	switch len(g.Overscan) {
+	case 0:
+	case 1:
+		o := int(g.Overscan[0])
+		x0, y0, x1, y1 = x0-o, y0-o, x1+o, y1+o
+	case 4:
+		x0, y0 = x0-int(g.Overscan[0]), y0-int(g.Overscan[1])
+		x1, y1 = x1+int(g.Overscan[2]), y1+int(g.Overscan[3])
+	default:
+		return 0, 0, 0, 0, ErrOverscan
+	}
+
+	for _, o := range g.Overscan {
+		if o < 0 {
+			return 0, 0, 0, 0, ErrOverscan
+		}
+	}
+
+	switch {
+	case len(g.Crop) == 4:
+		x0, y0 = maxInt(x0, int(g.Crop[0])), maxInt(y0, int(g.Crop[1]))
+		x1, y1 = minInt(x1, int(g.Crop[2])), minInt(y1, int(g.Crop[3]))
+	case len(g.Crop) != 0:
+		return 0, 0, 0, 0, ErrCrop
+	case len(g.CropWindow) == 4:
+		c := g.CropWindow
+		ox, oy, w, h := x0, y0, float32(x1-x0), float32(y1-y0)
+		x0 = maxInt(x0, ox+int(m.Floor(c[0]*w)))
+		y0 = maxInt(y0, oy+int(m.Floor(c[1]*h)))
+		x1 = minInt(x1, ox+int(m.Ceil(c[2]*w)))
+		y1 = minInt(y1, oy+int(m.Ceil(c[3]*h)))
+	case len(g.CropWindow) != 0:
+		return 0, 0, 0, 0, ErrCrop
+	}
+
+	if x1 <= x0 || y1 <= y0 {
+		return 0, 0, 0, 0, ErrCrop
+	}
+
+	return x0, y0, x1 - x0, y1 - y0, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
 
 // Name is a node method.
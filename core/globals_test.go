@@ -0,0 +1,59 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import "testing"
+
+func TestGlobalsWindow(t *testing.T) {
+	tests := []struct {
+		name       string
+		crop       []int32
+		cropWindow []float32
+		overscan   []int32
+		x, y, w, h int
+		err        error
+	}{
+		{"full", nil, nil, nil, 0, 0, 101, 57, nil},
+		{"crop", []int32{10, 5, 60, 40}, nil, nil, 10, 5, 50, 35, nil},
+		{"crop clipped", []int32{-5, -5, 200, 100}, nil, nil, 0, 0, 101, 57, nil},
+		{"crop single pixel", []int32{100, 56, 101, 57}, nil, nil, 100, 56, 1, 1, nil},
+		{"crop window", nil, []float32{0.25, 0.5, 0.75, 1}, nil, 25, 28, 51, 29, nil},
+		{"crop window rounded out", nil, []float32{0.1, 0.1, 0.3, 0.3}, nil, 10, 5, 21, 13, nil},
+		{"crop before crop window", []int32{1, 2, 3, 4}, []float32{0.5, 0.5, 1, 1}, nil, 1, 2, 2, 2, nil},
+		{"overscan", nil, nil, []int32{3}, -3, -3, 107, 63, nil},
+		{"overscan edges", nil, nil, []int32{1, 2, 3, 4}, -1, -2, 105, 63, nil},
+		{"crop into overscan", []int32{-10, -10, 20, 20}, nil, []int32{3}, -3, -3, 23, 23, nil},
+		{"crop into overscan edges", []int32{90, 50, 110, 70}, nil, []int32{1, 2, 3, 4}, 90, 50, 14, 11, nil},
+		{"crop inside overscan", []int32{10, 10, 20, 20}, nil, []int32{5}, 10, 10, 10, 10, nil},
+		{"crop window with overscan", nil, []float32{0, 0, 1, 1}, []int32{5}, -5, -5, 111, 67, nil},
+		{"crop window of overscan edges", nil, []float32{0.5, 0.5, 1, 1}, []int32{1, 2, 3, 4}, 51, 29, 53, 32, nil},
+		{"crop window inside overscan", nil, []float32{0.25, 0.5, 0.75, 1}, []int32{5}, 22, 28, 57, 34, nil},
+		{"crop only overscan", []int32{-5, 0, 0, 57}, nil, []int32{5}, -5, 0, 5, 57, nil},
+		{"overscan count", nil, nil, []int32{1, 2}, 0, 0, 0, 0, ErrOverscan},
+		{"overscan negative", nil, nil, []int32{-1}, 0, 0, 0, 0, ErrOverscan},
+		{"crop count", []int32{1, 2, 3}, nil, nil, 0, 0, 0, 0, ErrCrop},
+		{"crop empty", []int32{50, 5, 40, 10}, nil, nil, 0, 0, 0, 0, ErrCrop},
+		{"crop outside", []int32{200, 0, 300, 10}, nil, nil, 0, 0, 0, 0, ErrCrop},
+		{"crop outside overscan", []int32{-20, 0, -6, 10}, nil, []int32{5}, 0, 0, 0, 0, ErrCrop},
+		{"crop window count", nil, []float32{0.5, 0.5}, nil, 0, 0, 0, 0, ErrCrop},
+	}
+
+	for _, test := range tests {
+		g := NewGlobals()
+		g.XRes, g.YRes = 101, 57
+		g.Crop, g.CropWindow, g.Overscan = test.crop, test.cropWindow, test.overscan
+
+		x, y, w, h, err := g.window()
+
+		if err != test.err {
+			t.Errorf("%v: error %v, want %v", test.name, err, test.err)
+			continue
+		}
+
+		if x != test.x || y != test.y || w != test.w || h != test.h {
+			t.Errorf("%v: window %v,%v %vx%v, want %v,%v %vx%v", test.name, x, y, w, h, test.x, test.y, test.w, test.h)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
+)
+
+// Integrator is implemented by nodes that compute the light arriving along camera rays.  The
+// integrator for a render is the node named by Globals.Integrator, if that is empty the first
+// integrator node in the scene is used or the path tracer if there are none.
+type Integrator interface {
+	Node
+
+	// NewWorker returns the state for one render goroutine.  Workers are never shared
+	// between goroutines.
+	NewWorker(rc *RenderContext) IntegratorWorker
+}
+
+// IntegratorWorker computes samples for an Integrator.
+type IntegratorWorker interface {
+	// Radiance returns the RGB radiance arriving at the camera along ray.  If aov is
+	// non-nil the AOVs for the first hit should be written.
+	Radiance(ray *RayData, aov *AOVSample) colour.RGB
+}
+
+// SplattingWorker is implemented by workers that splat light path contributions, which may
+// land on any pixel, with FrameBuffer.SplatLight.  StartTile is called with the buffer for each
+// tile before its pixels are sampled and the contributions must be splatted into it.  index
+// identifies the tile, it is the same in every iteration.  A tile is only rendered by one
+// goroutine at a time and every tile of an iteration is finished before the next starts.
+type SplattingWorker interface {
+	IntegratorWorker
+
+	StartTile(tile *FrameBuffer, index int)
+}
+
+// PassIntegrator is implemented by integrators that need to do work between passes over the
+// image (e.g. tracing photons).  Render calls EndPass after each iteration has sampled every
+// pixel and before the frame buffer is resolved.
+type PassIntegrator interface {
+	Integrator
+
+	// EndPass is called from Render, no workers are running.
+	EndPass(rc *RenderContext)
+}
+
+// PathIntegrator is the unidirectional path tracer with next event estimation.  Path depths
+// are set in Globals.
+type PathIntegrator struct {
+	NodeName string `node:"Name"`
+}
+
+// Name implements Node.
+func (p *PathIntegrator) Name() string { return p.NodeName }
+
+// PreRender implements Node.
+func (p *PathIntegrator) PreRender(rc *RenderContext) error { return nil }
+
+// PostRender implements Node.
+func (p *PathIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.
+func (p *PathIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	return newPathTracer(&rc.globals)
+}
+
+// DirectIntegrator computes direct lighting only.  Lights are sampled at the first hit and
+// combined with a BSDF sample, which also finds lights seen in mirror reflections.
+type DirectIntegrator struct {
+	NodeName string `node:"Name"`
+}
+
+// Name implements Node.
+func (d *DirectIntegrator) Name() string { return d.NodeName }
+
+// PreRender implements Node.
+func (d *DirectIntegrator) PreRender(rc *RenderContext) error { return nil }
+
+// PostRender implements Node.
+func (d *DirectIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.
+func (d *DirectIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	globals := rc.globals
+	globals.MaxDepth = 0
+
+	return newPathTracer(&globals)
+}
+
+// findIntegrator returns the integrator selected by the globals.
+func (rc *RenderContext) findIntegrator() (Integrator, error) {
+	if rc.globals.Integrator != "" {
+		node := rc.FindNode(rc.globals.Integrator)
+
+		if node == nil {
+			return nil, fmt.Errorf("integrator %v not found", rc.globals.Integrator)
+		}
+
+		integrator, ok := node.(Integrator)
+
+		if !ok {
+			return nil, fmt.Errorf("node %v is not an integrator", rc.globals.Integrator)
+		}
+
+		return integrator, nil
+	}
+
+	for _, node := range rc.nodes {
+		if integrator, ok := node.(Integrator); ok {
+			return integrator, nil
+		}
+	}
+
+	return &PathIntegrator{}, nil
+}
@@ -4,16 +4,120 @@
 
 package core
 
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
 // Light represents a light that can be sampled by the system.
 type Light interface {
 	//	SamplePoint(*rand.Rand, *SurfacePoint, *float64) error                                // Sample a point on the surface
 
-	// SampleArea samples a point on the surface of the light by area.
+	// SampleArea samples a point on the surface of the light by area for the shading point
+	// in sg.  On success sets sg.Ld and sg.Ldist to the direction and distance to the point,
+	// sg.Liu to the emitted radiance, sg.Lpdf to the PDF with respect to solid angle and
+	// sg.Weight to 1/sg.Lpdf.  Lights made of several elements (e.g. mesh lights) set
+	// sg.Lelem to the element the point is on.
 	// Returns nil on successful sample.
 	SampleArea(*ShaderGlobals) error
 
-	//	SampleDirection(*SurfacePoint, *rand.Rand, *m.Vec3, *colour.Spectrum, *float64) error // Sample direction given point
+	// PDF returns the solid angle PDF with which SampleArea would choose the point sg.P when
+	// sampling from sg.Ro, or 0 if sg.P isn't on the light.  sg is set up from a ray hit.
+	PDF(sg *ShaderGlobals) float32
+
+	// SampleEmission samples a ray leaving the light, for integrators that trace paths from
+	// the lights.  sg gives the wavelength, time and random number generator.  Returns nil
+	// on success.
+	SampleEmission(sg *ShaderGlobals, ls *LightSample) error
+
+	// EvalEmission sets ls.N and ls.Le to the normal and the radiance leaving the point ls.P
+	// (which must be on the light, element ls.ElemID) in direction ls.D, and ls.PdfA, ls.PdfD
+	// to the PDFs with which SampleEmission would have chosen them.
+	EvalEmission(sg *ShaderGlobals, ls *LightSample)
 
 	// DiffuseShadeMult returns the diffuse lighting multiplier.
 	DiffuseShadeMult() float32
 }
+
+// DeltaLight is implemented by lights that rays can't hit, such as point and distant lights.
+// They can only be found by sampling so integrators don't weight light samples against BSDF
+// samples and PDF returns 0.  Lights with no size set sg.Lpdf and sg.Weight to 1 in
+// SampleArea and sg.Liu to the irradiance arriving at the point.
+type DeltaLight interface {
+	Light
+
+	// Delta returns true if rays can't hit the light.
+	Delta() bool
+}
+
+// isDelta returns true if light is a DeltaLight that rays can't hit.
+func isDelta(light Light) bool {
+	d, ok := light.(DeltaLight)
+	return ok && d.Delta()
+}
+
+// BackgroundLight is implemented by lights at infinity, such as environment maps, which are
+// seen by rays that leave the scene.  EvalEmission ignores ls.P and returns the radiance
+// arriving from direction -ls.D in ls.Le and the solid angle PDF with which SampleArea would
+// choose that direction in ls.PdfD.  SampleEmission chooses the direction first and then a
+// point uniformly on the disk facing it that covers the scene (ls.PdfA).  SampleArea sets
+// sg.Ldist to a point outside the scene.  PDF returns 0 as rays can't hit the light.
+type BackgroundLight interface {
+	Light
+
+	// Background returns the radiance arriving along the ray in sg, which left the scene.
+	// camera is true for rays from the camera.
+	Background(sg *ShaderGlobals, camera bool) colour.Spectrum
+}
+
+// isBackground returns true if light is a BackgroundLight.
+func isBackground(light Light) bool {
+	_, ok := light.(BackgroundLight)
+	return ok
+}
+
+// background returns the radiance from the background lights arriving along the ray in sg,
+// which left the scene.  If bsdfPdf isn't 0 the ray was BSDF sampled with that solid angle PDF
+// and the radiance of each light is weighted against that light's samples with the power
+// heuristic, as the lights are sampled separately.
+func background(sg *ShaderGlobals, camera bool, bsdfPdf float32) (L colour.Spectrum) {
+	L.Lambda = sg.Lambda
+
+	ls := LightSample{D: m.Vec3Neg(m.Vec3Normalize(sg.Rd))}
+
+	for _, light := range grc.scene.background {
+		Le := light.Background(sg, camera)
+
+		if bsdfPdf != 0 {
+			light.EvalEmission(sg, &ls)
+			Le.Scale(PowerHeuristic(bsdfPdf, ls.PdfD))
+		}
+
+		L.Add(Le)
+	}
+
+	return
+}
+
+// LightSample is a ray leaving a light.
+type LightSample struct {
+	P, N m.Vec3          // Point on the light and its normal
+	D    m.Vec3          // Direction leaving the light
+	Le   colour.Spectrum // Radiance leaving P in direction D
+	PdfA float32         // PDF of P with respect to area
+	PdfD float32         // PDF of D with respect to solid angle
+
+	ElemID uint32 // Element of the light P is on, for lights made of several elements
+}
+
+// emissionRay initialises ray to leave the light along ls, starting just off the light to avoid
+// hitting it again.
+func emissionRay(ray *RayData, ls *LightSample, sg *ShaderGlobals) {
+	offset := m.Vec3Scale(1e-4*(1+m.Vec3Length(ls.P)), ls.N)
+
+	if m.Vec3Dot(ls.D, ls.N) < 0 {
+		offset = m.Vec3Neg(offset)
+	}
+
+	ray.Init(RayDiffuse, m.Vec3Add(ls.P, offset), ls.D, m.Inf(1), sg)
+}
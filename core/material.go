@@ -16,8 +16,21 @@ type Material interface {
 	//ApplyBumpMap(surf *SurfacePoint)
 	HasBumpMap() bool
 
+	// HasEDF returns true if the material is emissive.
+	HasEDF() bool
 	Emission(sg *ShaderGlobals, omegaO m.Vec3) colour.RGB
 
 	// Eval evaluates the shader and returns values in sh.OutXXX members.
 	Eval(sg *ShaderGlobals)
+
+	// Closure sets up c with the BSDF lobes for the surface point in sg.  Used by the path
+	// tracer instead of Eval.
+	Closure(sg *ShaderGlobals, c *Closure)
+}
+
+// FlatShader is implemented by materials that only use emission to appear flat shaded, such
+// as debug shaders.  Their emission isn't light so their surfaces aren't sampled as lights.
+type FlatShader interface {
+	Material
+	FlatShaded()
 }
@@ -0,0 +1,306 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"math"
+	"sort"
+)
+
+// meshLight samples the emissive triangles of a TriangleMesh.  Triangles are chosen in
+// proportion to their power (area times the estimated average emission).  Every triangle
+// is given at least a tenth of the mean emission as the estimate can miss details in
+// textures.  Points are uniform within a triangle.
+type meshLight struct {
+	mesh  TriangleMesh
+	tris  []int32    // Emissive triangles
+	index []int32    // Position in tris of each triangle of the mesh, -1 if not emissive
+	mtls  []Material // Material of each emissive triangle
+	area  []float32  // Area of each emissive triangle
+	cdf   []float64  // Probability of choosing tris[0..i]
+}
+
+// Barycentric coordinates of the points the average emission of a triangle is estimated from.
+var powerPoints = [...][2]float32{{1.0 / 3, 1.0 / 3}, {2.0 / 3, 1.0 / 6}, {1.0 / 6, 2.0 / 3}, {1.0 / 6, 1.0 / 6}}
+
+// newMeshLight returns the light for the emissive triangles of mesh, or nil if there are none.
+// Triangles with a FlatShader material aren't emissive.
+func newMeshLight(mesh TriangleMesh) *meshLight {
+	l := &meshLight{mesh: mesh, index: make([]int32, mesh.NumTriangles())}
+
+	var emission []float64
+	var sg ShaderGlobals
+
+	for i := range l.index {
+		l.index[i] = -1
+
+		V, mtlid := mesh.Triangle(i)
+		mtl := GetMaterial(mtlid)
+
+		if mtl == nil || !mtl.HasEDF() {
+			continue
+		}
+
+		if _, ok := mtl.(FlatShader); ok {
+			continue
+		}
+
+		area := 0.5 * m.Vec3Length(m.Vec3Cross(m.Vec3Sub(V[1], V[0]), m.Vec3Sub(V[2], V[0])))
+
+		if !(area > 0) {
+			continue
+		}
+
+		E := 0.0
+
+		for _, b := range powerPoints {
+			sg = ShaderGlobals{}
+			mesh.SetupTriangle(i, b[0], b[1], &sg)
+			E += float64(mtl.Emission(&sg, m.Vec3{0, 0, 1}).Luminance())
+		}
+
+		l.index[i] = int32(len(l.tris))
+		l.tris = append(l.tris, int32(i))
+		l.mtls = append(l.mtls, mtl)
+		l.area = append(l.area, area)
+		emission = append(emission, E/float64(len(powerPoints)))
+	}
+
+	if len(l.tris) == 0 {
+		return nil
+	}
+
+	var totalArea, totalPower float64
+
+	for j, E := range emission {
+		totalArea += float64(l.area[j])
+		totalPower += float64(l.area[j]) * E
+	}
+
+	minE := 0.1 * totalPower / totalArea
+
+	if !(minE > 0) {
+		minE = 1 // Black wherever it was estimated, choose by area.
+	}
+
+	l.cdf = make([]float64, len(emission))
+
+	sum := 0.0
+
+	for j, E := range emission {
+		sum += float64(l.area[j]) * math.Max(E, minE)
+		l.cdf[j] = sum
+	}
+
+	for j := range l.cdf {
+		l.cdf[j] /= sum
+	}
+
+	l.cdf[len(l.cdf)-1] = 1
+
+	return l
+}
+
+// initMeshLights adds a light for each TriangleMesh with emissive triangles.
+func (scene *Scene) initMeshLights() {
+	for _, prim := range scene.prims {
+		if mesh, ok := prim.(TriangleMesh); ok {
+			if l := newMeshLight(mesh); l != nil {
+				scene.lights = append(scene.lights, l)
+			}
+		}
+	}
+}
+
+// prob returns the probability of choosing emissive triangle j.
+func (l *meshLight) prob(j int) float32 {
+	if j == 0 {
+		return float32(l.cdf[0])
+	}
+
+	return float32(l.cdf[j] - l.cdf[j-1])
+}
+
+// pick returns the emissive triangle chosen by u.
+func (l *meshLight) pick(u float64) int {
+	j := sort.SearchFloat64s(l.cdf, u)
+
+	if j == len(l.cdf) {
+		j--
+	}
+
+	return j
+}
+
+// elem returns the position in l.tris of triangle i, or -1 if it isn't emissive.
+func (l *meshLight) elem(i uint32) int {
+	if int(i) >= len(l.index) {
+		return -1
+	}
+
+	return int(l.index[i])
+}
+
+// barycentric returns the barycentric coordinates of P on the triangle V.
+func barycentric(V [3]m.Vec3, P m.Vec3) (b0, b1 float32) {
+	e0 := m.Vec3Sub(V[0], V[2])
+	e1 := m.Vec3Sub(V[1], V[2])
+	d := m.Vec3Sub(P, V[2])
+
+	a00, a01, a11 := float64(m.Vec3Dot(e0, e0)), float64(m.Vec3Dot(e0, e1)), float64(m.Vec3Dot(e1, e1))
+	c0, c1 := float64(m.Vec3Dot(e0, d)), float64(m.Vec3Dot(e1, d))
+	det := a00*a11 - a01*a01
+
+	if det == 0 {
+		return 1.0 / 3, 1.0 / 3
+	}
+
+	u := math.Min(math.Max((a11*c0-a01*c1)/det, 0), 1)
+	v := math.Min(math.Max((a00*c1-a01*c0)/det, 0), 1-u)
+
+	return float32(u), float32(v)
+}
+
+// front returns the geometric normal of the light point in lsg turned to the side that emits.
+func front(lsg *ShaderGlobals) m.Vec3 {
+	if m.Vec3Dot(lsg.Ng, lsg.N) < 0 {
+		return m.Vec3Neg(lsg.Ng)
+	}
+
+	return lsg.Ng
+}
+
+// DiffuseShadeMult implements Light.
+func (l *meshLight) DiffuseShadeMult() float32 { return 1.0 }
+
+// SampleArea implements Light.
+func (l *meshLight) SampleArea(sg *ShaderGlobals) error {
+	j := l.pick(sg.Rand().Float64())
+	b0, b1 := sample.UniformTriangle(sg.Rand().Float64(), sg.Rand().Float64())
+
+	lsg := sg.pool.shaderGlobals()
+	defer sg.pool.putShaderGlobals(lsg)
+
+	l.mesh.SetupTriangle(int(l.tris[j]), b0, b1, lsg)
+
+	V := m.Vec3Sub(lsg.P, sg.P)
+	dist2 := m.Vec3Length2(V)
+
+	if dist2 == 0 {
+		return ErrNoSample
+	}
+
+	sg.Ldist = m.Sqrt(dist2)
+	sg.Ld = m.Vec3Scale(1/sg.Ldist, V)
+
+	cos := m.Abs(m.Vec3Dot(m.Vec3Normalize(lsg.Ng), sg.Ld))
+
+	if cos == 0 {
+		return ErrNoSample
+	}
+
+	lsg.Ro, lsg.Rd = sg.P, sg.Ld
+	lsg.Lambda, lsg.Time = sg.Lambda, sg.Time
+
+	E := l.mtls[j].Emission(lsg, lsg.WorldToTangent(m.Vec3Neg(sg.Ld)))
+
+	if E.Maxh() <= 0 {
+		// Back face or black texel, the PDF still counts the point for MIS.
+		return ErrNoSample
+	}
+
+	pdf := l.prob(j) / l.area[j] * dist2 / cos
+
+	sg.Liu.Lambda = sg.Lambda
+	sg.Liu.FromRGB(E[0], E[1], E[2])
+	sg.Lpdf = pdf
+	sg.Weight = 1 / pdf
+	sg.Lelem = uint32(l.tris[j])
+
+	return nil
+}
+
+// PDF implements Light.
+func (l *meshLight) PDF(sg *ShaderGlobals) float32 {
+	if sg.Prim != Primitive(l.mesh) {
+		return 0
+	}
+
+	j := l.elem(sg.ElemID)
+
+	if j == -1 {
+		return 0
+	}
+
+	V := m.Vec3Sub(sg.P, sg.Ro)
+	dist2 := m.Vec3Length2(V)
+	cos := m.Abs(m.Vec3Dot(m.Vec3Normalize(sg.Ng), V)) / m.Sqrt(dist2)
+
+	if !(cos > 0) {
+		return 0
+	}
+
+	return l.prob(j) / l.area[j] * dist2 / cos
+}
+
+// SampleEmission implements Light.  Directions are cosine distributed on the emitting side.
+func (l *meshLight) SampleEmission(sg *ShaderGlobals, ls *LightSample) error {
+	j := l.pick(sg.Rand().Float64())
+	b0, b1 := sample.UniformTriangle(sg.Rand().Float64(), sg.Rand().Float64())
+
+	lsg := sg.pool.shaderGlobals()
+	defer sg.pool.putShaderGlobals(lsg)
+
+	l.mesh.SetupTriangle(int(l.tris[j]), b0, b1, lsg)
+
+	N := m.Vec3Normalize(front(lsg))
+	T, B := m.Vec3Basis(N)
+
+	ls.P = lsg.P
+	ls.D = m.Vec3BasisExpand(T, B, N, sample.CosineHemisphere(sg.Rand().Float64(), sg.Rand().Float64()))
+	ls.ElemID = uint32(l.tris[j])
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements Light.
+func (l *meshLight) EvalEmission(sg *ShaderGlobals, ls *LightSample) {
+	ls.Le.Lambda = sg.Lambda
+	ls.Le.Set(0)
+	ls.PdfA, ls.PdfD = 0, 0
+
+	j := l.elem(ls.ElemID)
+
+	if j == -1 {
+		return
+	}
+
+	V, _ := l.mesh.Triangle(int(ls.ElemID))
+	b0, b1 := barycentric(V, ls.P)
+
+	lsg := sg.pool.shaderGlobals()
+	defer sg.pool.putShaderGlobals(lsg)
+
+	l.mesh.SetupTriangle(int(ls.ElemID), b0, b1, lsg)
+
+	lsg.Ro, lsg.Rd = m.Vec3Add(ls.P, ls.D), m.Vec3Neg(ls.D)
+	lsg.Lambda, lsg.Time = sg.Lambda, sg.Time
+
+	E := l.mtls[j].Emission(lsg, lsg.WorldToTangent(ls.D))
+	N := m.Vec3Normalize(front(lsg))
+
+	ls.N = N
+	ls.Le.FromRGB(E[0], E[1], E[2])
+	ls.PdfA = l.prob(j) / l.area[j]
+	ls.PdfD = m.Max(0, m.Vec3Dot(N, ls.D)) / m.Pi
+}
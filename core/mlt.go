@@ -0,0 +1,370 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// MLTIntegrator renders with primary sample space Metropolis light transport (Kelemen et al.
+// 2002).  Paths are generated by the path tracer from a vector of (quasi)random numbers which
+// is mutated by a Markov chain, so once a path carrying a lot of light is found (e.g. a caustic
+// through glass) nearby paths are explored rather than relying on finding it again by chance.
+//
+// Mutations either perturb every number slightly (small steps) or replace them all (large
+// steps, with probability LargeStepProb).  The overall brightness is estimated from Bootstrap
+// independent paths before rendering, which also choose the starting paths of the chains.
+//
+// There is one chain for each tile of the image, started from a bootstrap path chosen with
+// Globals.Seed.  Each pixel sample of a tile advances the tile's chain by one mutation and the
+// chain splats the result wherever the path lands, so the image doesn't depend on the number of
+// goroutines.  AOVs aren't written.
+type MLTIntegrator struct {
+	NodeName      string  `node:"Name"`
+	Bootstrap     int     // Number of paths to estimate the normalization and start the chains
+	LargeStepProb float32 // Probability of a large step mutation
+	Sigma         float32 // Standard deviation of the small step mutations
+
+	once   sync.Once
+	b      float32   // Average luminance of the image
+	cdf    []float64 // Running sums of the luminance of the bootstrap paths
+	mu     sync.Mutex
+	chains map[int]*mltChain // Chains by tile index, started when the tile is first rendered
+}
+
+// NewMLTIntegrator returns an MLTIntegrator set to the defaults.
+func NewMLTIntegrator() *MLTIntegrator {
+	return &MLTIntegrator{
+		Bootstrap:     100000,
+		LargeStepProb: 0.3,
+		Sigma:         0.01,
+	}
+}
+
+// Name implements Node.
+func (mlt *MLTIntegrator) Name() string { return mlt.NodeName }
+
+// PreRender implements Node.
+func (mlt *MLTIntegrator) PreRender(rc *RenderContext) error { return nil }
+
+// PostRender implements Node.
+func (mlt *MLTIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.  The first call runs the bootstrap phase.  Workers advance
+// the chain of the tile they are given (see StartTile), a tile is only rendered by one
+// goroutine at a time so a chain is never advanced by two goroutines at once.
+func (mlt *MLTIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	mlt.once.Do(func() { mlt.bootstrap(rc) })
+
+	return &mltWorker{mlt: mlt, rc: rc}
+}
+
+// chain returns the chain for tile index, starting it if needed.
+func (mlt *MLTIntegrator) chain(rc *RenderContext, index int) *mltChain {
+	mlt.mu.Lock()
+	defer mlt.mu.Unlock()
+
+	if mlt.chains == nil {
+		mlt.chains = make(map[int]*mltChain)
+	}
+
+	c, ok := mlt.chains[index]
+
+	if !ok {
+		c = mlt.startChain(rc, index)
+		mlt.chains[index] = c
+	}
+
+	return c
+}
+
+// bootstrap estimates the image normalization from independent paths and keeps their
+// luminance to choose the starting paths of the chains.
+func (mlt *MLTIntegrator) bootstrap(rc *RenderContext) {
+	cdf := make([]float64, mlt.Bootstrap)
+	sum := float64(0)
+
+	c := newMLTChain(rc, mlt)
+
+	for i := range cdf {
+		c.sampler.reset(int64(i))
+
+		L, _, _ := c.eval()
+
+		sum += float64(L.Luminance())
+		cdf[i] = sum
+	}
+
+	if !(sum > 0) {
+		// No light reaches the camera.
+		return
+	}
+
+	mlt.b = float32(sum / float64(mlt.Bootstrap))
+	mlt.cdf = cdf
+}
+
+// startChain returns the chain for tile k started from one of the bootstrap paths, chosen in
+// proportion to its luminance.
+func (mlt *MLTIntegrator) startChain(rc *RenderContext, k int) *mltChain {
+	c := newMLTChain(rc, mlt)
+
+	if mlt.b == 0 {
+		return c
+	}
+
+	rnd := rand.New(rand.NewSource(int64(hash(uint64(rc.globals.Seed), uint64(k)))))
+	sum := mlt.cdf[len(mlt.cdf)-1]
+	i := sort.SearchFloat64s(mlt.cdf, rnd.Float64()*sum)
+
+	if i >= len(mlt.cdf) {
+		i = len(mlt.cdf) - 1
+	}
+
+	// Replay the chosen path then give the chain its own mutations.
+	c.sampler.reset(int64(i))
+	c.L, c.x, c.y = c.eval()
+	c.I = c.L.Luminance()
+	c.sampler.src.Seed(rnd.Int63())
+
+	return c
+}
+
+// mltChain is the state of one Markov chain.
+type mltChain struct {
+	sampler mltSampler
+	rnd     *rand.Rand // Reads the primary samples
+	rc      *RenderContext
+	camera  Camera
+	tracer  *pathTracer
+	ray     *RayData // Heap allocated for alignment
+	w, h    int
+
+	// The current path.
+	L    colour.RGB
+	x, y int
+	I    float32 // Luminance of L
+}
+
+func newMLTChain(rc *RenderContext, mlt *MLTIntegrator) *mltChain {
+	c := &mltChain{
+		rc:     rc,
+		camera: rc.FindNode("camera").(Camera),
+		tracer: newPathTracer(&rc.globals),
+		ray:    &RayData{},
+	}
+
+	c.w, c.h = rc.OutputRes()
+
+	c.sampler.src = rand.NewSource(0)
+	c.sampler.mutate = rand.New(c.sampler.src)
+	c.sampler.sigma = float64(mlt.Sigma)
+	c.sampler.largeStepProb = float64(mlt.LargeStepProb)
+	c.rnd = rand.New(&c.sampler)
+
+	return c
+}
+
+// eval traces the path for the current primary samples and returns its radiance and pixel.
+// The samples are used in the same order as samplePixel.
+func (c *mltChain) eval() (L colour.RGB, x, y int) {
+	sx := c.rnd.Float32() * float32(c.w)
+	sy := c.rnd.Float32() * float32(c.h)
+
+	lambda := (float32(720-450) * c.rnd.Float32()) + 450
+	time := c.rnd.Float32()
+
+	sg := &ShaderGlobals{
+		Lambda: lambda,
+		Time:   time,
+		rnd:    c.rnd,
+	}
+
+	u, v := c.rc.screen(sx, sy)
+	c.camera.ComputeRay(u, v, time, c.rnd, c.ray, sg)
+
+	L = c.tracer.Radiance(c.ray, nil)
+
+	if l := L.Luminance(); !(l > 0) || math.IsInf(float64(l), 0) {
+		// Also rejects NaN, such paths are never accepted.
+		L = colour.RGB{}
+	}
+
+	x = int(m.Min(sx, float32(c.w-1)))
+	y = int(m.Min(sy, float32(c.h-1)))
+
+	return
+}
+
+// mltWorker is the IntegratorWorker for the MLTIntegrator.
+type mltWorker struct {
+	mlt   *MLTIntegrator
+	rc    *RenderContext
+	chain *mltChain
+	tile  *FrameBuffer // Tile being rendered, records the splats
+}
+
+// StartTile implements SplattingWorker, the samples of the tile advance its chain.
+func (w *mltWorker) StartTile(tile *FrameBuffer, index int) {
+	w.tile = tile
+
+	if w.mlt.b != 0 {
+		w.chain = w.mlt.chain(w.rc, index)
+	}
+}
+
+// Radiance implements IntegratorWorker.  ray is ignored, the tile's chain is advanced by one
+// mutation and the current and proposed paths are splatted weighted by their probability of
+// being the next state (expected value splatting).  Always returns black.
+func (w *mltWorker) Radiance(ray *RayData, aov *AOVSample) colour.RGB {
+	c := w.chain
+
+	if w.mlt.b == 0 {
+		return colour.RGB{}
+	}
+
+	c.sampler.startIteration()
+
+	L, x, y := c.eval()
+	I := L.Luminance()
+
+	accept := float32(1)
+
+	if c.I > 0 {
+		accept = m.Min(1, I/c.I)
+	}
+
+	if I > 0 {
+		Lp := L
+		Lp.Scale(accept * w.mlt.b / I)
+		w.tile.SplatLight(x, y, Lp)
+	}
+
+	if c.I > 0 {
+		Lc := c.L
+		Lc.Scale((1 - accept) * w.mlt.b / c.I)
+		w.tile.SplatLight(c.x, c.y, Lc)
+	}
+
+	if c.sampler.mutate.Float32() < accept {
+		c.L, c.x, c.y, c.I = L, x, y, I
+		c.sampler.accept()
+	} else {
+		c.sampler.reject()
+	}
+
+	w.rc.framebuf.AddLightPaths(1)
+
+	return colour.RGB{}
+}
+
+// primarySample is one element of the primary sample vector.  Mutations are applied lazily
+// when the sample is next used, the backup is restored if the mutation is rejected.
+type primarySample struct {
+	value        float64
+	lastModified int64 // Iteration the value was last changed
+	valueBackup  float64
+	backupMod    int64
+}
+
+// mltSampler is a replayable vector of primary samples in [0,1) which implements rand.Source,
+// so a rand.Rand reading from it turns the vector into the random decisions of a path.  The
+// same vector always gives the same path.  (After Pharr, Jakob & Humphreys, Physically Based
+// Rendering 3rd ed., sec. 16.4.)
+type mltSampler struct {
+	X      []primarySample
+	index  int
+	src    rand.Source // Seeds mutate
+	mutate *rand.Rand  // Random numbers for the mutations themselves
+
+	sigma, largeStepProb float64
+
+	iteration     int64
+	lastLargeStep int64
+	largeStep     bool
+}
+
+// reset clears the vector, the samples will be drawn uniformly from a generator seeded with
+// seed.
+func (s *mltSampler) reset(seed int64) {
+	s.src.Seed(seed)
+	s.X = s.X[:0]
+	s.index = 0
+	s.iteration = 0
+	s.lastLargeStep = 0
+	s.largeStep = true
+}
+
+// startIteration starts a new mutation, the next path is read from the start of the vector.
+func (s *mltSampler) startIteration() {
+	s.iteration++
+	s.largeStep = s.mutate.Float64() < s.largeStepProb
+	s.index = 0
+}
+
+// accept keeps the mutated samples.
+func (s *mltSampler) accept() {
+	if s.largeStep {
+		s.lastLargeStep = s.iteration
+	}
+}
+
+// reject restores the samples changed by the current mutation.
+func (s *mltSampler) reject() {
+	for i := range s.X {
+		if x := &s.X[i]; x.lastModified == s.iteration {
+			x.value = x.valueBackup
+			x.lastModified = x.backupMod
+		}
+	}
+
+	s.iteration--
+}
+
+// next returns the next sample, applying any mutations it has missed.
+func (s *mltSampler) next() float64 {
+	for s.index >= len(s.X) {
+		s.X = append(s.X, primarySample{})
+	}
+
+	x := &s.X[s.index]
+	s.index++
+
+	// Samples not used since the last large step would have been replaced by it.
+	if x.lastModified < s.lastLargeStep {
+		x.value = s.mutate.Float64()
+		x.lastModified = s.lastLargeStep
+	}
+
+	x.valueBackup = x.value
+	x.backupMod = x.lastModified
+
+	if s.largeStep {
+		x.value = s.mutate.Float64()
+	} else {
+		// The small steps missed since the sample was last used combine into one.
+		n := float64(s.iteration - x.lastModified)
+		x.value += s.mutate.NormFloat64() * s.sigma * math.Sqrt(n)
+		x.value -= math.Floor(x.value)
+	}
+
+	x.lastModified = s.iteration
+
+	return x.value
+}
+
+// Int63 implements rand.Source (see sampleInt63).  Small mutations give small changes in
+// Float32 and Float64, paths must make their integer choices from those.
+func (s *mltSampler) Int63() int64 {
+	return sampleInt63(s.next())
+}
+
+// Seed implements rand.Source, the samples come from the vector so it does nothing.
+func (s *mltSampler) Seed(seed int64) {}
@@ -0,0 +1,294 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Lighting AOV categories for the path tracer, decided by the lobe sampled at the first hit.
+const (
+	pathDiffuse = iota
+	pathSpecular
+	pathTransmission
+	pathEmission
+	pathCategories
+)
+
+var pathCategoryAOVs = [pathCategories]string{"diffuse", "specular", "transmission", "emission"}
+
+// pathCategoryMasks are the Closure.Eval masks for the direct lighting at the first hit.
+var pathCategoryMasks = []int{ScatterDiffuse, ScatterGlossy | ScatterSpecular, ScatterTransmission}
+
+// category returns the AOV category for a lobe type.
+func category(ty int) int {
+	switch {
+	case ty&ScatterTransmission != 0:
+		return pathTransmission
+	case ty&ScatterDiffuse != 0:
+		return pathDiffuse
+	}
+	return pathSpecular
+}
+
+// offsetRay initialises ray starting at the point in sg in direction d, offsetting the origin
+// to the side of the surface d points to.
+func offsetRay(ray *RayData, ty uint32, sg *ShaderGlobals, d m.Vec3, maxdist float32) {
+	if m.Vec3Dot(d, sg.Poffset) < 0 {
+		ray.Init(ty, sg.OffsetP(-1), d, maxdist, sg)
+	} else {
+		ray.Init(ty, sg.OffsetP(1), d, maxdist, sg)
+	}
+}
+
+// emissionWeight returns the power heuristic weight of the BSDF sample with solid angle PDF
+// bsdfPdf that found the emissive hit in sg against sampling the light the surface belongs to.
+// Surfaces that aren't part of a light can only be found by BSDF sampling.
+func emissionWeight(sg *ShaderGlobals, bsdfPdf float32) float32 {
+	for _, light := range grc.scene.lights {
+		if pdf := light.PDF(sg); pdf > 0 {
+			return PowerHeuristic(bsdfPdf, pdf)
+		}
+	}
+	return 1
+}
+
+// pathTracer is the per-goroutine state of the path tracer (PathIntegrator and
+// DirectIntegrator).
+type pathTracer struct {
+	globals *Globals
+	closure Closure
+	shadow  *RayData         // Heap allocated for alignment
+	shadowg ShaderGlobals    // Unmodified by shadow rays
+	sg      [2]ShaderGlobals // The first hit and the current hit
+}
+
+func newPathTracer(globals *Globals) *pathTracer {
+	return &pathTracer{globals: globals, shadow: &RayData{}}
+}
+
+// sampleLights adds the light sampled direct illumination at the point in sg, weighted against
+// BSDF sampling with the power heuristic (except for delta lights which BSDF sampling can't
+// find), to out[i] for the closure lobes matching masks[i].
+func (pt *pathTracer) sampleLights(sg *ShaderGlobals, masks []int, out []colour.Spectrum) {
+	for _, light := range grc.scene.lights {
+		if light.SampleArea(sg) != nil {
+			continue
+		}
+
+		omegaO := sg.WorldToTangent(sg.Ld)
+
+		if _, pdf := pt.closure.Eval(omegaO, 0); pdf == 0 {
+			// Direction can't be scattered by any lobe, avoid the shadow ray.
+			continue
+		}
+
+		offsetRay(pt.shadow, RayShadow, sg, m.Vec3Scale(sg.Ldist*(1.0-VisRayEpsilon), sg.Ld), 1.0)
+
+		if TraceProbe(pt.shadow, &pt.shadowg) {
+			continue
+		}
+
+		delta := isDelta(light)
+
+		for i, mask := range masks {
+			f, pdf := pt.closure.Eval(omegaO, mask)
+
+			f.Mul(sg.Liu)
+
+			if delta {
+				f.Scale(sg.Weight)
+			} else {
+				f.Scale(sg.Weight * PowerHeuristic(sg.Lpdf, pdf))
+			}
+
+			out[i].Add(f)
+		}
+	}
+}
+
+// Radiance implements IntegratorWorker using unidirectional path tracing with next event
+// estimation, light and BSDF samples are combined with multiple importance sampling.  If
+// aov is non-nil the builtin and lighting AOVs for the first hit are written.
+func (pt *pathTracer) Radiance(ray *RayData, aov *AOVSample) colour.RGB {
+	var L [pathCategories]colour.Spectrum
+	var beta colour.Spectrum
+
+	for i := range L {
+		L[i].Lambda = ray.Lambda
+	}
+
+	beta.Lambda = ray.Lambda
+	beta.Set(1)
+
+	var first *ShaderGlobals
+	var depth, diffuseDepth, glossyDepth, transDepth int
+
+	globals := pt.globals
+	cat := pathEmission
+	lastPdf := float32(0)
+	lastSpecular := true // Camera rays count as specular (no MIS for directly visible lights)
+	terminate := false
+
+	for {
+		sg := &pt.sg[1]
+
+		if first == nil {
+			sg = &pt.sg[0]
+		}
+
+		*sg = ShaderGlobals{
+			X:       ray.X,
+			Y:       ray.Y,
+			Ro:      ray.Ray.P,
+			Rd:      ray.Ray.D,
+			RayType: uint16(ray.Type),
+			Depth:   ray.Level,
+			rnd:     ray.rnd,
+			Lambda:  ray.Lambda,
+			Time:    ray.Time,
+			pool:    ray.pool,
+		}
+
+		if first == nil {
+			sg.aov = aov
+			first = sg
+		}
+
+		if !TraceProbe(ray, sg) {
+			if len(grc.scene.background) > 0 {
+				bsdfPdf := lastPdf
+
+				if lastSpecular {
+					bsdfPdf = 0
+				}
+
+				Le := background(sg, depth == 0, bsdfPdf)
+				Le.Mul(beta)
+				L[cat].Add(Le)
+			}
+			break
+		}
+
+		if sg.Shader == nil {
+			break
+		}
+
+		sg.ElemID = ray.Result.ElemID
+		sg.Rl = float64(m.Vec3Length(m.Vec3Sub(sg.P, sg.Ro)))
+
+		if depth == 0 {
+			sg.writeBuiltinAOVs()
+		}
+
+		if sg.Shader.HasEDF() {
+			var Le colour.Spectrum
+
+			E := sg.Shader.Emission(sg, sg.ViewDirection())
+			Le.Lambda = sg.Lambda
+			Le.FromRGB(E[0], E[1], E[2])
+			Le.Mul(beta)
+
+			if !lastSpecular {
+				Le.Scale(emissionWeight(sg, lastPdf))
+			}
+
+			L[cat].Add(Le)
+		}
+
+		// Past the depth limits the last ray is only traced to find emission.
+		if terminate {
+			break
+		}
+
+		pt.closure.Reset(sg)
+		sg.Shader.Closure(sg, &pt.closure)
+
+		if len(pt.closure.Lobes) == 0 {
+			break
+		}
+
+		if pt.closure.NonSpecular() {
+			if depth == 0 && aov != nil {
+				// Split the direct lighting at the first hit into the lighting AOVs.
+				pt.sampleLights(sg, pathCategoryMasks, L[:])
+			} else {
+				Ld := [1]colour.Spectrum{{Lambda: sg.Lambda}}
+
+				pt.sampleLights(sg, []int{^0}, Ld[:])
+				Ld[0].Mul(beta)
+
+				if depth == 0 {
+					L[pathDiffuse].Add(Ld[0])
+				} else {
+					L[cat].Add(Ld[0])
+				}
+			}
+		}
+
+		omegaO, f, pdf, ty := pt.closure.Sample(sg.rnd.Float64(), sg.rnd.Float64(), sg.rnd.Float64())
+
+		if !(pdf > 0) {
+			break
+		}
+
+		if depth == 0 {
+			cat = category(ty)
+		}
+
+		depth++
+
+		rayType := uint32(RayGlossy)
+
+		switch {
+		case ty&ScatterTransmission != 0:
+			transDepth++
+			rayType = RayTransmission
+			terminate = transDepth > globals.MaxTransmissionDepth
+		case ty&ScatterDiffuse != 0:
+			diffuseDepth++
+			rayType = RayDiffuse
+			terminate = diffuseDepth > globals.MaxDiffuseDepth
+		default:
+			glossyDepth++
+			terminate = glossyDepth > globals.MaxGlossyDepth
+		}
+
+		terminate = terminate || depth > globals.MaxDepth
+
+		f.Scale(1 / pdf)
+		beta.Mul(f)
+
+		lastPdf = pdf
+		lastSpecular = ty&ScatterSpecular != 0
+
+		if depth > globals.RRDepth {
+			q := m.Max(0.05, 1-m.Max(m.Max(beta.C[0], beta.C[1]), m.Max(beta.C[2], beta.C[3])))
+
+			if sg.rnd.Float32() < q {
+				break
+			}
+
+			beta.Scale(1 / (1 - q))
+		}
+
+		sg.Depth = uint8(depth)
+
+		offsetRay(ray, rayType, sg, sg.TangentToWorld(omegaO), m.Inf(1))
+	}
+
+	var out colour.RGB
+
+	for i := range L {
+		r, g, b := L[i].ToRGB()
+		c := colour.RGB{r, g, b}
+
+		first.AOVSetRGB(pathCategoryAOVs[i], c)
+		out.Add(c)
+	}
+
+	return out
+}
@@ -0,0 +1,66 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+// workerPool holds released RayData and ShaderGlobals for reuse by one render goroutine.
+// RayData is large (it holds the traversal stack) so allocating one for every shadow and
+// bounce ray traced by shaders makes a lot of work for the garbage collector.  The pool is
+// passed down from the camera ray through RayData.Init and Trace.  A nil pool allocates.
+type workerPool struct {
+	rays []*RayData
+	sgs  []*ShaderGlobals
+}
+
+// ray returns a RayData from the pool.
+func (p *workerPool) ray() *RayData {
+	if p == nil || len(p.rays) == 0 {
+		return &RayData{}
+	}
+
+	r := p.rays[len(p.rays)-1]
+	p.rays = p.rays[:len(p.rays)-1]
+
+	return r
+}
+
+// putRay returns r to the pool.
+func (p *workerPool) putRay(r *RayData) {
+	if p != nil {
+		p.rays = append(p.rays, r)
+	}
+}
+
+// shaderGlobals returns a zeroed ShaderGlobals from the pool.
+func (p *workerPool) shaderGlobals() *ShaderGlobals {
+	if p == nil || len(p.sgs) == 0 {
+		return &ShaderGlobals{pool: p}
+	}
+
+	sg := p.sgs[len(p.sgs)-1]
+	p.sgs = p.sgs[:len(p.sgs)-1]
+
+	*sg = ShaderGlobals{pool: p}
+
+	return sg
+}
+
+// putShaderGlobals returns sg to the pool.
+func (p *workerPool) putShaderGlobals(sg *ShaderGlobals) {
+	if p != nil {
+		p.sgs = append(p.sgs, sg)
+	}
+}
+
+// NewRay returns a RayData for tracing a ray from the shading point, it should be passed to
+// ReleaseRay once the results have been used.  The RayData are reused by each render
+// goroutine.
+func (sg *ShaderGlobals) NewRay() *RayData {
+	return sg.pool.ray()
+}
+
+// ReleaseRay returns ray (from NewRay) for reuse.
+func (sg *ShaderGlobals) ReleaseRay(ray *RayData) {
+	sg.pool.putRay(ray)
+}
@@ -30,6 +30,24 @@ type Primitive interface {
 	// UVCoord(set int, elem uint32, su,sv float32) m.Vec3
 }
 
+// TriangleMesh is implemented by primitives made of triangles.  Triangles with an emissive
+// material are sampled as lights (see RenderContext.PreRender).
+type TriangleMesh interface {
+	Primitive
+
+	// NumTriangles returns the number of triangles that can be sampled.  Triangles are
+	// numbered as ShaderGlobals.ElemID of ray hits.
+	NumTriangles() int
+
+	// Triangle returns the vertices and material id of triangle i.
+	Triangle(i int) (V [3]m.Vec3, mtlid int32)
+
+	// SetupTriangle sets up sg (P, N, Ns, Ng, U, V, DdPdu, DdPdv) for the point with
+	// barycentric coordinates b0, b1 (the weights of V[0] and V[1]) on triangle i as a ray
+	// hit would.
+	SetupTriangle(i int, b0, b1 float32, sg *ShaderGlobals)
+}
+
 //go:nosplit
 //go:noescape
 func rayNodeIntersectAllASM(ray *Ray, node *qbvh.Node, hit *[4]int32, tNear *[4]float32)
@@ -56,6 +74,7 @@ func (scene *Scene) traceRayAccel(ray *RayData, sg *ShaderGlobals) (mtlid int32)
 
 		if node >= 0 {
 			pnode := &(scene.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			order := [4]int{0, 1, 2, 3} // actually in reverse order as this is order pushed on stack
@@ -117,6 +136,8 @@ func (scene *Scene) traceRayAccel(ray *RayData, sg *ShaderGlobals) (mtlid int32)
 
 				if _mtlid > -1 {
 					ray.Result.Prim = scene.prims[i]
+					sg.Prim = scene.prims[i]
+					sg.PrimID = int32(i)
 					mtlid = _mtlid
 				}
 			}
@@ -146,6 +167,7 @@ func (scene *Scene) visRayAccel(ray *RayData) {
 
 		if node >= 0 {
 			pnode := &(scene.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			for k := range pnode.Children {
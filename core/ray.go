@@ -13,6 +13,9 @@ import (
 const (
 	RayCamera = (1 << iota)
 	RayShadow
+	RayDiffuse      // Path continuation after diffuse scattering
+	RayGlossy       // Path continuation after glossy or specular reflection
+	RayTransmission // Path continuation after transmission
 )
 
 // CheckEmptyLeaf is a debug constant. If set then empty leafs are explicitly checked.
@@ -69,11 +72,9 @@ type Ray struct {
 	Kx, Ky, Kz int32      // 13
 }
 
-// RayStats collects stats about the ray traversal.
-//
-// Deprecated: RayStats is unused.
+// RayStats collects stats about the ray traversal.  They are reset by Init.
 type RayStats struct {
-	Nnodes int
+	Nnodes int // Number of acceleration structure nodes visited
 }
 
 // RayData represents a ray plus support and transform data.  Will be refactored.
@@ -91,6 +92,8 @@ type RayData struct {
 	Lambda       float32
 	Time         float32
 	Type         uint32
+	X, Y         int // Raster position of the pixel being sampled
+	pool         *workerPool
 }
 
 // Init sets up the ray.  ty should be bitwise combination of RAY_ constants.  P is the
@@ -106,7 +109,9 @@ func (r *RayData) Init(ty uint32, P, D m.Vec3, maxdist float32, sg *ShaderGlobal
 	r.rnd = sg.rnd
 	r.Lambda = sg.Lambda
 	r.Time = sg.Time
-
+	r.X, r.Y = sg.X, sg.Y
+	r.pool = sg.pool
+	r.Stats = RayStats{}
 }
 
 // IsVis returns true if P1 is visible from P0.
@@ -0,0 +1,168 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core_test
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core"
+	_ "github.com/jamiec7919/vermeer/internal/camera"
+	_ "github.com/jamiec7919/vermeer/internal/geom/polymesh"
+	_ "github.com/jamiec7919/vermeer/internal/light/disk"
+	_ "github.com/jamiec7919/vermeer/material"
+	"github.com/jamiec7919/vermeer/nodes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// testScene is a floor and wall lit by a disk light.  The small tiles and wide filter make
+// samples splat across tile borders.
+const testScene = `
+PathIntegrator { Name "path" }
+BDPTIntegrator { Name "bdpt" }
+MLTIntegrator { Name "mlt" Bootstrap 2000 }
+SPPMIntegrator { Name "sppm" }
+
+Globals {
+	Integrator "%v"
+	Sampler "%v"
+	Seed 3
+	MaxDepth 3
+	XRes 40
+	YRes 30
+	TileSize 8
+	Filter "gaussian"
+}
+
+Material {
+	Name "floor"
+	Kd rgb 0.7 0.7 0.7
+	Ks rgb 0.9 0.9 0.9
+	DiffuseStrength float 1
+	SpecularStrength float 0.2
+	Roughness float 0.5
+	SpecularRoughness float 0.4
+}
+
+Material {
+	Name "red"
+	Kd rgb 0.8 0.2 0.2
+	DiffuseStrength float 1
+}
+
+Material {
+	Name "lightmtl"
+	Kd rgb 0 0 0
+	E rgb 20 20 20
+	DiffuseStrength float 0
+}
+
+PolyMesh {
+	Name "floor"
+	Verts 1 4 point -2 0 -2  2 0 -2  2 0 2  -2 0 2
+	PolyCount 1 int 4
+	FaceIdx 4 int 3 2 1 0
+	ModelToWorld 1 matrix 1 0 0 0  0 1 0 0  0 0 1 0  0 0 0 1
+	Material "floor"
+}
+
+PolyMesh {
+	Name "wall"
+	Verts 1 4 point -2 0 -1  2 0 -1  2 2 -1  -2 2 -1
+	PolyCount 1 int 4
+	FaceIdx 4 int 0 1 2 3
+	ModelToWorld 1 matrix 1 0 0 0  0 1 0 0  0 0 1 0  0 0 0 1
+	Material "red"
+}
+
+DiskLight {
+	Name "light01"
+	Material "lightmtl"
+	P 0 1.5 0.5
+	LookAt 0 0 0.5
+	Up 0 0 1
+	Radius 0.3
+}
+
+Camera {
+	Name "camera"
+	Type "LookAt"
+	From 1 1 point 0 1 3
+	To 1 1 point 0 0.5 0
+	Up 0 1 0
+	Focal 1
+	Fov 60
+}
+`
+
+// renderScene renders the test scene for iter iterations with the given number of goroutines
+// and returns the image.
+func renderScene(t *testing.T, integrator, sampler string, threads, iter int) []float32 {
+	dir, err := ioutil.TempDir("", "vermeer")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(dir, "scene.vnf")
+
+	if err := ioutil.WriteFile(filename, []byte(fmt.Sprintf(testScene, integrator, sampler)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rc := core.NewRenderContext()
+
+	if err := nodes.Parse(rc, filename); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	rc.Globals().MaxGoRoutines = threads
+
+	if err := rc.PreRender(); err != nil {
+		t.Fatalf("PreRender: %v", err)
+	}
+
+	if _, err := rc.Render(iter); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+
+	return rc.Image()
+}
+
+// TestRenderThreads checks that renders don't depend on the number of goroutines.
+func TestRenderThreads(t *testing.T) {
+	tests := []struct {
+		integrator, sampler string
+	}{
+		{"path", "sobol"},
+		{"path", "cmj"},
+		{"path", "independent"},
+		{"bdpt", "sobol"},
+		{"mlt", "sobol"},
+		{"sppm", "sobol"},
+	}
+
+	for _, test := range tests {
+		a := renderScene(t, test.integrator, test.sampler, 1, 3)
+		b := renderScene(t, test.integrator, test.sampler, 4, 3)
+
+		nonzero := false
+
+		for i := range a {
+			if a[i] != b[i] {
+				t.Errorf("%v %v: 1 and 4 goroutines differ at %v: %v != %v", test.integrator, test.sampler, i, a[i], b[i])
+				break
+			}
+
+			if a[i] != 0 {
+				nonzero = true
+			}
+		}
+
+		if !nonzero {
+			t.Errorf("%v %v: image is black", test.integrator, test.sampler)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/cheggaaa/pb"
 	// "github.com/jamiec7919/vermeer/material"
 	"fmt"
+	"github.com/jamiec7919/vermeer/colour"
 	m "github.com/jamiec7919/vermeer/math"
 	"log"
 	"math/rand"
@@ -15,10 +16,12 @@ import (
 	"time"
 )
 
-// TILESIZE is the size (width and height) in pixels of a render tile.
+// TILESIZE is the default size (width and height) in pixels of a render tile.
 const TILESIZE = 64
 
-// MAXGOROUTINES is the default number of goroutines to run for rendering.
+// MAXGOROUTINES was the default number of goroutines to run for rendering.
+//
+// Deprecated: the default is now one goroutine per CPU (see Globals.Threads).
 const MAXGOROUTINES = 5
 
 // NSAMP is the number of samples to take (not used).
@@ -31,23 +34,32 @@ var shadowRays uint64
 // Stats collects stats for the whole render.
 type Stats struct {
 	Duration                 time.Duration
+	Iterations               int // Including any before the render was resumed
 	RayCount, ShadowRayCount uint64
+
+	// Samples per pixel, these differ between pixels with adaptive sampling.
+	MinSamples, MaxSamples uint32
+	MeanSamples            float64
 }
 
 func (s *Stats) String() string {
-	return fmt.Sprintf("%v	%v	%v/%v", s.Duration, float64(s.RayCount)/(1000000.0*s.Duration.Seconds()), s.RayCount, s.ShadowRayCount)
+	return fmt.Sprintf("%v	%v	%v/%v	%v/%.1f/%v", s.Duration, float64(s.RayCount)/(1000000.0*s.Duration.Seconds()), s.RayCount, s.ShadowRayCount, s.MinSamples, s.MeanSamples, s.MaxSamples)
 }
 
 // Frame represents a single frame.
 //
 // Deprecated: not needed.
 type Frame struct {
-	w, h   int
-	du, dv float32
-	camera Camera
-	scene  *Scene
-	rc     *RenderContext
-	bar    *pb.ProgressBar
+	w, h       int
+	camera     Camera
+	filter     Filter
+	sampler    Sampler // Cloned for each goroutine
+	integrator Integrator
+	scene      *Scene
+	rc         *RenderContext
+	bar        *pb.ProgressBar
+	active     []bool    // Pixels still being sampled, nil for all
+	errors     []float32 // Relative error of each pixel for adaptive sampling
 }
 
 // PreviewWindow is an interface that preview windows should implement.
@@ -65,16 +77,52 @@ type PreviewFrame struct {
 	Buf  []uint8
 }
 
-// OutputRes returns the output resolution.
+// OutputRes returns the resolution of the rendered image, the crop window including any
+// overscan (see Globals.Crop).  This is XRes x YRes if neither are set.
 func (rc *RenderContext) OutputRes() (int, int) {
-	return rc.globals.XRes, rc.globals.YRes
+	_, _, w, h, _ := rc.globals.window()
+	return w, h
 }
 
-// Image returns a float32 RGB slice of pixels.
+// DataWindow returns the position of the top left pixel of the rendered image relative to
+// the top left of the XRes x YRes image, and its size.  Outputs store the rendered image as the
+// data window and XRes x YRes as the display window.
+func (rc *RenderContext) DataWindow() (x, y, w, h int) {
+	x, y, w, h, _ = rc.globals.window()
+	return
+}
+
+// screen returns the camera screen position of the raster position x,y.  Raster positions
+// are frame buffer pixels, which only cover the crop window.
+func (rc *RenderContext) screen(x, y float32) (u, v float32) {
+	u = -1 + (x+float32(rc.rasterX))*2/float32(rc.globals.XRes)
+	v = 1 - (y+float32(rc.rasterY))*2/float32(rc.globals.YRes)
+	return
+}
+
+// raster returns the frame buffer pixel at camera screen position u,v.
+func (rc *RenderContext) raster(u, v float32) (x, y int) {
+	x = int(m.Floor((u+1)*float32(rc.globals.XRes)/2)) - rc.rasterX
+	y = int(m.Floor((1-v)*float32(rc.globals.YRes)/2)) - rc.rasterY
+	return
+}
+
+// Image returns a float32 RGB slice of pixels.  The values are unclamped linear radiance.
 func (rc *RenderContext) Image() []float32 {
 	return rc.imgbuf
 }
 
+// Globals returns the render settings.
+func (rc *RenderContext) Globals() *Globals {
+	return &rc.globals
+}
+
+// FrameBuffer returns the accumulation buffer for the current render, or nil if
+// rendering hasn't started.
+func (rc *RenderContext) FrameBuffer() *FrameBuffer {
+	return rc.framebuf
+}
+
 // RenderContext represents everything in the current core API instance.
 //
 // Deprecated: will only ever be one of these so promote everything to top level and avoid
@@ -82,12 +130,22 @@ func (rc *RenderContext) Image() []float32 {
 type RenderContext struct {
 	globals   Globals
 	imgbuf    []float32
+	framebuf  *FrameBuffer
 	frames    []Frame
 	nodes     []Node
 	nodeMap   map[string]Node
 	scene     Scene
 	cameras   []Camera
 	materials []Material
+	aovs      []*AOV
+	aovIndex  map[string]*AOV
+	aovStride int // Total number of floats for all AOVs
+	sceneHash []byte
+	assets    []string // Files read by the scene, see AddAsset
+	resume    bool     // Continue from the checkpoint
+
+	// Frame buffer pixel 0,0 is this pixel of the full frame (bottom row up).
+	rasterX, rasterY int
 
 	PreviewChan chan PreviewFrame
 	preview     PreviewWindow
@@ -108,11 +166,10 @@ func (rc *RenderContext) GetMaterial(id int32) Material {
 // a time is to be rendered anyway.
 func NewRenderContext() *RenderContext {
 	rc := &RenderContext{}
-	rc.globals.XRes = 256
-	rc.globals.YRes = 256
-	rc.globals.MaxGoRoutines = MAXGOROUTINES
+	rc.globals = *NewGlobals()
 	rc.finish = make(chan bool, 1)
 	rc.nodeMap = make(map[string]Node)
+	rc.aovIndex = make(map[string]*AOV)
 	grc = rc
 	return rc
 }
@@ -125,11 +182,16 @@ func (rc *RenderContext) StartPreview(preview PreviewWindow) error {
 
 // Finish is called to notify all listeners that all rendering should finish and exit.
 func (rc *RenderContext) Finish() {
-	rc.finish <- true
+	select {
+	case rc.finish <- true:
+	default:
+		// Already finishing.
+	}
 }
 
 // PreRender is called after all nodes are loaded and calls PreRender on all nodes.
-// Nodes may add new nodes so PreRender iterates until no new nodes are created.
+// Nodes may add new nodes so PreRender iterates until no new nodes are created.  Once the
+// scene is built a light is added for each TriangleMesh with emissive triangles.
 func (rc *RenderContext) PreRender() error {
 	// pre and fixup nodes
 	// Note that nodes in PreRender may add new nodes, so we must backup and
@@ -152,17 +214,27 @@ func (rc *RenderContext) PreRender() error {
 
 	rc.nodes = allnodes
 
-	return rc.scene.initAccel()
+	if err := rc.scene.initAccel(); err != nil {
+		return err
+	}
+
+	rc.scene.initMeshLights()
+
+	return nil
 }
 
 // WorkItem represents a screen tile (note: shouldn't be public).
 type WorkItem struct {
 	x, y, w, h int
-	samples    []float32
+	fb         *FrameBuffer
+	index      int          // Position of the tile in top-down order, whatever the bucket order
+	iter       int          // Iteration, the sample index for the pixels
+	seq        int          // Position in the order tiles are merged
+	tile       *FrameBuffer // The rendered tile
 }
 
 /* This should return an rgb sample to be accumulated for the pixel */
-func samplePixel(x, y int, frame *Frame, rnd *rand.Rand, ray *RayData) (r, g, b float32) {
+func samplePixel(sx, sy float32, frame *Frame, rnd *rand.Rand, ray *RayData, worker IntegratorWorker, aov *AOVSample, pool *workerPool) (c colour.RGB) {
 	/*
 	  .. Trace AA_count rays around pixel, for each ray that hits different surface/triangle
 	    shade that and weight accordingly.
@@ -170,48 +242,67 @@ func samplePixel(x, y int, frame *Frame, rnd *rand.Rand, ray *RayData) (r, g, b
 	    Need to get the primitive & face id out of ray intersection. Time needs consideration
 	*/
 
-	//log.Printf("Pix %v %v", x, y)
-	r0 := rnd.Float32()
-	r1 := rnd.Float32()
-
-	u := (float32(x) + r0) * frame.du
-	v := (float32(y) + r1) * frame.dv
+	u, v := frame.rc.screen(sx, sy)
 
 	lambda := (float32(720-450) * rnd.Float32()) + 450
 	time := rnd.Float32()
 
-	sg := &ShaderGlobals{
-		Lambda: lambda,
-		Time:   time,
-		rnd:    rnd,
-	}
+	sg := pool.shaderGlobals()
+	defer pool.putShaderGlobals(sg)
 
-	frame.camera.ComputeRay(-1+u, 1-v, time, rnd, ray, sg)
+	sg.X, sg.Y = int(sx), int(sy)
+	sg.Lambda, sg.Time = lambda, time
+	sg.rnd = rnd
 
-	var samp ScreenSample
+	frame.camera.ComputeRay(u, v, time, rnd, ray, sg)
 
-	if Trace(ray, &samp) {
-		return samp.Colour[0], samp.Colour[1], samp.Colour[2]
+	if aov != nil {
+		aov.Reset()
 	}
 
-	return
+	return worker.Radiance(ray, aov)
 }
 
 // NOTE: we return the raydata here even though it is ignored in order to ensure that ray is
 // heap allocated (for alignment purposes)
-func renderFunc(n int, frame *Frame, c chan *WorkItem, done chan *WorkItem, wg *sync.WaitGroup) *RayData {
+func renderFunc(frame *Frame, c chan *WorkItem, done chan *WorkItem, tiles *sync.Pool, wg *sync.WaitGroup) *RayData {
 	defer wg.Done()
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sampler := frame.sampler.Clone()
+	rnd := rand.New(&samplerSource{sampler})
+
+	// Samples near the edge of a tile contribute to pixels in neighbouring tiles so
+	// each tile is accumulated with a border wide enough for the filter and then merged.
+	border := int(m.Ceil(frame.filter.Radius()))
+	aov := frame.rc.newAOVSample()
+	worker := frame.integrator.NewWorker(frame.rc)
+	pool := &workerPool{}
 
 	ray := &RayData{}
 	for w := range c {
+		tile := tiles.Get().(*FrameBuffer)
+		tile.Reset(w.x-border, w.y-border, w.w+2*border, w.h+2*border)
+
+		if sw, ok := worker.(SplattingWorker); ok {
+			sw.StartTile(tile, w.index)
+		}
+
 		for j := 0; j < w.h; j++ {
 			for i := 0; i < w.w; i++ {
-				r, g, b := samplePixel(i+w.x, j+w.y, frame, rnd, ray)
+				x, y := i+w.x, j+w.y
+
+				if !frame.isActive(x, y) {
+					continue
+				}
+
+				// Seeded by the position in the full frame so that crops match full renders.
+				sampler.StartPixelSample(x+frame.rc.rasterX, y+frame.rc.rasterY, w.iter)
+
+				sx := float32(x) + rnd.Float32()
+				sy := float32(y) + rnd.Float32()
+
+				c := samplePixel(sx, sy, frame, rnd, ray, worker, aov, pool)
 
-				w.samples[((i+w.x)+(j+w.y)*frame.w)*3+0] = (w.samples[((i+w.x)+(j+w.y)*frame.w)*3+0]*float32(n) + m.Clamp(r*1000, 0, 255)) / float32(n+1)
-				w.samples[((i+w.x)+(j+w.y)*frame.w)*3+1] = (w.samples[((i+w.x)+(j+w.y)*frame.w)*3+1]*float32(n) + m.Clamp(g*1000, 0, 255)) / float32(n+1)
-				w.samples[((i+w.x)+(j+w.y)*frame.w)*3+2] = (w.samples[((i+w.x)+(j+w.y)*frame.w)*3+2]*float32(n) + m.Clamp(b*1000, 0, 255)) / float32(n+1)
+				tile.Splat(x, y, sx, sy, c, aov, frame.filter)
 
 				if frame.bar != nil {
 					frame.bar.Increment()
@@ -219,30 +310,44 @@ func renderFunc(n int, frame *Frame, c chan *WorkItem, done chan *WorkItem, wg *
 			}
 		}
 
+		w.tile = tile
 		done <- w
 	}
 
 	return ray
 }
 
-func tonemap(w, h int, hdrRGB []float32, buf []uint8) {
-	// Tone map into buffer
-	for i := 0; i < w*h*3; i += 3 {
-		buf[i] = uint8(hdrRGB[i])
-		buf[i+1] = uint8(hdrRGB[i+1])
-		buf[i+2] = uint8(hdrRGB[i+2])
+// tonemap converts the linear hdrRGB image into 24bit sRGB in buf, scaling by 2^exposure.
+func tonemap(w, h int, exposure float32, hdrRGB []float32, buf []uint8) {
+	scale := m.Pow(2, exposure)
 
+	for i := 0; i < w*h*3; i++ {
+		buf[i] = uint8(m.Clamp(linearToSRGB(hdrRGB[i]*scale)*255+0.5, 0, 255))
 	}
 
 }
 
+// linearToSRGB applies the sRGB transfer function (OETF) to the linear value v.
+func linearToSRGB(v float32) float32 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+
+	return 1.055*m.Pow(v, 1/2.4) - 0.055
+}
+
 // FrameAspect returns the aspect ration of the global frame size (W/H).
 func (rc *RenderContext) FrameAspect() float32 {
 	return float32(rc.globals.XRes) / float32(rc.globals.YRes)
 }
 
 // Render is called to begin the render process. If maxIter >= 0 only that many iterations
-// will be performed before exiting.
+// will be performed before exiting.  The render also ends once adaptive sampling has finished
+// every pixel or the time limit in Globals is reached (checked between iterations).
+//
+// If Globals.Checkpoint is set the state is saved every CheckpointInterval seconds and when
+// the render ends, after Resume the render continues from the saved state.  Iterations are
+// counted from the start of the original render.
 func (rc *RenderContext) Render(maxIter int) (stats Stats, err error) {
 	// render frames as given in frames (could be progressive)
 	var frame Frame
@@ -259,76 +364,179 @@ func (rc *RenderContext) Render(maxIter int) (stats Stats, err error) {
 
 	frame.rc = rc
 	frame.scene = &rc.scene
-	frame.w = rc.globals.XRes
-	frame.h = rc.globals.YRes
-	frame.du = 2.0 / float32(frame.w)
-	frame.dv = 2.0 / float32(frame.h)
+
+	x, y, w, h, err := rc.globals.window()
+
+	if err != nil {
+		return stats, err
+	}
+
+	frame.w, frame.h = w, h
+	rc.rasterX, rc.rasterY = x, rc.globals.YRes-y-h
+
+	if frame.filter, err = NewFilter(rc.globals.Filter, rc.globals.FilterWidth); err != nil {
+		return stats, fmt.Errorf("filter %v: %v", rc.globals.Filter, err)
+	}
+
+	if frame.integrator, err = rc.findIntegrator(); err != nil {
+		return stats, err
+	}
+
+	if frame.sampler, err = NewSampler(rc.globals.Sampler, rc.globals.Seed, rc.globals.MaxSamples); err != nil {
+		return stats, fmt.Errorf("sampler %v: %v", rc.globals.Sampler, err)
+	}
+
+	size := rc.globals.TileSize
+
+	if size <= 0 {
+		size = TILESIZE
+	}
+
+	items, err := tileOrder(rc.globals.BucketOrder, frame.w, frame.h, size, rc.globals.Seed)
+
+	if err != nil {
+		return stats, fmt.Errorf("bucket order %v: %v", rc.globals.BucketOrder, err)
+	}
+
+	// Tile buffers, each has a border for the filter.
+	border := int(m.Ceil(frame.filter.Radius()))
+	tiles := &sync.Pool{New: func() interface{} {
+		return NewFrameBuffer(size+2*border, size+2*border, rc.aovs)
+	}}
 
 	if rc.globals.UseProgress {
-		frame.bar = pb.StartNew(rc.globals.XRes * rc.globals.YRes)
+		frame.bar = pb.StartNew(frame.w * frame.h)
+	}
+
+	rc.framebuf = NewFrameBuffer(frame.w, frame.h, rc.aovs)
+
+	for i := range items {
+		items[i].fb = rc.framebuf
+	}
+	rc.imgbuf = make([]float32, frame.w*frame.h*3)
+
+	checkpoints := rc.globals.Checkpoint != ""
+
+	if checkpoints && !resumable(frame.integrator) {
+		log.Printf("Warning: checkpoints disabled, %v", ErrNotResumable)
+		checkpoints = false
+	}
+
+	// Iteration to start from and time spent by earlier runs.
+	start := 0
+	var elapsed time.Duration
+
+	if rc.resume {
+		if !checkpoints {
+			return stats, ErrNotResumable
+		}
+
+		if start, elapsed, err = rc.readCheckpoint(); err != nil {
+			return stats, fmt.Errorf("checkpoint %v: %v", rc.globals.Checkpoint, err)
+		}
+
+		log.Printf("Resuming from %v at iteration %v", rc.globals.Checkpoint, start)
+
+		if rc.globals.adaptive() {
+			frame.updateActive(&rc.globals)
+		}
+
+		rc.framebuf.Resolve(rc.imgbuf)
+
+		if maxIter >= 0 && start >= maxIter {
+			stats.Iterations = start
+			rc.framebuf.sampleStats(&stats)
+			return stats, nil
+		}
+	}
+
+	// The goroutines render tiles until the render ends.  The channels can hold every tile
+	// so an iteration is issued without waiting and the workers never wait to return tiles.
+	var wg sync.WaitGroup
+	workChan := make(chan *WorkItem, len(items))
+	done := make(chan *WorkItem, len(items))
+	pending := make(map[int]*WorkItem)
+
+	log.Printf("Rendering %vx%v with %v goroutines", frame.w, frame.h, rc.globals.Threads())
+
+	for n := 0; n < rc.globals.Threads(); n++ {
+		wg.Add(1)
+		go renderFunc(&frame, workChan, done, tiles, &wg)
 	}
 
-	buf := make([]float32, frame.w*frame.h*3)
+	defer func() {
+		close(workChan)
+		wg.Wait()
+	}()
 
 	startTime := time.Now()
+	lastCheckpoint := startTime
 
 L:
-	for k := 0; true; k++ {
+	for k := start; true; k++ {
 
 		if maxIter >= 0 && k >= maxIter-1 {
 			rc.Finish()
 		}
 
-		var wg sync.WaitGroup
-		workChan := make(chan *WorkItem)
-		done := make(chan *WorkItem)
+		n := 0
+
+		for i := range items {
+			w := &items[i]
 
-		for n := 0; n < rc.globals.MaxGoRoutines; n++ {
-			wg.Add(1)
-			go renderFunc(k, &frame, workChan, done, &wg)
+			if frame.tileActive(w.x, w.y, w.w, w.h) {
+				w.iter, w.seq = k, n
+				workChan <- w
+				n++
+			}
 		}
 
-		complete := make(chan []float32)
-		go func() {
-			var q []*WorkItem
-			for d := range done {
-				q = append(q, d)
+		// Tiles are merged in the order they were issued so that the sums for pixels
+		// overlapped by several tiles are the same whichever goroutines render them.
+		for next := 0; next < n; {
+			d := <-done
+			pending[d.seq] = d
+
+			for w, ok := pending[next]; ok; w, ok = pending[next] {
+				w.fb.Merge(w.tile)
+				tiles.Put(w.tile)
+				w.tile = nil
+				delete(pending, next)
+				next++
 			}
-			/*
-				for k := range q {
-					for j := 0; j < q[k].h; j++ {
-						for i := 0; i < q[k].w; i++ {
-							buf[((i+q[k].x)+(j+q[k].y)*frame.w)*3+0] = q[k].samples[(i+(j*q[k].w))*3+0]
-							buf[((i+q[k].x)+(j+q[k].y)*frame.w)*3+1] = q[k].samples[(i+(j*q[k].w))*3+1]
-							buf[((i+q[k].x)+(j+q[k].y)*frame.w)*3+2] = q[k].samples[(i+(j*q[k].w))*3+2]
-						}
-					}
-				}
-			*/
-			complete <- buf
-		}()
+		}
 
-		for j := 0; j < frame.h; j += TILESIZE {
-			for i := 0; i < frame.w; i += TILESIZE {
+		if pi, ok := frame.integrator.(PassIntegrator); ok {
+			pi.EndPass(rc)
+		}
 
-				workChan <- &WorkItem{x: i, y: j, w: TILESIZE, h: TILESIZE, samples: buf /* make([]float32, TILESIZE*TILESIZE*3)*/}
-			}
+		rc.framebuf.Resolve(rc.imgbuf)
+
+		if rc.globals.adaptive() && frame.updateActive(&rc.globals) == 0 {
+			log.Printf("All pixels converged")
+			rc.Finish()
 		}
 
-		close(workChan)
-		wg.Wait()
-		close(done)
+		if limit := rc.globals.TimeLimit; limit > 0 && (elapsed+time.Since(startTime)).Seconds() >= float64(limit) {
+			log.Printf("Time limit reached")
+			rc.Finish()
+		}
 
-		rc.imgbuf = <-complete
+		if checkpoints && time.Since(lastCheckpoint).Seconds() >= float64(rc.globals.CheckpointInterval) {
+			if err := rc.writeCheckpoint(k, elapsed+time.Since(startTime)); err != nil {
+				log.Printf("Warning: checkpoint: %v", err)
+			}
+			lastCheckpoint = time.Now()
+		}
 
 		if rc.preview != nil {
 			fr := PreviewFrame{
-				W:   rc.globals.XRes,
-				H:   rc.globals.YRes,
-				Buf: make([]uint8, 3*rc.globals.XRes*rc.globals.YRes),
+				W:   frame.w,
+				H:   frame.h,
+				Buf: make([]uint8, 3*frame.w*frame.h),
 			}
 
-			tonemap(rc.globals.XRes, rc.globals.YRes, rc.imgbuf, fr.Buf)
+			tonemap(frame.w, frame.h, rc.globals.Exposure, rc.imgbuf, fr.Buf)
 
 			rc.preview.UpdateFrame(fr)
 		}
@@ -340,10 +548,25 @@ L:
 			}
 			duration := time.Since(startTime)
 
+			if checkpoints {
+				// Allows the render to be continued with more iterations.
+				if err := rc.writeCheckpoint(k, elapsed+duration); err != nil {
+					log.Printf("Warning: checkpoint: %v", err)
+				}
+			}
+
 			stats.Duration = duration
+			stats.Iterations = k + 1
 			stats.RayCount = rayCount
 			stats.ShadowRayCount = shadowRays
+			rc.framebuf.sampleStats(&stats)
 			log.Printf("%v iterations, %v (%v rays, %v shadow) %v Mr/sec", k+1, duration, rayCount, shadowRays, float64(rayCount)/(1000000.0*duration.Seconds()))
+
+			log.Printf("%v/%.1f/%v samples per pixel (min/mean/max)", stats.MinSamples, stats.MeanSamples, stats.MaxSamples)
+
+			if rc.framebuf.Rejected > 0 {
+				log.Printf("%v non-finite samples rejected", rc.framebuf.Rejected)
+			}
 			break L
 		default:
 		}
@@ -407,6 +630,10 @@ func (rc *RenderContext) AddNode(node Node) {
 		rc.scene.prims = append(rc.scene.prims, t)
 	case Light:
 		rc.scene.lights = append(rc.scene.lights, t)
+
+		if bg, ok := t.(BackgroundLight); ok {
+			rc.scene.background = append(rc.scene.background, bg)
+		}
 	case Material:
 		rc.addMaterial(t)
 	case *Globals:
@@ -0,0 +1,273 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"math/bits"
+	"strings"
+)
+
+// ErrUnknownSampler is returned by NewSampler if the sampler name isn't recognised.
+var ErrUnknownSampler = errors.New("Unknown sampler")
+
+// Sampler generates the random numbers for camera samples.  Each pixel sample reads a sequence
+// of values in [0,1), one per dimension, which depend only on the pixel, the sample index and
+// the seed so that renders are repeatable whichever goroutine takes the sample.
+//
+// Render reads the values through a rand.Rand so each Float32 or Float64 drawn by the camera,
+// integrator and shaders takes the next dimension.  The pixel position takes dimensions 0 and
+// 1, the wavelength 2 and the time 3.  Integer choices should scale a Float32 or Float64 (e.g.
+// int(u*n)), the bounded integer methods of rand.Rand (Intn etc.) aren't stratified and may read
+// more than one dimension (see sampleInt63).
+type Sampler interface {
+	// StartPixelSample starts sample index of pixel x,y from dimension 0.
+	StartPixelSample(x, y, index int)
+
+	// Get1D returns the value for the next dimension.
+	Get1D() float64
+
+	// Clone returns a new sampler with the same settings, for use by another goroutine.
+	Clone() Sampler
+}
+
+// NewSampler returns the sampler with the given name ("sobol", "cmj" or "independent") and
+// seed.  samples is the expected number of samples per pixel, the CMJ patterns are this size.
+func NewSampler(name string, seed, samples int) (Sampler, error) {
+	switch strings.ToLower(name) {
+	case "", "sobol":
+		return &SobolSampler{Seed: seed}, nil
+	case "cmj":
+		if samples <= 0 {
+			samples = 64
+		}
+		return &CMJSampler{Seed: seed, Samples: samples}, nil
+	case "independent", "random":
+		return &IndependentSampler{Seed: seed}, nil
+	}
+
+	return nil, ErrUnknownSampler
+}
+
+// mixBits is a 64 bit hash finalizer.
+func mixBits(v uint64) uint64 {
+	v ^= v >> 31
+	v *= 0x7fb5d329728ea185
+	v ^= v >> 27
+	v *= 0x81dadef4bc2dd44d
+	v ^= v >> 33
+	return v
+}
+
+// hash combines the values into a 64 bit hash.
+func hash(v ...uint64) (h uint64) {
+	for _, x := range v {
+		h = mixBits(h ^ mixBits(x+0x9e3779b97f4a7c15))
+	}
+	return
+}
+
+// pixelSeed returns the seed for pixel x,y.
+func pixelSeed(x, y, seed int) uint64 {
+	return hash(uint64(uint32(x))|uint64(uint32(y))<<32, uint64(seed))
+}
+
+// IndependentSampler returns uncorrelated uniform random values.
+type IndependentSampler struct {
+	Seed int
+
+	pixel uint64
+	index uint64
+	dim   uint64
+}
+
+// StartPixelSample implements Sampler.
+func (s *IndependentSampler) StartPixelSample(x, y, index int) {
+	s.pixel = pixelSeed(x, y, s.Seed)
+	s.index = uint64(index)
+	s.dim = 0
+}
+
+// Get1D implements Sampler.
+func (s *IndependentSampler) Get1D() float64 {
+	h := hash(s.pixel, s.index, s.dim)
+	s.dim++
+
+	return float64(h>>11) / (1 << 53)
+}
+
+// Clone implements Sampler.
+func (s *IndependentSampler) Clone() Sampler {
+	return &IndependentSampler{Seed: s.Seed}
+}
+
+// CMJSampler generates correlated multi-jittered samples (Kensler 2013), each pair of
+// dimensions is a 2D pattern of Samples points with its own permutation.  Further samples
+// start new patterns.
+type CMJSampler struct {
+	Seed    int
+	Samples int
+
+	pixel uint64
+	index int
+	dim   uint64
+	y     float64 // Second value of the current pair
+}
+
+// StartPixelSample implements Sampler.
+func (s *CMJSampler) StartPixelSample(x, y, index int) {
+	s.pixel = pixelSeed(x, y, s.Seed)
+	s.index = index
+	s.dim = 0
+}
+
+// Get1D implements Sampler.
+func (s *CMJSampler) Get1D() (v float64) {
+	if s.dim&1 == 0 {
+		p := hash(s.pixel, s.dim, uint64(s.index/s.Samples))
+		v, s.y = sample.CMJ2(s.index%s.Samples, s.Samples, int(uint32(p)))
+	} else {
+		v = s.y
+	}
+
+	s.dim++
+	return
+}
+
+// Clone implements Sampler.
+func (s *CMJSampler) Clone() Sampler {
+	return &CMJSampler{Seed: s.Seed, Samples: s.Samples}
+}
+
+// SobolSampler generates Owen scrambled Sobol points, after Burley's "Practical Hash-based
+// Owen Scrambling" (JCGT 2020).  Dimensions are taken from the first 4 dimensions of the
+// Sobol sequence in groups of 4, each group shuffles the sample order with its own scramble
+// of the index so the groups aren't correlated.  Each pixel has its own scrambles.
+type SobolSampler struct {
+	Seed int
+
+	pixel uint64
+	index uint32
+	dim   uint64
+}
+
+// sobolMatrices are the generator matrices (as direction numbers) of the first 4 dimensions
+// of the Sobol sequence.
+var sobolMatrices [4][32]uint32
+
+func init() {
+	// Primitive polynomial degree s and coefficients a, and initial direction numbers m
+	// (Joe & Kuo).  The first dimension is the van der Corput sequence.
+	params := []struct {
+		s, a uint
+		m    []uint32
+	}{
+		{1, 0, []uint32{1}},
+		{2, 1, []uint32{1, 3}},
+		{3, 1, []uint32{1, 3, 1}},
+	}
+
+	for k := range sobolMatrices[0] {
+		sobolMatrices[0][k] = 1 << (31 - uint(k))
+	}
+
+	for d, p := range params {
+		v := &sobolMatrices[d+1]
+
+		for k := range v {
+			if uint(k) < p.s {
+				v[k] = p.m[k] << (31 - uint(k))
+				continue
+			}
+
+			v[k] = v[k-int(p.s)] ^ (v[k-int(p.s)] >> p.s)
+
+			for j := uint(1); j < p.s; j++ {
+				if (p.a>>(p.s-1-j))&1 != 0 {
+					v[k] ^= v[k-int(j)]
+				}
+			}
+		}
+	}
+}
+
+// sobol returns dimension dim of Sobol point index as a 32 bit fraction.
+func sobol(index uint32, dim int) (x uint32) {
+	for k := 0; index != 0; k++ {
+		if index&1 != 0 {
+			x ^= sobolMatrices[dim][k]
+		}
+		index >>= 1
+	}
+	return
+}
+
+// nestedUniformScramble Owen scrambles the 32 bit fraction x with the given seed.
+func nestedUniformScramble(x, seed uint32) uint32 {
+	// Laine-Karras hash of the reversed bits, each bit only depends on the bits below it.
+	x = bits.Reverse32(x)
+	x += seed
+	x ^= x * 0x6c50b47c
+	x ^= x * 0xb82f1e52
+	x ^= x * 0xc7afe638
+	x ^= x * 0x8d22f6e6
+
+	return bits.Reverse32(x)
+}
+
+// StartPixelSample implements Sampler.
+func (s *SobolSampler) StartPixelSample(x, y, index int) {
+	s.pixel = pixelSeed(x, y, s.Seed)
+	s.index = uint32(index)
+	s.dim = 0
+}
+
+// Get1D implements Sampler.
+func (s *SobolSampler) Get1D() float64 {
+	group := hash(s.pixel, s.dim/4)
+	d := int(s.dim % 4)
+	s.dim++
+
+	index := nestedUniformScramble(s.index, uint32(group))
+	x := nestedUniformScramble(sobol(index, d), uint32(hash(group, uint64(d))))
+
+	return float64(x) / (1 << 32)
+}
+
+// Clone implements Sampler.
+func (s *SobolSampler) Clone() Sampler {
+	return &SobolSampler{Seed: s.Seed}
+}
+
+// sampleInt63 returns the value u in [0,1) as a rand.Source.Int63 result.  rand.Rand computes
+// Float32 and Float64 from the high bits so u is stored in bits 10-62, small changes in u give
+// small changes in the floats.  The bounded integer methods mask or take the remainder of the
+// low bits, so the top 9 bits of u are repeated in bits 0-8 rather than leaving them 0 (fewer
+// than 10 so that Float64 still rounds to u).  Those integers still aren't a monotonic function
+// of u.
+func sampleInt63(u float64) int64 {
+	v := uint64(u * (1 << 53))
+
+	if v >= 1<<53 {
+		// Rounding can give 1.
+		v = 1<<53 - 1
+	}
+
+	return int64(v<<10 | v>>44)
+}
+
+// samplerSource is a rand.Source reading from a Sampler.
+type samplerSource struct {
+	sampler Sampler
+}
+
+// Int63 implements rand.Source.
+func (s *samplerSource) Int63() int64 {
+	return sampleInt63(s.sampler.Get1D())
+}
+
+// Seed implements rand.Source, the values come from the sampler so it does nothing.
+func (s *samplerSource) Seed(seed int64) {}
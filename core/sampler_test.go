@@ -0,0 +1,192 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// constSource is a rand.Source returning sampleInt63(u).
+type constSource struct {
+	u float64
+}
+
+func (s *constSource) Int63() int64    { return sampleInt63(s.u) }
+func (s *constSource) Seed(seed int64) {}
+
+func TestSampleInt63(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	values := []float64{0, 0.25, 0.5, 1 - 1.0/(1<<53), 1 - 1.0/(1<<40)}
+
+	// Samplers return multiples of 2^-53.
+	for i := 0; i < 1000; i++ {
+		values = append(values, float64(rnd.Int63n(1<<53))/(1<<53))
+	}
+
+	for _, u := range values {
+		// Float64 must return u exactly without reading a second value.
+		src := &constSource{u}
+
+		if f := rand.New(src).Float64(); f != u {
+			t.Errorf("u=%v: Float64 = %v", u, f)
+		}
+	}
+
+	// The low bits aren't constant so bounded integers take both values.
+	var counts [2]int
+
+	for i := 0; i < 1000; i++ {
+		counts[rand.New(&constSource{rnd.Float64()}).Int63n(2)]++
+	}
+
+	if counts[0] == 0 || counts[1] == 0 {
+		t.Errorf("Int63n(2) counts %v", counts)
+	}
+}
+
+// samples returns n dimensions of sample index of pixel x,y.
+func samples(s Sampler, x, y, index, n int) []float64 {
+	s.StartPixelSample(x, y, index)
+
+	v := make([]float64, n)
+
+	for i := range v {
+		v[i] = s.Get1D()
+	}
+
+	return v
+}
+
+func TestSamplerRepeat(t *testing.T) {
+	for _, name := range []string{"sobol", "cmj", "independent"} {
+		s, err := NewSampler(name, 7, 16)
+
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+
+		a := samples(s, 3, 5, 2, 20)
+		samples(s, 4, 5, 2, 20)
+		b := samples(s, 3, 5, 2, 20)
+		c := samples(s.Clone(), 3, 5, 2, 20)
+
+		other, _ := NewSampler(name, 8, 16)
+		d := samples(other, 3, 5, 2, 20)
+
+		same := true
+
+		for i := range a {
+			if a[i] < 0 || a[i] >= 1 {
+				t.Errorf("%v: dimension %v = %v outside [0,1)", name, i, a[i])
+			}
+
+			if a[i] != b[i] || a[i] != c[i] {
+				t.Errorf("%v: dimension %v doesn't repeat: %v %v %v", name, i, a[i], b[i], c[i])
+			}
+
+			if a[i] != d[i] {
+				same = false
+			}
+		}
+
+		if same {
+			t.Errorf("%v: seed doesn't change the samples", name)
+		}
+	}
+}
+
+// strata returns true if the points (x[i], y[i]) each fall in a different cell of an nx x ny
+// grid over [0,1)^2.
+func strata(x, y []float64, nx, ny int) bool {
+	seen := make(map[int]bool)
+
+	for i := range x {
+		if x[i] < 0 || x[i] >= 1 || y[i] < 0 || y[i] >= 1 {
+			return false
+		}
+
+		cell := int(x[i]*float64(nx)) + int(y[i]*float64(ny))*nx
+
+		if seen[cell] {
+			return false
+		}
+
+		seen[cell] = true
+	}
+
+	return true
+}
+
+// pattern returns dimensions dx and dy of samples first..first+n-1 of pixel x,y.
+func pattern(s Sampler, x, y, first, n, dx, dy int) (u, v []float64) {
+	for i := first; i < first+n; i++ {
+		p := samples(s, x, y, i, dy+1)
+		u = append(u, p[dx])
+		v = append(v, p[dy])
+	}
+
+	return
+}
+
+func TestSobolStratified(t *testing.T) {
+	s := &SobolSampler{Seed: 1}
+
+	for _, n := range []int{1, 2, 4, 16, 64, 256} {
+		for _, pixel := range [][2]int{{0, 0}, {17, 3}, {-2, 9}} {
+			// The first and second pair of each group of 4 dimensions.
+			for _, dims := range [][2]int{{0, 1}, {4, 5}, {2, 3}} {
+				u, v := pattern(s, pixel[0], pixel[1], 0, n, dims[0], dims[1])
+
+				// Each dimension is stratified.
+				if !strata(u, make([]float64, n), n, 1) || !strata(v, make([]float64, n), n, 1) {
+					t.Errorf("n=%v pixel %v dimensions %v: not stratified in 1D", n, pixel, dims)
+				}
+
+				if dims[0]%4 != 0 {
+					continue
+				}
+
+				// The first two Sobol dimensions are a (0,2)-sequence, every elementary interval
+				// of area 1/n holds one point.
+				for nx := 1; nx <= n; nx *= 2 {
+					if !strata(u, v, nx, n/nx) {
+						t.Errorf("n=%v pixel %v dimensions %v: not stratified in %vx%v", n, pixel, dims, nx, n/nx)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCMJStratified(t *testing.T) {
+	for _, n := range []int{4, 8, 9, 16, 64} {
+		s := &CMJSampler{Seed: 2, Samples: n}
+
+		mx := 1
+
+		for (mx+1)*(mx+1) <= n {
+			mx++
+		}
+
+		// Each pair of dimensions has its own pattern and samples past n start a new one.
+		for _, first := range []int{0, n} {
+			for _, dims := range [][2]int{{0, 1}, {2, 3}} {
+				u, v := pattern(s, 5, 6, first, n, dims[0], dims[1])
+
+				// The samples are a permutation of the strata in each dimension and of the
+				// jitter cells.
+				if !strata(u, make([]float64, n), n, 1) || !strata(v, make([]float64, n), n, 1) {
+					t.Errorf("n=%v samples from %v dimensions %v: not stratified in 1D", n, first, dims)
+				}
+
+				if !strata(u, v, mx, n/mx) {
+					t.Errorf("n=%v samples from %v dimensions %v: not stratified in %vx%v", n, first, dims, mx, n/mx)
+				}
+			}
+		}
+	}
+}
@@ -20,11 +20,19 @@ type Scene struct {
 	nodes  []qbvh.Node
 	bounds m.BoundingBox
 
-	lights []Light
+	lights     []Light
+	background []BackgroundLight // Lights seen by rays that leave the scene
 }
 
 var grc *RenderContext
 
+// SceneBounds returns the bounds of the primitives in the scene.  It is valid once the scene
+// has been built after PreRender, lights that need the size of the scene (e.g. distant lights)
+// call it when sampling.
+func SceneBounds() m.BoundingBox {
+	return grc.scene.bounds
+}
+
 // ScreenSample is returned by Trace.
 type ScreenSample struct {
 	Colour  colour.RGB
@@ -34,6 +42,7 @@ type ScreenSample struct {
 	Z       float64
 	ElemID  uint32
 	Prim    Primitive
+	AOV     *AOVSample // If non-nil the requested AOVs are written for the first hit
 }
 
 // TraceProbe intersects ray with the scene and sets up the globals sg with the first intersection.
@@ -58,6 +67,7 @@ func TraceProbe(ray *RayData, sg *ShaderGlobals) bool {
 		sg.Shader = mtl
 		sg.N = m.Vec3Normalize(sg.N)
 		sg.Ns = m.Vec3Normalize(sg.Ns)
+		sg.Bu, sg.Bv = ray.Result.Bu, ray.Result.Bv
 		return true
 	}
 
@@ -65,18 +75,20 @@ func TraceProbe(ray *RayData, sg *ShaderGlobals) bool {
 }
 
 // Trace intersects ray with the scene and evaluates the shader at the first intersection. The
-// result is returned in the samp struct.
+// result is returned in the samp struct, if the ray leaves the scene samp.Colour is set to the
+// background lights seen along it.
 // Returns true if any intersection or false for none.
 func Trace(ray *RayData, samp *ScreenSample) bool {
-	sg := &ShaderGlobals{
-		Ro:     ray.Ray.P,
-		Rd:     ray.Ray.D,
-		Prim:   ray.Result.Prim,
-		ElemID: ray.Result.ElemID,
-		Depth:  ray.Level,
-		rnd:    ray.rnd,
-		Lambda: ray.Lambda,
-		Time:   ray.Time,
+	sg := ray.pool.shaderGlobals()
+	defer ray.pool.putShaderGlobals(sg)
+
+	sg.Ro, sg.Rd = ray.Ray.P, ray.Ray.D
+	sg.Depth = ray.Level
+	sg.rnd = ray.rnd
+	sg.Lambda, sg.Time = ray.Lambda, ray.Time
+
+	if samp != nil {
+		sg.aov = samp.AOV
 	}
 
 	if TraceProbe(ray, sg) {
@@ -84,7 +96,11 @@ func Trace(ray *RayData, samp *ScreenSample) bool {
 			return false
 		}
 
+		sg.ElemID = ray.Result.ElemID
+		sg.Rl = float64(m.Vec3Length(m.Vec3Sub(sg.P, sg.Ro)))
+
 		sg.Shader.Eval(sg)
+		sg.writeBuiltinAOVs()
 
 		if samp != nil {
 			samp.Colour = sg.OutRGB
@@ -95,6 +111,13 @@ func Trace(ray *RayData, samp *ScreenSample) bool {
 
 		return true
 	}
+
+	if samp != nil && len(grc.scene.background) > 0 {
+		L := background(sg, ray.Type&RayCamera != 0, 0)
+		r, g, b := L.ToRGB()
+		samp.Colour = colour.RGB{r, g, b}
+	}
+
 	return false
 }
 
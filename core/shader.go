@@ -44,6 +44,7 @@ type ShaderGlobals struct {
 	Rl          float64        // Ray length (|Ro-P|)
 	ElemID      uint32         // Element ID (triangle, curve etc.)
 	Prim        Primitive      // primitive pointer
+	PrimID      int32          // Index of primitive in the scene
 	Psg         *ShaderGlobals // Parent (last shaded)
 	Shader      Material
 
@@ -60,12 +61,16 @@ type ShaderGlobals struct {
 	Ld     m.Vec3          // Incident direction
 	Li     colour.Spectrum // incoming intensity
 	Liu    colour.Spectrum // unoccluded incoming
+	Lpdf   float32         // solid angle PDF of the light sample
+	Lelem  uint32          // Element of the light the sample is on (see LightSample.ElemID)
 
 	Area float32
 
 	OutRGB colour.RGB
 
-	rnd *rand.Rand
+	rnd  *rand.Rand
+	aov  *AOVSample  // AOV values, only non-nil for the first hit of camera rays
+	pool *workerPool // Reusable rays for the render goroutine
 }
 
 // Rand returns the rng in use.
@@ -175,7 +180,8 @@ retry:
 // EvaluateLightSample will evaluate the MIS sample for the current light sample and given BRDF.
 func (sg *ShaderGlobals) EvaluateLightSample(brdf BSDF) colour.RGB {
 	// The brdf returns directions in the tangent space
-	ray := new(RayData)
+	ray := sg.pool.ray()
+	defer sg.pool.putRay(ray)
 
 	if m.Vec3Dot(sg.Ld, sg.Ng) < 0 {
 		ray.Init(RayShadow, sg.OffsetP(-1), m.Vec3Scale(sg.Ldist*(1.0-VisRayEpsilon), sg.Ld), 1.0, sg)
@@ -184,7 +190,10 @@ func (sg *ShaderGlobals) EvaluateLightSample(brdf BSDF) colour.RGB {
 
 	}
 
-	if !TraceProbe(ray, &ShaderGlobals{}) { // for shadow rays sg is not modified so to avoid allocations reuse it here
+	probe := sg.pool.shaderGlobals() // Shadow rays don't use the hit
+	defer sg.pool.putShaderGlobals(probe)
+
+	if !TraceProbe(ray, probe) {
 
 		rho := brdf.Eval(sg.WorldToTangent(sg.Ld))
 
@@ -0,0 +1,595 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	m "github.com/jamiec7919/vermeer/math"
+	"math/rand"
+	"sync"
+)
+
+// SPPMIntegrator is a stochastic progressive photon mapper (Hachisuka & Jensen 2009).  Each
+// render iteration is a pass: camera rays are followed through specular surfaces to the
+// first non-specular hit (the visible point of the pixel), then photons are traced from the
+// lights and the photons landing within a radius of each visible point are gathered.  The
+// radius of each pixel shrinks as photons are gathered so the estimate converges, including
+// caustics seen directly or through specular surfaces which path tracing can't find.
+//
+// With DirectLighting the lights are sampled at the visible points and photons only count from
+// their second hit, otherwise all the lighting comes from photons.  Globals.MaxDepth limits
+// both the camera and photon paths.  All samples in a pass share a wavelength.
+//
+// The photons of a pass are traced in sppmBatches batches, each seeded from Globals.Seed, the
+// pass and the batch, and the photons gathered by each batch are added to the pixels in batch
+// order so the image doesn't depend on the number of goroutines.
+type SPPMIntegrator struct {
+	NodeName       string  `node:"Name"`
+	Photons        int     // Photons per pass, 0 for the number of pixels
+	Radius         float32 // Initial gather radius, 0 to choose from the scene size
+	Alpha          float32 // Fraction of the photons kept each pass (radius reduction)
+	DirectLighting bool    // Sample lights at the visible points
+
+	once     sync.Once
+	w, h     int
+	pixels   []sppmPixel
+	grid     sppmGrid
+	lambda   float32 // Wavelength for the current pass
+	rnd      *rand.Rand
+	nPhotons uint64 // Total photons emitted
+	pass     int
+	batches  [sppmBatches][]sppmGather // Photons gathered by each batch in the current pass
+}
+
+// sppmBatches is the number of batches the photons of a pass are traced in.
+const sppmBatches = 64
+
+// sppmGather is a photon gathered at the visible point of a pixel.
+type sppmGather struct {
+	pixel int32
+	phi   colour.RGB
+}
+
+// NewSPPMIntegrator returns an SPPMIntegrator set to the defaults.
+func NewSPPMIntegrator() *SPPMIntegrator {
+	return &SPPMIntegrator{
+		Alpha:          0.7,
+		DirectLighting: true,
+	}
+}
+
+// sppmPixel is the state of a pixel.
+type sppmPixel struct {
+	radius float32
+	n      float32    // Accumulated photon count
+	tau    colour.RGB // Accumulated flux, scaled with the radius
+
+	// Visible point for the current pass.
+	vp struct {
+		ok      bool
+		sg      ShaderGlobals
+		closure Closure
+		beta    colour.Spectrum // Throughput from the camera
+	}
+
+	// Photons gathered this pass.
+	phi [3]float32
+	m   int32
+}
+
+// Name implements Node.
+func (sppm *SPPMIntegrator) Name() string { return sppm.NodeName }
+
+// PreRender implements Node.
+func (sppm *SPPMIntegrator) PreRender(rc *RenderContext) error { return nil }
+
+// PostRender implements Node.
+func (sppm *SPPMIntegrator) PostRender(rc *RenderContext) error { return nil }
+
+// NewWorker implements Integrator.
+func (sppm *SPPMIntegrator) NewWorker(rc *RenderContext) IntegratorWorker {
+	sppm.once.Do(func() { sppm.init(rc) })
+
+	return &sppmWorker{sppm: sppm, globals: &rc.globals, shadow: &RayData{}}
+}
+
+func (sppm *SPPMIntegrator) init(rc *RenderContext) {
+	sppm.w, sppm.h = rc.OutputRes()
+	sppm.pixels = make([]sppmPixel, sppm.w*sppm.h)
+	sppm.rnd = rand.New(rand.NewSource(int64(rc.globals.Seed)))
+	sppm.lambda = (float32(720-450) * sppm.rnd.Float32()) + 450
+
+	radius := sppm.Radius
+
+	if radius == 0 {
+		b := &rc.scene.bounds
+		radius = m.Vec3Length(m.Vec3Sub(m.Vec3(b.Bounds[1]), m.Vec3(b.Bounds[0]))) / 200
+	}
+
+	for i := range sppm.pixels {
+		sppm.pixels[i].radius = radius
+	}
+}
+
+// EndPass implements PassIntegrator.  Traces the photons for the pass and updates the pixels.
+func (sppm *SPPMIntegrator) EndPass(rc *RenderContext) {
+	sppm.grid.build(sppm.pixels)
+
+	n := sppm.Photons
+
+	if n <= 0 {
+		n = sppm.w * sppm.h
+	}
+
+	var wg sync.WaitGroup
+
+	queue := make(chan int, sppmBatches)
+
+	for b := 0; b < sppmBatches; b++ {
+		queue <- b
+	}
+
+	close(queue)
+
+	for k := 0; k < rc.globals.Threads(); k++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			t := newPhotonTracer(sppm, &rc.globals)
+
+			for b := range queue {
+				count := n / sppmBatches
+
+				if b < n%sppmBatches {
+					count++
+				}
+
+				t.rnd.Seed(int64(hash(uint64(rc.globals.Seed), uint64(sppm.pass), uint64(b))))
+				t.gathered = sppm.batches[b][:0]
+
+				for i := 0; i < count; i++ {
+					t.trace()
+				}
+
+				sppm.batches[b] = t.gathered
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for _, batch := range sppm.batches {
+		for _, g := range batch {
+			p := &sppm.pixels[g.pixel]
+
+			for k := range p.phi {
+				p.phi[k] += g.phi[k]
+			}
+
+			p.m++
+		}
+	}
+
+	sppm.nPhotons += uint64(n)
+	sppm.pass++
+
+	fb := rc.framebuf
+
+	for y := 0; y < sppm.h; y++ {
+		for x := 0; x < sppm.w; x++ {
+			p := &sppm.pixels[x+y*sppm.w]
+
+			if p.m > 0 {
+				// Keep a fraction alpha of the new photons and shrink the radius to match.
+				n := p.n + sppm.Alpha*float32(p.m)
+				r := p.radius * m.Sqrt(n/(p.n+float32(p.m)))
+				scale := (r * r) / (p.radius * p.radius)
+
+				for k := range p.tau {
+					p.tau[k] = (p.tau[k] + p.phi[k]) * scale
+				}
+
+				p.n, p.radius = n, r
+			}
+
+			p.phi = [3]float32{}
+			p.m = 0
+
+			L := p.tau
+			L.Scale(1 / (float32(sppm.nPhotons) * m.Pi * p.radius * p.radius))
+
+			fb.SetLight(x, y, L)
+		}
+	}
+
+	fb.LightPaths = uint64(fb.W * fb.H)
+
+	sppm.lambda = (float32(720-450) * sppm.rnd.Float32()) + 450
+}
+
+// sppmWorker is the IntegratorWorker for the camera pass of the SPPMIntegrator.
+type sppmWorker struct {
+	sppm    *SPPMIntegrator
+	globals *Globals
+	shadow  *RayData // Heap allocated for alignment
+	shadowg ShaderGlobals
+}
+
+// Radiance implements IntegratorWorker.  Finds the visible point for the pixel and returns
+// the light reaching the camera other than through the visible point's photons.
+func (w *sppmWorker) Radiance(ray *RayData, aov *AOVSample) colour.RGB {
+	var L, beta colour.Spectrum
+
+	if ray.X < 0 || ray.Y < 0 || ray.X >= w.sppm.w || ray.Y >= w.sppm.h {
+		return colour.RGB{}
+	}
+
+	p := &w.sppm.pixels[ray.X+ray.Y*w.sppm.w]
+	p.vp.ok = false
+
+	ray.Lambda = w.sppm.lambda
+
+	L.Lambda = ray.Lambda
+	beta.Lambda = ray.Lambda
+	beta.Set(1)
+
+	sg := &p.vp.sg
+	closure := &p.vp.closure
+
+	for depth := 0; depth <= w.globals.MaxDepth; depth++ {
+		*sg = ShaderGlobals{
+			X:       ray.X,
+			Y:       ray.Y,
+			Ro:      ray.Ray.P,
+			Rd:      ray.Ray.D,
+			RayType: uint16(ray.Type),
+			Depth:   ray.Level,
+			rnd:     ray.rnd,
+			Lambda:  ray.Lambda,
+			Time:    ray.Time,
+		}
+
+		if depth == 0 {
+			sg.aov = aov
+		}
+
+		if !TraceProbe(ray, sg) {
+			if len(grc.scene.background) > 0 {
+				Le := background(sg, depth == 0, 0)
+				Le.Mul(beta)
+				L.Add(Le)
+			}
+			break
+		}
+
+		if sg.Shader == nil {
+			break
+		}
+
+		sg.ElemID = ray.Result.ElemID
+		sg.Rl = float64(m.Vec3Length(m.Vec3Sub(sg.P, sg.Ro)))
+
+		if depth == 0 {
+			sg.writeBuiltinAOVs()
+			sg.aov = nil
+		}
+
+		// Only camera and specular paths reach here so emission can't be found another way.
+		if sg.Shader.HasEDF() {
+			var Le colour.Spectrum
+
+			E := sg.Shader.Emission(sg, sg.ViewDirection())
+			Le.Lambda = sg.Lambda
+			Le.FromRGB(E[0], E[1], E[2])
+			Le.Mul(beta)
+			L.Add(Le)
+		}
+
+		closure.Reset(sg)
+		sg.Shader.Closure(sg, closure)
+
+		if len(closure.Lobes) == 0 {
+			break
+		}
+
+		if closure.NonSpecular() {
+			if w.sppm.DirectLighting {
+				Ld := w.directLighting(sg, closure)
+				Ld.Mul(beta)
+				L.Add(Ld)
+			}
+
+			p.vp.ok = true
+			p.vp.beta = beta
+			break
+		}
+
+		omegaO, f, pdf, ty := closure.Sample(sg.rnd.Float64(), sg.rnd.Float64(), sg.rnd.Float64())
+
+		if !(pdf > 0) {
+			break
+		}
+
+		f.Scale(1 / pdf)
+		beta.Mul(f)
+
+		sg.Depth = uint8(depth + 1)
+
+		offsetRay(ray, scatterRayType(ty), sg, sg.TangentToWorld(omegaO), m.Inf(1))
+	}
+
+	r, g, b := L.ToRGB()
+	return colour.RGB{r, g, b}
+}
+
+// directLighting returns the light sampled direct illumination at the point in sg.
+func (w *sppmWorker) directLighting(sg *ShaderGlobals, closure *Closure) (Ld colour.Spectrum) {
+	Ld.Lambda = sg.Lambda
+
+	for _, light := range grc.scene.lights {
+		if light.SampleArea(sg) != nil {
+			continue
+		}
+
+		f, _ := closure.Eval(sg.WorldToTangent(sg.Ld), ^0)
+
+		if isBlack(f) {
+			continue
+		}
+
+		offsetRay(w.shadow, RayShadow, sg, m.Vec3Scale(sg.Ldist*(1.0-VisRayEpsilon), sg.Ld), 1.0)
+
+		if TraceProbe(w.shadow, &w.shadowg) {
+			continue
+		}
+
+		f.Mul(sg.Liu)
+		f.Scale(sg.Weight)
+		Ld.Add(f)
+	}
+
+	return
+}
+
+// photonTracer is the per-goroutine state for tracing photons.
+type photonTracer struct {
+	sppm     *SPPMIntegrator
+	globals  *Globals
+	rnd      *rand.Rand
+	ray      *RayData // Heap allocated for alignment
+	sg       ShaderGlobals
+	closure  Closure
+	gathered []sppmGather // Photons gathered by the current batch
+}
+
+func newPhotonTracer(sppm *SPPMIntegrator, globals *Globals) *photonTracer {
+	return &photonTracer{
+		sppm:    sppm,
+		globals: globals,
+		rnd:     rand.New(rand.NewSource(0)),
+		ray:     &RayData{},
+	}
+}
+
+// trace follows one photon from a light chosen uniformly.  Photon paths aren't corrected for
+// the non-symmetric scattering of refraction.
+func (t *photonTracer) trace() {
+	lights := grc.scene.lights
+
+	if len(lights) == 0 {
+		return
+	}
+
+	pick := 1 / float32(len(lights))
+	light := lights[int(t.rnd.Float32()*float32(len(lights)))%len(lights)]
+
+	sg := &t.sg
+	*sg = ShaderGlobals{Lambda: t.sppm.lambda, Time: t.rnd.Float32(), rnd: t.rnd}
+
+	var ls LightSample
+
+	if light.SampleEmission(sg, &ls) != nil || ls.PdfA == 0 || ls.PdfD == 0 {
+		return
+	}
+
+	beta := ls.Le
+	beta.Scale(m.Abs(m.Vec3Dot(ls.N, ls.D)) / (pick * ls.PdfA * ls.PdfD))
+
+	emissionRay(t.ray, &ls, sg)
+
+	for depth := 0; depth <= t.globals.MaxDepth; depth++ {
+		ray := t.ray
+
+		*sg = ShaderGlobals{
+			Ro:      ray.Ray.P,
+			Rd:      ray.Ray.D,
+			RayType: uint16(ray.Type),
+			Depth:   ray.Level,
+			rnd:     ray.rnd,
+			Lambda:  ray.Lambda,
+			Time:    ray.Time,
+		}
+
+		if !TraceProbe(ray, sg) || sg.Shader == nil {
+			break
+		}
+
+		// The first hit is direct lighting, which may have been sampled already.
+		if depth > 0 || !t.sppm.DirectLighting {
+			t.gather(sg.P, m.Vec3Neg(sg.Rd), beta)
+		}
+
+		t.closure.Reset(sg)
+		sg.Shader.Closure(sg, &t.closure)
+
+		if len(t.closure.Lobes) == 0 {
+			break
+		}
+
+		omegaO, f, pdf, ty := t.closure.Sample(t.rnd.Float64(), t.rnd.Float64(), t.rnd.Float64())
+
+		if !(pdf > 0) {
+			break
+		}
+
+		f.Scale(1 / pdf)
+
+		// Russian roulette on the change in throughput, keeps photon powers similar.
+		q := m.Max(0, 1-m.Max(m.Max(f.C[0], f.C[1]), m.Max(f.C[2], f.C[3])))
+
+		if t.rnd.Float32() < q {
+			break
+		}
+
+		f.Scale(1 / (1 - q))
+		beta.Mul(f)
+
+		sg.Depth = uint8(depth + 1)
+
+		offsetRay(ray, scatterRayType(ty), sg, sg.TangentToWorld(omegaO), m.Inf(1))
+	}
+}
+
+// gather records the photon with power beta arriving at P from direction wi for the visible
+// points within their radius.
+func (t *photonTracer) gather(P, wi m.Vec3, beta colour.Spectrum) {
+	sppm := t.sppm
+
+	for _, i := range sppm.grid.lookup(P) {
+		p := &sppm.pixels[i]
+
+		if m.Vec3Length2(m.Vec3Sub(p.vp.sg.P, P)) > p.radius*p.radius {
+			continue
+		}
+
+		omegaO := p.vp.sg.WorldToTangent(wi)
+
+		if omegaO[2] == 0 {
+			continue
+		}
+
+		// The photon density accounts for the cosine.
+		f, _ := p.vp.closure.Eval(omegaO, ^0)
+		f.Scale(1 / m.Abs(omegaO[2]))
+		f.Mul(p.vp.beta)
+		f.Mul(beta)
+
+		r, g, b := f.ToRGB()
+
+		t.gathered = append(t.gathered, sppmGather{i, colour.RGB{r, g, b}})
+	}
+}
+
+// sppmGrid is a hash grid of the visible points.  Each cell lists the pixels whose visible
+// point is within their radius of the cell, cells are hashed into a table the size of the
+// image.
+type sppmGrid struct {
+	min      m.Vec3
+	cellSize float32
+	res      [3]int
+	cells    [][]int32
+}
+
+// build rebuilds the grid for the visible points of pixels.
+func (g *sppmGrid) build(pixels []sppmPixel) {
+	if len(g.cells) != len(pixels) {
+		g.cells = make([][]int32, len(pixels))
+	}
+
+	for i := range g.cells {
+		g.cells[i] = g.cells[i][:0]
+	}
+
+	var bounds m.BoundingBox
+	bounds.Reset()
+
+	maxRadius := float32(0)
+
+	for i := range pixels {
+		p := &pixels[i]
+
+		if !p.vp.ok {
+			continue
+		}
+
+		for k := 0; k < 3; k++ {
+			bounds.GrowDim(k, p.vp.sg.P[k]-p.radius)
+			bounds.GrowDim(k, p.vp.sg.P[k]+p.radius)
+		}
+
+		maxRadius = m.Max(maxRadius, p.radius)
+	}
+
+	if maxRadius == 0 {
+		g.res = [3]int{}
+		return
+	}
+
+	g.min = m.Vec3(bounds.Bounds[0])
+	g.cellSize = 2 * maxRadius
+
+	for k := 0; k < 3; k++ {
+		g.res[k] = 1 + int(bounds.Dim(k)/g.cellSize)
+	}
+
+	for i := range pixels {
+		p := &pixels[i]
+
+		if !p.vp.ok || isBlack(p.vp.beta) {
+			continue
+		}
+
+		r := m.Vec3{p.radius, p.radius, p.radius}
+		lo, _ := g.cell(m.Vec3Sub(p.vp.sg.P, r))
+		hi, _ := g.cell(m.Vec3Add(p.vp.sg.P, r))
+
+		for z := lo[2]; z <= hi[2]; z++ {
+			for y := lo[1]; y <= hi[1]; y++ {
+				for x := lo[0]; x <= hi[0]; x++ {
+					h := g.hash(x, y, z)
+					g.cells[h] = append(g.cells[h], int32(i))
+				}
+			}
+		}
+	}
+}
+
+// cell returns the cell containing P, clamped to the grid, and whether P was inside.
+func (g *sppmGrid) cell(P m.Vec3) (c [3]int, inside bool) {
+	inside = true
+
+	for k := 0; k < 3; k++ {
+		c[k] = int((P[k] - g.min[k]) / g.cellSize)
+
+		if c[k] < 0 {
+			c[k], inside = 0, false
+		} else if c[k] >= g.res[k] {
+			c[k], inside = g.res[k]-1, false
+		}
+	}
+
+	return
+}
+
+func (g *sppmGrid) hash(x, y, z int) int {
+	return int((uint32(x)*73856093 ^ uint32(y)*19349663 ^ uint32(z)*83492791) % uint32(len(g.cells)))
+}
+
+// lookup returns the pixels whose visible points may be within their radius of P.
+func (g *sppmGrid) lookup(P m.Vec3) []int32 {
+	if g.res[0] == 0 {
+		return nil
+	}
+
+	c, inside := g.cell(P)
+
+	if !inside {
+		return nil
+	}
+
+	return g.cells[g.hash(c[0], c[1], c[2])]
+}
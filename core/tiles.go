@@ -0,0 +1,117 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// ErrUnknownBucketOrder is returned by Render if Globals.BucketOrder isn't recognised.
+var ErrUnknownBucketOrder = errors.New("Unknown bucket order")
+
+// tileOrder returns the tiles covering a w x h frame buffer in the given bucket order:
+// "top-down" (default) rows of tiles from the top of the image, "spiral" outwards from the
+// centre, "hilbert" along a Hilbert curve or "random" shuffled by seed.  The order is the same
+// for every iteration.
+func tileOrder(order string, w, h, size, seed int) ([]WorkItem, error) {
+	var items []WorkItem
+
+	// Frame buffer rows run from the bottom up, tiles are aligned to the top left of the image
+	// so that the clipped tiles are at the right and bottom edges.
+	for y1 := h; y1 > 0; y1 -= size {
+		for x := 0; x < w; x += size {
+			t := WorkItem{x: x, y: y1 - size, w: size, h: size, index: len(items)}
+
+			if t.x+t.w > w {
+				t.w = w - t.x
+			}
+
+			if t.y < 0 {
+				t.h += t.y
+				t.y = 0
+			}
+
+			items = append(items, t)
+		}
+	}
+
+	// Tile grid coordinates from the top left.
+	cols := (w + size - 1) / size
+	rows := (h + size - 1) / size
+	col := func(t *WorkItem) int { return t.x / size }
+	row := func(t *WorkItem) int { return (h - t.y - t.h) / size }
+
+	var key func(t *WorkItem) float64
+
+	switch strings.ToLower(order) {
+	case "", "top-down", "topdown":
+		return items, nil
+
+	case "spiral":
+		// Rings of tiles around the centre, each ring in angle order.
+		key = func(t *WorkItem) float64 {
+			dx := float64(col(t)) - float64(cols-1)/2
+			dy := float64(row(t)) - float64(rows-1)/2
+			ring := math.Floor(math.Max(math.Abs(dx), math.Abs(dy)))
+
+			return ring*8 + math.Atan2(dy, dx) + math.Pi
+		}
+
+	case "hilbert":
+		n := 1
+
+		for n < cols || n < rows {
+			n *= 2
+		}
+
+		key = func(t *WorkItem) float64 { return float64(hilbertIndex(n, col(t), row(t))) }
+
+	case "random":
+		rnd := rand.New(rand.NewSource(int64(seed)))
+		rnd.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+		return items, nil
+
+	default:
+		return nil, ErrUnknownBucketOrder
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return key(&items[i]) < key(&items[j]) })
+
+	return items, nil
+}
+
+// hilbertIndex returns the distance of x,y along the Hilbert curve filling an n x n grid, n
+// must be a power of 2.
+func hilbertIndex(n, x, y int) (d int) {
+	for s := n / 2; s > 0; s /= 2 {
+		rx, ry := 0, 0
+
+		if x&s != 0 {
+			rx = 1
+		}
+
+		if y&s != 0 {
+			ry = 1
+		}
+
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant so the curve is continuous.
+		if ry == 0 {
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+
+			x, y = y, x
+		}
+	}
+
+	return
+}
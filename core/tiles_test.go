@@ -0,0 +1,131 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package core
+
+import "testing"
+
+func TestTileOrderPermutation(t *testing.T) {
+	sizes := []struct{ w, h, size int }{
+		{64, 64, 16},
+		{100, 37, 16},
+		{37, 100, 8},
+		{130, 70, 64},
+		{5, 3, 8},
+		{1, 1, 64},
+		{300, 7, 7},
+	}
+
+	for _, sz := range sizes {
+		topDown, err := tileOrder("", sz.w, sz.h, sz.size, 0)
+
+		if err != nil {
+			t.Fatalf("%vx%v: %v", sz.w, sz.h, err)
+		}
+
+		for _, order := range []string{"top-down", "spiral", "hilbert", "Hilbert", "random"} {
+			items, err := tileOrder(order, sz.w, sz.h, sz.size, 5)
+
+			if err != nil {
+				t.Errorf("%v %vx%v: %v", order, sz.w, sz.h, err)
+				continue
+			}
+
+			if len(items) != len(topDown) {
+				t.Errorf("%v %vx%v: %v tiles, want %v", order, sz.w, sz.h, len(items), len(topDown))
+				continue
+			}
+
+			// Every pixel is covered by exactly one tile.
+			covered := make([]int, sz.w*sz.h)
+			seen := make([]bool, len(items))
+
+			for _, it := range items {
+				if it.index < 0 || it.index >= len(items) || seen[it.index] {
+					t.Errorf("%v %vx%v: bad or repeated tile index %v", order, sz.w, sz.h, it.index)
+					break
+				}
+
+				seen[it.index] = true
+
+				if td := topDown[it.index]; td.x != it.x || td.y != it.y || td.w != it.w || td.h != it.h {
+					t.Errorf("%v %vx%v: tile index %v isn't the top-down tile", order, sz.w, sz.h, it.index)
+				}
+
+				for y := it.y; y < it.y+it.h; y++ {
+					for x := it.x; x < it.x+it.w; x++ {
+						if x < 0 || y < 0 || x >= sz.w || y >= sz.h {
+							t.Fatalf("%v %vx%v: tile %+v outside the image", order, sz.w, sz.h, it)
+						}
+
+						covered[x+y*sz.w]++
+					}
+				}
+			}
+
+			for i, n := range covered {
+				if n != 1 {
+					t.Errorf("%v %vx%v: pixel %v,%v covered %v times", order, sz.w, sz.h, i%sz.w, i/sz.w, n)
+					break
+				}
+			}
+		}
+	}
+
+	if _, err := tileOrder("zigzag", 10, 10, 4, 0); err != ErrUnknownBucketOrder {
+		t.Errorf("unknown order: %v", err)
+	}
+}
+
+func TestTileOrderRandomSeed(t *testing.T) {
+	a, _ := tileOrder("random", 200, 100, 16, 1)
+	b, _ := tileOrder("random", 200, 100, 16, 1)
+	c, _ := tileOrder("random", 200, 100, 16, 2)
+
+	same := true
+
+	for i := range a {
+		if a[i].index != b[i].index {
+			t.Fatalf("same seed gives different orders")
+		}
+
+		if a[i].index != c[i].index {
+			same = false
+		}
+	}
+
+	if same {
+		t.Errorf("seed doesn't change the order")
+	}
+}
+
+func TestHilbertIndex(t *testing.T) {
+	for n := 1; n <= 32; n *= 2 {
+		pos := make([][2]int, n*n)
+		seen := make([]bool, n*n)
+
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				d := hilbertIndex(n, x, y)
+
+				if d < 0 || d >= n*n || seen[d] {
+					t.Fatalf("n=%v: bad or repeated index %v at %v,%v", n, d, x, y)
+				}
+
+				seen[d] = true
+				pos[d] = [2]int{x, y}
+			}
+		}
+
+		// Consecutive cells along the curve are neighbours.
+		for d := 1; d < n*n; d++ {
+			dx, dy := pos[d][0]-pos[d-1][0], pos[d][1]-pos[d-1][1]
+
+			if dx*dx+dy*dy != 1 {
+				t.Errorf("n=%v: cells %v and %v aren't adjacent: %v %v", n, d-1, d, pos[d-1], pos[d])
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package image
+
+import (
+	"errors"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// ErrBufferType is returned if a pixel buffer doesn't match the TypeDesc given for it.
+var ErrBufferType = errors.New("Pixel buffer type mismatch")
+
+// ToFloat32 returns the pixels in buf, described by ty, as float32 values.  UINT8 and UINT16
+// values are mapped to [0,1].  If buf is already []float32 it is returned directly.
+func ToFloat32(ty TypeDesc, buf interface{}) ([]float32, error) {
+	switch ty.BaseType {
+	case FLOAT:
+		if pbuf, ok := buf.([]float32); ok {
+			return pbuf, nil
+		}
+	case HALF:
+		if pbuf, ok := buf.([]m.Float16); ok {
+			out := make([]float32, len(pbuf))
+
+			for i := range pbuf {
+				out[i] = m.Float16ToFloat32(pbuf[i])
+			}
+			return out, nil
+		}
+	case UINT8:
+		if pbuf, ok := buf.([]uint8); ok {
+			out := make([]float32, len(pbuf))
+
+			for i := range pbuf {
+				out[i] = float32(pbuf[i]) / 255
+			}
+			return out, nil
+		}
+	case UINT16:
+		if pbuf, ok := buf.([]uint16); ok {
+			out := make([]float32, len(pbuf))
+
+			for i := range pbuf {
+				out[i] = float32(pbuf[i]) / 65535
+			}
+			return out, nil
+		}
+	}
+
+	return nil, ErrBufferType
+}
+
+// FromFloat32 converts the float32 values in src into buf, described by ty.  UINT8 and
+// UINT16 values are clamped from [0,1].
+func FromFloat32(ty TypeDesc, src []float32, buf interface{}) error {
+	switch ty.BaseType {
+	case FLOAT:
+		if pbuf, ok := buf.([]float32); ok {
+			copy(pbuf, src)
+			return nil
+		}
+	case HALF:
+		if pbuf, ok := buf.([]m.Float16); ok {
+			for i := 0; i < len(src) && i < len(pbuf); i++ {
+				pbuf[i] = m.Float32ToFloat16(src[i])
+			}
+			return nil
+		}
+	case UINT8:
+		if pbuf, ok := buf.([]uint8); ok {
+			for i := 0; i < len(src) && i < len(pbuf); i++ {
+				pbuf[i] = uint8(m.Clamp(src[i]*255+0.5, 0, 255))
+			}
+			return nil
+		}
+	case UINT16:
+		if pbuf, ok := buf.([]uint16); ok {
+			for i := 0; i < len(src) && i < len(pbuf); i++ {
+				pbuf[i] = uint16(m.Clamp(src[i]*65535+0.5, 0, 65535))
+			}
+			return nil
+		}
+	}
+
+	return ErrBufferType
+}
@@ -0,0 +1,232 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+)
+
+var errRLECorrupt = errors.New("exr: corrupt RLE data")
+
+// predictorInterleave splits raw into its even and odd bytes and replaces each byte with
+// the difference from the previous one.  This is applied before RLE and ZIP compression.
+func predictorInterleave(raw []byte) []byte {
+	tmp := make([]byte, len(raw))
+
+	if len(raw) == 0 {
+		return tmp
+	}
+
+	t1 := 0
+	t2 := (len(raw) + 1) / 2
+
+	for i := 0; i < len(raw); i++ {
+		if i&1 == 0 {
+			tmp[t1] = raw[i]
+			t1++
+		} else {
+			tmp[t2] = raw[i]
+			t2++
+		}
+	}
+
+	p := int(tmp[0])
+
+	for i := 1; i < len(tmp); i++ {
+		d := int(tmp[i]) - p + (128 + 256)
+		p = int(tmp[i])
+		tmp[i] = byte(d)
+	}
+
+	return tmp
+}
+
+// unpredictInterleave reverses predictorInterleave, writing the result into raw.
+func unpredictInterleave(tmp []byte, raw []byte) {
+	for i := 1; i < len(tmp); i++ {
+		tmp[i] = byte(int(tmp[i-1]) + int(tmp[i]) - 128)
+	}
+
+	t1 := 0
+	t2 := (len(tmp) + 1) / 2
+
+	for i := range raw {
+		if i&1 == 0 {
+			raw[i] = tmp[t1]
+			t1++
+		} else {
+			raw[i] = tmp[t2]
+			t2++
+		}
+	}
+}
+
+const (
+	rleMinRunLength = 3
+	rleMaxRunLength = 127
+)
+
+// rleCompress run length encodes in.  Runs are stored as (count-1, value) and literal
+// sequences as (-count, values...).
+func rleCompress(in []byte) []byte {
+	out := make([]byte, 0, len(in))
+
+	runStart := 0
+	runEnd := 1
+
+	for runStart < len(in) {
+		for runEnd < len(in) && in[runStart] == in[runEnd] && runEnd-runStart-1 < rleMaxRunLength {
+			runEnd++
+		}
+
+		if runEnd-runStart >= rleMinRunLength {
+			// Compressable run
+			out = append(out, byte(runEnd-runStart-1), in[runStart])
+			runStart = runEnd
+		} else {
+			// Uncompressable run
+			for runEnd < len(in) &&
+				((runEnd+1 >= len(in) || in[runEnd] != in[runEnd+1]) ||
+					(runEnd+2 >= len(in) || in[runEnd+1] != in[runEnd+2])) &&
+				runEnd-runStart < rleMaxRunLength {
+				runEnd++
+			}
+
+			out = append(out, byte(int8(runStart-runEnd)))
+			out = append(out, in[runStart:runEnd]...)
+			runStart = runEnd
+		}
+
+		runEnd++
+	}
+
+	return out
+}
+
+// rleUncompress decodes in into out which must be the expected size.
+func rleUncompress(in []byte, out []byte) error {
+	n := 0
+
+	for len(in) > 0 {
+		count := int(int8(in[0]))
+		in = in[1:]
+
+		if count < 0 {
+			count = -count
+
+			if count > len(in) || n+count > len(out) {
+				return errRLECorrupt
+			}
+
+			copy(out[n:], in[:count])
+			in = in[count:]
+			n += count
+		} else {
+			if len(in) == 0 || n+count+1 > len(out) {
+				return errRLECorrupt
+			}
+
+			for i := 0; i <= count; i++ {
+				out[n] = in[0]
+				n++
+			}
+			in = in[1:]
+		}
+	}
+
+	if n != len(out) {
+		return errRLECorrupt
+	}
+
+	return nil
+}
+
+func zipCompress(in []byte) []byte {
+	var b bytes.Buffer
+
+	w := zlib.NewWriter(&b)
+	w.Write(in)
+	w.Close()
+
+	return b.Bytes()
+}
+
+func zipUncompress(in []byte, out []byte) error {
+	r, err := zlib.NewReader(bytes.NewReader(in))
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	_, err = io.ReadFull(r, out)
+
+	return err
+}
+
+// compressBlock compresses the raw pixel data of a block of nx by ny pixels.  If the
+// compressed data isn't smaller than raw then raw is returned as files store
+// uncompressed blocks in that case.
+func compressBlock(compression uint8, raw []byte, chans []channel, nx, ny int) []byte {
+	var out []byte
+
+	switch compression {
+	case compressRLE:
+		out = rleCompress(predictorInterleave(raw))
+	case compressZIPS, compressZIP:
+		out = zipCompress(predictorInterleave(raw))
+	case compressPIZ:
+		out = pizCompress(raw, chans, nx, ny)
+	default:
+		return raw
+	}
+
+	if len(out) >= len(raw) {
+		return raw
+	}
+
+	return out
+}
+
+// uncompressBlock decompresses the data for a block into raw, which must be the size
+// of the uncompressed block.
+func uncompressBlock(compression uint8, data []byte, raw []byte, chans []channel, nx, ny int) error {
+	if len(data) == len(raw) {
+		copy(raw, data) // Stored uncompressed
+		return nil
+	}
+
+	switch compression {
+	case compressRLE:
+		tmp := make([]byte, len(raw))
+
+		if err := rleUncompress(data, tmp); err != nil {
+			return err
+		}
+
+		unpredictInterleave(tmp, raw)
+
+	case compressZIPS, compressZIP:
+		tmp := make([]byte, len(raw))
+
+		if err := zipUncompress(data, tmp); err != nil {
+			return err
+		}
+
+		unpredictInterleave(tmp, raw)
+
+	case compressPIZ:
+		return pizUncompress(data, raw, chans, nx, ny)
+
+	default:
+		return ErrUnsupported
+	}
+
+	return nil
+}
@@ -0,0 +1,593 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package exr implements a pure Go OpenEXR reader and writer.
+
+Single part scanline and tiled images with half, float and uint channels are supported,
+using NONE, RLE, ZIPS, ZIP or PIZ compression.  Only the first level of mipmapped
+tiled images is read and subsampled channels aren't supported.
+
+The data window maps onto Spec.X, Spec.Y, Spec.Width and Spec.Height and the display
+window onto Spec.FullX, Spec.FullY, Spec.FullWidth and Spec.FullHeight.  Channels are
+presented with R, G, B and A first (as OpenImageIO does) followed by the rest in file
+order.  The compression is given by the "compression" extra attribute ("none", "rle",
+"zips", "zip" or "piz").
+*/
+package exr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jamiec7919/vermeer/image"
+	"io"
+	"math"
+	"sort"
+)
+
+const magic = 20000630
+
+// Version field flags.
+const (
+	flagTiled     = 0x200
+	flagLongNames = 0x400
+	flagNonImage  = 0x800
+	flagMultiPart = 0x1000
+)
+
+// Channel pixel types.
+const (
+	pixelUint  = 0
+	pixelHalf  = 1
+	pixelFloat = 2
+)
+
+// Compression methods.
+const (
+	compressNone  = 0
+	compressRLE   = 1
+	compressZIPS  = 2
+	compressZIP   = 3
+	compressPIZ   = 4
+	compressPXR24 = 5
+	compressB44   = 6
+	compressB44A  = 7
+)
+
+// Level modes for tiled images.
+const (
+	levelOne    = 0
+	levelMipmap = 1
+	levelRipmap = 2
+)
+
+var (
+	// ErrNotEXR is returned when opening a file that isn't an OpenEXR file.
+	ErrNotEXR = errors.New("exr: not an OpenEXR file")
+
+	// ErrUnsupported is returned for valid files using features that aren't implemented.
+	ErrUnsupported = errors.New("exr: unsupported feature")
+
+	errCorrupt = errors.New("exr: corrupt file")
+)
+
+var compressionNames = map[string]uint8{
+	"none": compressNone,
+	"rle":  compressRLE,
+	"zips": compressZIPS,
+	"zip":  compressZIP,
+	"piz":  compressPIZ,
+}
+
+func compressionName(c uint8) string {
+	for k, v := range compressionNames {
+		if v == c {
+			return k
+		}
+	}
+
+	return fmt.Sprintf("unknown(%v)", c)
+}
+
+// linesPerBlock returns the number of scanlines stored in each chunk for the compression.
+func linesPerBlock(compression uint8) int {
+	switch compression {
+	case compressZIP, compressPXR24:
+		return 16
+	case compressPIZ, compressB44, compressB44A:
+		return 32
+	}
+
+	return 1
+}
+
+type channel struct {
+	name                 string
+	pixelType            int32
+	pLinear              uint8
+	xSampling, ySampling int32
+}
+
+// size returns the size in bytes of one pixel of the channel.
+func (c *channel) size() int {
+	if c.pixelType == pixelHalf {
+		return 2
+	}
+	return 4
+}
+
+type box2i struct {
+	xMin, yMin, xMax, yMax int32
+}
+
+func (b box2i) width() int  { return int(b.xMax-b.xMin) + 1 }
+func (b box2i) height() int { return int(b.yMax-b.yMin) + 1 }
+
+// header holds the attributes of a single part image.
+type header struct {
+	channels           []channel // Sorted by name as in the file
+	compression        uint8
+	dataWindow         box2i
+	displayWindow      box2i
+	lineOrder          uint8
+	pixelAspectRatio   float32
+	screenWindowCenter [2]float32
+	screenWindowWidth  float32
+
+	tiled          bool
+	tileW, tileH   int
+	tileLevelMode  uint8
+	tileRoundingUp bool
+
+	extra map[string]interface{}
+}
+
+// bytesPerLine returns the number of bytes in a line of nx pixels of all channels.
+func (h *header) bytesPerLine(nx int) (n int) {
+	for i := range h.channels {
+		n += nx * h.channels[i].size()
+	}
+	return
+}
+
+// chunkCount returns the number of chunks in the offset table that are read (for tiled
+// images only the first level).
+func (h *header) chunkCount() int {
+	if h.tiled {
+		return h.tilesX() * h.tilesY()
+	}
+
+	lpb := linesPerBlock(h.compression)
+	return (h.dataWindow.height() + lpb - 1) / lpb
+}
+
+func (h *header) tilesX() int { return (h.dataWindow.width() + h.tileW - 1) / h.tileW }
+func (h *header) tilesY() int { return (h.dataWindow.height() + h.tileH - 1) / h.tileH }
+
+// reader wraps an io.Reader with helpers for the little-endian header types.
+type reader struct {
+	r   *bufio.Reader
+	n   int64 // bytes consumed
+	err error
+}
+
+func (r *reader) read(p []byte) {
+	if r.err != nil {
+		return
+	}
+
+	n, err := io.ReadFull(r.r, p)
+	r.n += int64(n)
+	r.err = err
+}
+
+func (r *reader) uint8() uint8 {
+	var b [1]byte
+	r.read(b[:])
+	return b[0]
+}
+
+func (r *reader) int32() int32 {
+	var b [4]byte
+	r.read(b[:])
+	return int32(binary.LittleEndian.Uint32(b[:]))
+}
+
+func (r *reader) float32() float32 {
+	var b [4]byte
+	r.read(b[:])
+	return math.Float32frombits(binary.LittleEndian.Uint32(b[:]))
+}
+
+func (r *reader) cstring() string {
+	var s []byte
+
+	for r.err == nil {
+		c := r.uint8()
+
+		if c == 0 {
+			break
+		}
+
+		s = append(s, c)
+
+		if len(s) > 255 {
+			r.err = errCorrupt
+		}
+	}
+
+	return string(s)
+}
+
+func (r *reader) box2i() (b box2i) {
+	b.xMin = r.int32()
+	b.yMin = r.int32()
+	b.xMax = r.int32()
+	b.yMax = r.int32()
+	return
+}
+
+// readHeader reads the magic number, version and header attributes.
+func readHeader(r *reader) (*header, error) {
+	if r.int32() != magic || r.err != nil {
+		return nil, ErrNotEXR
+	}
+
+	version := r.int32()
+
+	if version&0xff != 2 {
+		return nil, ErrUnsupported
+	}
+
+	if version&(flagNonImage|flagMultiPart) != 0 {
+		return nil, ErrUnsupported
+	}
+
+	h := &header{
+		tiled:            version&flagTiled != 0,
+		pixelAspectRatio: 1,
+		extra:            make(map[string]interface{}),
+	}
+
+	for r.err == nil {
+		name := r.cstring()
+
+		if name == "" {
+			break
+		}
+
+		ty := r.cstring()
+		size := int(r.int32())
+
+		if r.err != nil {
+			break
+		}
+
+		if size < 0 || size > 1<<24 {
+			return nil, errCorrupt
+		}
+
+		value := make([]byte, size)
+		r.read(value)
+
+		if err := h.setAttribute(name, ty, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	if len(h.channels) == 0 || h.dataWindow.width() <= 0 || h.dataWindow.height() <= 0 {
+		return nil, errCorrupt
+	}
+
+	if h.tiled && (h.tileW <= 0 || h.tileH <= 0) {
+		return nil, errCorrupt
+	}
+
+	for i := range h.channels {
+		if h.channels[i].xSampling != 1 || h.channels[i].ySampling != 1 {
+			return nil, ErrUnsupported
+		}
+	}
+
+	switch h.compression {
+	case compressNone, compressRLE, compressZIPS, compressZIP, compressPIZ:
+	default:
+		return nil, fmt.Errorf("exr: unsupported compression %v", h.compression)
+	}
+
+	return h, nil
+}
+
+func (h *header) setAttribute(name, ty string, value []byte) error {
+	le := binary.LittleEndian
+
+	box := func() box2i {
+		return box2i{int32(le.Uint32(value[0:])), int32(le.Uint32(value[4:])),
+			int32(le.Uint32(value[8:])), int32(le.Uint32(value[12:]))}
+	}
+
+	minSize := map[string]int{"box2i": 16, "compression": 1, "lineOrder": 1, "float": 4,
+		"int": 4, "v2f": 8, "tiledesc": 9}
+
+	if len(value) < minSize[ty] {
+		return errCorrupt
+	}
+
+	switch {
+	case name == "channels" && ty == "chlist":
+		for len(value) > 0 && value[0] != 0 {
+			i := 0
+
+			for i < len(value) && value[i] != 0 {
+				i++
+			}
+
+			if i+17 > len(value) {
+				return errCorrupt
+			}
+
+			c := channel{name: string(value[:i])}
+			value = value[i+1:]
+
+			c.pixelType = int32(le.Uint32(value[0:]))
+			c.pLinear = value[4]
+			c.xSampling = int32(le.Uint32(value[8:]))
+			c.ySampling = int32(le.Uint32(value[12:]))
+			value = value[16:]
+
+			if c.pixelType < pixelUint || c.pixelType > pixelFloat {
+				return errCorrupt
+			}
+
+			h.channels = append(h.channels, c)
+		}
+
+	case name == "compression" && ty == "compression":
+		h.compression = value[0]
+		h.extra["compression"] = compressionName(h.compression)
+
+	case name == "dataWindow" && ty == "box2i":
+		h.dataWindow = box()
+
+	case name == "displayWindow" && ty == "box2i":
+		h.displayWindow = box()
+
+	case name == "lineOrder" && ty == "lineOrder":
+		h.lineOrder = value[0]
+
+	case name == "pixelAspectRatio" && ty == "float":
+		h.pixelAspectRatio = math.Float32frombits(le.Uint32(value))
+
+	case name == "screenWindowCenter" && ty == "v2f":
+		h.screenWindowCenter[0] = math.Float32frombits(le.Uint32(value[0:]))
+		h.screenWindowCenter[1] = math.Float32frombits(le.Uint32(value[4:]))
+
+	case name == "screenWindowWidth" && ty == "float":
+		h.screenWindowWidth = math.Float32frombits(le.Uint32(value))
+
+	case name == "tiles" && ty == "tiledesc":
+		h.tileW = int(le.Uint32(value[0:]))
+		h.tileH = int(le.Uint32(value[4:]))
+		h.tileLevelMode = value[8] & 0xf
+		h.tileRoundingUp = value[8]>>4 != 0
+
+	case ty == "string":
+		h.extra[name] = string(value)
+
+	case ty == "float":
+		h.extra[name] = math.Float32frombits(le.Uint32(value))
+
+	case ty == "int":
+		h.extra[name] = int32(le.Uint32(value))
+	}
+
+	return nil
+}
+
+// writer accumulates the little-endian header types.
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) uint8(v uint8) { w.buf = append(w.buf, v) }
+
+func (w *writer) int32(v int32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(v))
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *writer) float32(v float32) { w.int32(int32(math.Float32bits(v))) }
+
+func (w *writer) cstring(s string) {
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *writer) attribute(name, ty string, value func(w *writer)) {
+	var v writer
+	value(&v)
+
+	w.cstring(name)
+	w.cstring(ty)
+	w.int32(int32(len(v.buf)))
+	w.buf = append(w.buf, v.buf...)
+}
+
+func (w *writer) box2i(b box2i) {
+	w.int32(b.xMin)
+	w.int32(b.yMin)
+	w.int32(b.xMax)
+	w.int32(b.yMax)
+}
+
+// writeHeader encodes the magic number, version and attributes of h.
+func writeHeader(h *header) []byte {
+	var w writer
+
+	version := int32(2)
+
+	if h.tiled {
+		version |= flagTiled
+	}
+
+	for i := range h.channels {
+		if len(h.channels[i].name) > 31 {
+			version |= flagLongNames
+		}
+	}
+
+	for k := range h.extra {
+		if len(k) > 31 {
+			version |= flagLongNames
+		}
+	}
+
+	w.int32(magic)
+	w.int32(version)
+
+	w.attribute("channels", "chlist", func(w *writer) {
+		for i := range h.channels {
+			w.cstring(h.channels[i].name)
+			w.int32(h.channels[i].pixelType)
+			w.uint8(h.channels[i].pLinear)
+			w.uint8(0)
+			w.uint8(0)
+			w.uint8(0)
+			w.int32(1)
+			w.int32(1)
+		}
+		w.uint8(0)
+	})
+
+	w.attribute("compression", "compression", func(w *writer) { w.uint8(h.compression) })
+	w.attribute("dataWindow", "box2i", func(w *writer) { w.box2i(h.dataWindow) })
+	w.attribute("displayWindow", "box2i", func(w *writer) { w.box2i(h.displayWindow) })
+	w.attribute("lineOrder", "lineOrder", func(w *writer) { w.uint8(h.lineOrder) })
+	w.attribute("pixelAspectRatio", "float", func(w *writer) { w.float32(h.pixelAspectRatio) })
+	w.attribute("screenWindowCenter", "v2f", func(w *writer) {
+		w.float32(h.screenWindowCenter[0])
+		w.float32(h.screenWindowCenter[1])
+	})
+	w.attribute("screenWindowWidth", "float", func(w *writer) { w.float32(h.screenWindowWidth) })
+
+	if h.tiled {
+		w.attribute("tiles", "tiledesc", func(w *writer) {
+			w.int32(int32(h.tileW))
+			w.int32(int32(h.tileH))
+			w.uint8(levelOne)
+		})
+	}
+
+	// Extra attributes in a stable order
+	var names []string
+
+	for k := range h.extra {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	for _, k := range names {
+		switch v := h.extra[k].(type) {
+		case string:
+			if k != "compression" {
+				w.attribute(k, "string", func(w *writer) { w.buf = append(w.buf, v...) })
+			}
+		case float32:
+			w.attribute(k, "float", func(w *writer) { w.float32(v) })
+		case int32:
+			w.attribute(k, "int", func(w *writer) { w.int32(v) })
+		case int:
+			w.attribute(k, "int", func(w *writer) { w.int32(int32(v)) })
+		}
+	}
+
+	w.uint8(0) // End of header
+
+	return w.buf
+}
+
+// specOrder returns the order in which the file channels are presented in the Spec,
+// with R, G, B and A first.
+func specOrder(chans []channel) []int {
+	var order []int
+
+	for _, name := range []string{"R", "G", "B", "A"} {
+		for i := range chans {
+			if chans[i].name == name {
+				order = append(order, i)
+			}
+		}
+	}
+
+	for i := range chans {
+		switch chans[i].name {
+		case "R", "G", "B", "A":
+		default:
+			order = append(order, i)
+		}
+	}
+
+	return order
+}
+
+// spec builds the image.Spec describing h.
+func (h *header) spec() image.Spec {
+	spec := image.Spec{
+		X:            int(h.dataWindow.xMin),
+		Y:            int(h.dataWindow.yMin),
+		Width:        h.dataWindow.width(),
+		Height:       h.dataWindow.height(),
+		Depth:        1,
+		FullX:        int(h.displayWindow.xMin),
+		FullY:        int(h.displayWindow.yMin),
+		FullWidth:    h.displayWindow.width(),
+		FullHeight:   h.displayWindow.height(),
+		FullDepth:    1,
+		NChannels:    len(h.channels),
+		AlphaChannel: -1,
+		ZChannel:     -1,
+		ExtraAttribs: make(map[string]interface{}),
+	}
+
+	if h.tiled {
+		spec.TileWidth = h.tileW
+		spec.TileHeight = h.tileH
+		spec.TileDepth = 1
+	}
+
+	for i, c := range specOrder(h.channels) {
+		ch := &h.channels[c]
+		spec.ChannelNames = append(spec.ChannelNames, ch.name)
+
+		switch ch.pixelType {
+		case pixelHalf:
+			spec.Format = append(spec.Format, image.TypeDesc{BaseType: image.HALF})
+		default:
+			spec.Format = append(spec.Format, image.TypeDesc{BaseType: image.FLOAT})
+		}
+
+		switch ch.name {
+		case "A":
+			spec.AlphaChannel = i
+		case "Z":
+			spec.ZChannel = i
+		}
+	}
+
+	for k, v := range h.extra {
+		spec.ExtraAttribs[k] = v
+	}
+
+	spec.ExtraAttribs["pixelAspectRatio"] = h.pixelAspectRatio
+
+	return spec
+}
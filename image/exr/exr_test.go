@@ -0,0 +1,250 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/jamiec7919/vermeer/image"
+	m "github.com/jamiec7919/vermeer/math"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage returns a w*h image with nch channels containing smooth gradients and some
+// noise so that every compressor has something to work with.
+func testImage(w, h, nch int) []float32 {
+	rnd := rand.New(rand.NewSource(1))
+	img := make([]float32, w*h*nch)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			for c := 0; c < nch; c++ {
+				v := float32(x+c)/float32(w) + float32(y)/float32(h)
+
+				if (x+y)%7 == 0 {
+					v += rnd.Float32() * 4
+				}
+
+				img[(y*w+x)*nch+c] = v
+			}
+		}
+	}
+
+	return img
+}
+
+func roundTrip(t *testing.T, filename string, spec image.Spec, img []float32) []float32 {
+	w := &Writer{}
+
+	if err := w.Open(filename, &spec); err != nil {
+		t.Fatalf("open writer: %v", err)
+	}
+
+	if err := w.WriteImage(image.TypeDesc{BaseType: image.FLOAT}, img); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	w.Close()
+
+	r, err := Open(filename)
+
+	if err != nil {
+		t.Fatalf("open reader: %v", err)
+	}
+
+	defer r.Close()
+
+	rspec, _ := r.Spec()
+
+	if rspec.Width != spec.Width || rspec.Height != spec.Height || rspec.NChannels != spec.NChannels {
+		t.Fatalf("spec mismatch: got %vx%vx%v", rspec.Width, rspec.Height, rspec.NChannels)
+	}
+
+	out := make([]float32, len(img))
+
+	if err := r.ReadImage(image.TypeDesc{BaseType: image.FLOAT}, out); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exr")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	const w, h = 37, 45
+
+	for _, compression := range []string{"none", "rle", "zips", "zip", "piz"} {
+		for _, format := range []image.BaseType{image.HALF, image.FLOAT} {
+			for _, tiled := range []bool{false, true} {
+				spec := image.Spec{
+					Width:        w,
+					Height:       h,
+					NChannels:    4,
+					ChannelNames: []string{"R", "G", "B", "A"},
+					Format:       []image.TypeDesc{{BaseType: format}},
+					ExtraAttribs: map[string]interface{}{"compression": compression},
+				}
+
+				if tiled {
+					spec.TileWidth, spec.TileHeight = 16, 16
+				}
+
+				img := testImage(w, h, 4)
+				out := roundTrip(t, filepath.Join(dir, "test.exr"), spec, img)
+
+				for i := range img {
+					want := img[i]
+
+					if format == image.HALF {
+						want = m.Float16ToFloat32(m.Float32ToFloat16(want))
+					}
+
+					if out[i] != want {
+						t.Errorf("%v format %v tiled %v: pixel %v got %v want %v", compression, format, tiled, i, out[i], want)
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestScanline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exr")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	const w, h = 20, 40
+
+	spec := image.Spec{
+		X:            -3,
+		Y:            5,
+		Width:        w,
+		Height:       h,
+		NChannels:    3,
+		ExtraAttribs: map[string]interface{}{"compression": "piz"},
+	}
+
+	img := testImage(w, h, 3)
+	roundTrip(t, filepath.Join(dir, "test.exr"), spec, img)
+
+	r, err := Open(filepath.Join(dir, "test.exr"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	line := make([]float32, w*3)
+
+	for y := 0; y < h; y++ {
+		if err := r.ReadScanline(y+spec.Y, 0, image.TypeDesc{BaseType: image.FLOAT}, line); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range line {
+			if line[i] != img[y*w*3+i] {
+				t.Fatalf("line %v: got %v want %v", y, line[i], img[y*w*3+i])
+			}
+		}
+	}
+}
+
+// TestRewriteSpec checks that writing an image with the Spec returned by the reader doesn't
+// duplicate the standard attributes.
+func TestRewriteSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exr")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	const w, h = 8, 6
+
+	spec := image.Spec{
+		Width:     w,
+		Height:    h,
+		NChannels: 3,
+		ExtraAttribs: map[string]interface{}{
+			"pixelAspectRatio": float32(2),
+			"owner":            "vermeer",
+			"dataWindow":       "not a box",
+		},
+	}
+
+	img := testImage(w, h, 3)
+	filename := filepath.Join(dir, "test.exr")
+	roundTrip(t, filename, spec, img)
+
+	r, err := Open(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rspec, _ := r.Spec()
+	r.Close()
+
+	roundTrip(t, filename, rspec, img)
+
+	b, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the attribute names in the header.
+	count := map[string]int{}
+
+	for b = b[8:]; len(b) > 0 && b[0] != 0; {
+		name := b[:bytes.IndexByte(b, 0)]
+		b = b[len(name)+1:]
+		b = b[bytes.IndexByte(b, 0)+1:]
+		size := binary.LittleEndian.Uint32(b)
+		b = b[4+size:]
+
+		count[string(name)]++
+	}
+
+	for name, n := range count {
+		if n != 1 {
+			t.Errorf("%v written %v times", name, n)
+		}
+	}
+
+	if count["owner"] != 1 || count["pixelAspectRatio"] != 1 {
+		t.Errorf("attributes %v", count)
+	}
+
+	r, err = Open(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	if rspec, _ := r.Spec(); rspec.ExtraAttribs["pixelAspectRatio"] != float32(2) {
+		t.Errorf("pixelAspectRatio %v, want 2", rspec.ExtraAttribs["pixelAspectRatio"])
+	}
+}
@@ -0,0 +1,546 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+)
+
+// 16-bit Huffman coder used by PIZ compression.  This follows the OpenEXR implementation
+// (ImfHuf.cpp) exactly as the packed code table and bit stream formats are part of the
+// file format.
+
+const (
+	hufEncBits = 16 // literal (value) bit length
+	hufDecBits = 14 // decoding bit size (>= 8)
+
+	hufEncSize = (1 << hufEncBits) + 1 // encoding table size
+	hufDecSize = 1 << hufDecBits       // decoding table size
+	hufDecMask = hufDecSize - 1
+
+	shortZerocodeRun = 59
+	longZerocodeRun  = 63
+	shortestLongRun  = 2 + longZerocodeRun - shortZerocodeRun
+	longestLongRun   = 255 + shortestLongRun
+)
+
+var errHufCorrupt = errors.New("exr: corrupt huffman data")
+
+func hufLength(code uint64) uint { return uint(code & 63) }
+func hufCode(code uint64) uint64 { return code >> 6 }
+
+// bitWriter accumulates bits msb first.
+type bitWriter struct {
+	c   uint64
+	lc  uint
+	out []byte
+}
+
+func (w *bitWriter) outputBits(nBits uint, bits uint64) {
+	w.c <<= nBits
+	w.lc += nBits
+	w.c |= bits
+
+	for w.lc >= 8 {
+		w.lc -= 8
+		w.out = append(w.out, byte(w.c>>w.lc))
+	}
+}
+
+func (w *bitWriter) outputCode(code uint64) {
+	w.outputBits(hufLength(code), hufCode(code))
+}
+
+// hufCanonicalCodeTable converts the code lengths in hcode into canonical codes stored
+// as code<<6 | length.
+func hufCanonicalCodeTable(hcode []uint64) {
+	var n [59]uint64
+
+	for i := range hcode {
+		n[hcode[i]]++
+	}
+
+	// For each i from 58 through 1, compute the numerically lowest code with length i
+	// and store it in n[i].
+	c := uint64(0)
+
+	for i := 58; i > 0; i-- {
+		nc := (c + n[i]) >> 1
+		n[i] = c
+		c = nc
+	}
+
+	for i := range hcode {
+		if l := hcode[i]; l > 0 {
+			hcode[i] = l | (n[l] << 6)
+			n[l]++
+		}
+	}
+}
+
+// freqHeap is a min-heap of symbols ordered by frequency.
+type freqHeap struct {
+	sym []int
+	frq []uint64
+}
+
+func (h *freqHeap) Len() int           { return len(h.sym) }
+func (h *freqHeap) Less(i, j int) bool { return h.frq[h.sym[i]] < h.frq[h.sym[j]] }
+func (h *freqHeap) Swap(i, j int)      { h.sym[i], h.sym[j] = h.sym[j], h.sym[i] }
+func (h *freqHeap) Push(x interface{}) { h.sym = append(h.sym, x.(int)) }
+func (h *freqHeap) Pop() (x interface{}) {
+	x, h.sym = h.sym[len(h.sym)-1], h.sym[:len(h.sym)-1]
+	return
+}
+
+// hufBuildEncTable computes the canonical code table from the frequencies in frq (which
+// is overwritten) and returns the index of the first and last (the run length code)
+// symbols.
+func hufBuildEncTable(frq []uint64) (im, iM int) {
+	hlink := make([]int, hufEncSize)
+	h := &freqHeap{frq: frq}
+
+	for frq[im] == 0 {
+		im++
+	}
+
+	for i := im; i < hufEncSize; i++ {
+		hlink[i] = i
+
+		if frq[i] != 0 {
+			h.sym = append(h.sym, i)
+			iM = i
+		}
+	}
+
+	// Add a pseudo-symbol with frequency 1 for run length encoding.
+	iM++
+	frq[iM] = 1
+	h.sym = append(h.sym, iM)
+
+	heap.Init(h)
+
+	scode := make([]uint64, hufEncSize)
+
+	// Repeatedly merge the two least frequent sets, every symbol in a merged set has its
+	// code length increased by one.
+	for h.Len() > 1 {
+		mm := heap.Pop(h).(int)
+		m := heap.Pop(h).(int)
+
+		frq[m] += frq[mm]
+		heap.Push(h, m)
+
+		for j := m; ; j = hlink[j] {
+			scode[j]++
+
+			if hlink[j] == j {
+				hlink[j] = mm // merge the two lists
+				break
+			}
+		}
+
+		for j := mm; ; j = hlink[j] {
+			scode[j]++
+
+			if hlink[j] == j {
+				break
+			}
+		}
+	}
+
+	hufCanonicalCodeTable(scode)
+	copy(frq, scode)
+
+	return
+}
+
+// hufPackEncTable packs the code lengths for symbols im..iM into w.
+func hufPackEncTable(hcode []uint64, im, iM int, w *bitWriter) {
+	for ; im <= iM; im++ {
+		l := hufLength(hcode[im])
+
+		if l == 0 {
+			zerun := 1
+
+			for im < iM && zerun < longestLongRun {
+				if hufLength(hcode[im+1]) > 0 {
+					break
+				}
+				im++
+				zerun++
+			}
+
+			if zerun >= 2 {
+				if zerun >= shortestLongRun {
+					w.outputBits(6, longZerocodeRun)
+					w.outputBits(8, uint64(zerun-shortestLongRun))
+				} else {
+					w.outputBits(6, uint64(shortZerocodeRun+zerun-2))
+				}
+				continue
+			}
+		}
+
+		w.outputBits(6, uint64(l))
+	}
+
+	if w.lc > 0 {
+		w.out = append(w.out, byte(w.c<<(8-w.lc)))
+		w.lc = 0
+	}
+}
+
+func sendCode(sCode uint64, runCount int, runCode uint64, w *bitWriter) {
+	if hufLength(sCode)+hufLength(runCode)+8 < hufLength(sCode)*uint(runCount) {
+		w.outputCode(sCode)
+		w.outputCode(runCode)
+		w.outputBits(8, uint64(runCount))
+	} else {
+		for ; runCount >= 0; runCount-- {
+			w.outputCode(sCode)
+		}
+	}
+}
+
+// hufEncode encodes in using the code table and returns the number of bits written.
+func hufEncode(hcode []uint64, in []uint16, rlc int, w *bitWriter) int {
+	start := len(w.out)
+	s := in[0]
+	cs := 0
+
+	for _, v := range in[1:] {
+		if s == v && cs < 255 {
+			cs++
+		} else {
+			sendCode(hcode[s], cs, hcode[rlc], w)
+			cs = 0
+		}
+
+		s = v
+	}
+
+	sendCode(hcode[s], cs, hcode[rlc], w)
+
+	nBits := (len(w.out)-start)*8 + int(w.lc)
+
+	if w.lc > 0 {
+		w.out = append(w.out, byte(w.c<<(8-w.lc)))
+		w.lc = 0
+	}
+
+	return nBits
+}
+
+// hufCompress appends the Huffman encoded raw data to out.
+func hufCompress(raw []uint16, out []byte) []byte {
+	if len(raw) == 0 {
+		return out
+	}
+
+	frq := make([]uint64, hufEncSize)
+
+	for _, v := range raw {
+		frq[v]++
+	}
+
+	im, iM := hufBuildEncTable(frq)
+
+	start := len(out)
+	w := bitWriter{out: append(out, make([]byte, 20)...)}
+
+	hufPackEncTable(frq, im, iM, &w)
+	tableLength := len(w.out) - start - 20
+
+	nBits := hufEncode(frq, raw, iM, &w)
+
+	hdr := w.out[start:]
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(im))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(iM))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(tableLength))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(nBits))
+	binary.LittleEndian.PutUint32(hdr[16:], 0)
+
+	return w.out
+}
+
+// bitReader reads bits msb first.
+type bitReader struct {
+	c   uint64
+	lc  uint
+	in  []byte
+	pos int
+}
+
+func (r *bitReader) getChar() {
+	r.c = (r.c << 8) | uint64(r.in[r.pos])
+	r.pos++
+	r.lc += 8
+}
+
+func (r *bitReader) getBits(nBits uint) (uint64, error) {
+	for r.lc < nBits {
+		if r.pos >= len(r.in) {
+			return 0, errHufCorrupt
+		}
+		r.getChar()
+	}
+
+	r.lc -= nBits
+	return (r.c >> r.lc) & ((1 << nBits) - 1), nil
+}
+
+// hufUnpackEncTable reads the packed code lengths for symbols im..iM and builds the
+// canonical code table.
+func hufUnpackEncTable(r *bitReader, im, iM int, hcode []uint64) error {
+	for ; im <= iM; im++ {
+		l, err := r.getBits(6)
+
+		if err != nil {
+			return err
+		}
+
+		hcode[im] = l
+
+		if l == longZerocodeRun {
+			zerun, err := r.getBits(8)
+
+			if err != nil {
+				return err
+			}
+
+			zerun += shortestLongRun
+
+			if im+int(zerun) > iM+1 {
+				return errHufCorrupt
+			}
+
+			for ; zerun > 0; zerun-- {
+				hcode[im] = 0
+				im++
+			}
+			im--
+		} else if l >= shortZerocodeRun {
+			zerun := int(l) - shortZerocodeRun + 2
+
+			if im+zerun > iM+1 {
+				return errHufCorrupt
+			}
+
+			for ; zerun > 0; zerun-- {
+				hcode[im] = 0
+				im++
+			}
+			im--
+		}
+	}
+
+	hufCanonicalCodeTable(hcode)
+
+	return nil
+}
+
+// hufDec is a decoding table entry.  Codes of up to hufDecBits bits are decoded with a
+// single lookup, longer codes list the candidate symbols in p.
+type hufDec struct {
+	len uint
+	lit int
+	p   []int
+}
+
+func hufBuildDecTable(hcode []uint64, im, iM int, hdecod []hufDec) error {
+	for ; im <= iM; im++ {
+		c := hufCode(hcode[im])
+		l := hufLength(hcode[im])
+
+		if c>>l != 0 {
+			return errHufCorrupt // code is longer than its length
+		}
+
+		if l > hufDecBits {
+			pl := &hdecod[c>>(l-hufDecBits)]
+
+			if pl.len != 0 {
+				return errHufCorrupt
+			}
+
+			pl.lit++
+			pl.p = append(pl.p, im)
+		} else if l > 0 {
+			base := int(c << (hufDecBits - l))
+
+			for i := 0; i < 1<<(hufDecBits-l); i++ {
+				pl := &hdecod[base+i]
+
+				if pl.len != 0 || pl.p != nil {
+					return errHufCorrupt
+				}
+
+				pl.len = l
+				pl.lit = im
+			}
+		}
+	}
+
+	return nil
+}
+
+// hufDecoder holds the output state while decoding.
+type hufDecoder struct {
+	out []uint16
+	n   int
+}
+
+func (d *hufDecoder) getCode(po, rlc int, r *bitReader) error {
+	if po == rlc {
+		if r.lc < 8 {
+			if r.pos >= len(r.in) {
+				return errHufCorrupt
+			}
+			r.getChar()
+		}
+
+		r.lc -= 8
+		cs := int(byte(r.c >> r.lc))
+
+		if d.n+cs > len(d.out) || d.n == 0 {
+			return errHufCorrupt
+		}
+
+		s := d.out[d.n-1]
+
+		for ; cs > 0; cs-- {
+			d.out[d.n] = s
+			d.n++
+		}
+	} else if d.n < len(d.out) {
+		d.out[d.n] = uint16(po)
+		d.n++
+	} else {
+		return errHufCorrupt
+	}
+
+	return nil
+}
+
+func hufDecode(hcode []uint64, hdecod []hufDec, in []byte, nBits int, rlc int, out []uint16) error {
+	r := bitReader{in: in[:(nBits+7)/8]}
+	d := hufDecoder{out: out}
+
+	for r.pos < len(r.in) {
+		r.getChar()
+
+		for r.lc >= hufDecBits {
+			pl := &hdecod[(r.c>>(r.lc-hufDecBits))&hufDecMask]
+
+			if pl.len != 0 {
+				r.lc -= pl.len
+
+				if err := d.getCode(pl.lit, rlc, &r); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if pl.p == nil {
+				return errHufCorrupt
+			}
+
+			// Search the long codes
+			j := 0
+
+			for ; j < pl.lit; j++ {
+				l := hufLength(hcode[pl.p[j]])
+
+				for r.lc < l && r.pos < len(r.in) {
+					r.getChar()
+				}
+
+				if r.lc >= l && hufCode(hcode[pl.p[j]]) == (r.c>>(r.lc-l))&((1<<l)-1) {
+					r.lc -= l
+
+					if err := d.getCode(pl.p[j], rlc, &r); err != nil {
+						return err
+					}
+					break
+				}
+			}
+
+			if j == pl.lit {
+				return errHufCorrupt
+			}
+		}
+	}
+
+	// Get remaining (short) codes
+	i := uint((8 - nBits) & 7)
+	r.c >>= i
+	r.lc -= i
+
+	for r.lc > 0 {
+		pl := &hdecod[(r.c<<(hufDecBits-r.lc))&hufDecMask]
+
+		if pl.len == 0 || pl.len > r.lc {
+			return errHufCorrupt
+		}
+
+		r.lc -= pl.len
+
+		if err := d.getCode(pl.lit, rlc, &r); err != nil {
+			return err
+		}
+	}
+
+	if d.n != len(out) {
+		return errHufCorrupt
+	}
+
+	return nil
+}
+
+// hufUncompress decodes the Huffman compressed data in compressed into raw.
+func hufUncompress(compressed []byte, raw []uint16) error {
+	if len(compressed) == 0 {
+		if len(raw) != 0 {
+			return errHufCorrupt
+		}
+		return nil
+	}
+
+	if len(compressed) < 20 {
+		return errHufCorrupt
+	}
+
+	im := int(binary.LittleEndian.Uint32(compressed[0:]))
+	iM := int(binary.LittleEndian.Uint32(compressed[4:]))
+	nBits := int(binary.LittleEndian.Uint32(compressed[12:]))
+
+	if im < 0 || im >= hufEncSize || iM < 0 || iM >= hufEncSize || im > iM {
+		return errHufCorrupt
+	}
+
+	hcode := make([]uint64, hufEncSize)
+	r := bitReader{in: compressed[20:]}
+
+	if err := hufUnpackEncTable(&r, im, iM, hcode); err != nil {
+		return err
+	}
+
+	data := r.in[r.pos:]
+
+	if nBits > 8*len(data) {
+		return errHufCorrupt
+	}
+
+	hdecod := make([]hufDec, hufDecSize)
+
+	if err := hufBuildDecTable(hcode, im, iM, hdecod); err != nil {
+		return err
+	}
+
+	return hufDecode(hcode, hdecod, data, nBits, iM, raw)
+}
@@ -0,0 +1,453 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// PIZ compression: the data is split into 16-bit words per channel, mapped through a
+// lookup table to the range of values actually used, transformed with a Haar wavelet and
+// finally Huffman encoded.  Follows ImfPizCompressor.cpp and ImfWav.cpp.
+
+const (
+	ushortRange = 1 << 16
+	bitmapSize  = ushortRange >> 3
+)
+
+var errPizCorrupt = errors.New("exr: corrupt PIZ data")
+
+// pizChannel describes the layout of one channel within the word buffer.
+type pizChannel struct {
+	start  int // index of first word
+	nx, ny int
+	size   int // words per pixel
+}
+
+func bitmapFromData(data []uint16, bitmap []byte) (minNonZero, maxNonZero int) {
+	for _, v := range data {
+		bitmap[v>>3] |= 1 << (v & 7)
+	}
+
+	bitmap[0] &^= 1 // zero is not explicitly stored in the bitmap
+
+	minNonZero = bitmapSize - 1
+	maxNonZero = 0
+
+	for i := range bitmap {
+		if bitmap[i] != 0 {
+			if minNonZero > i {
+				minNonZero = i
+			}
+			if maxNonZero < i {
+				maxNonZero = i
+			}
+		}
+	}
+
+	return
+}
+
+func forwardLutFromBitmap(bitmap []byte, lut []uint16) uint16 {
+	k := 0
+
+	for i := 0; i < ushortRange; i++ {
+		if i == 0 || bitmap[i>>3]&(1<<uint(i&7)) != 0 {
+			lut[i] = uint16(k)
+			k++
+		} else {
+			lut[i] = 0
+		}
+	}
+
+	return uint16(k - 1) // maximum value stored in lut[]
+}
+
+func reverseLutFromBitmap(bitmap []byte, lut []uint16) uint16 {
+	k := 0
+
+	for i := 0; i < ushortRange; i++ {
+		if i == 0 || bitmap[i>>3]&(1<<uint(i&7)) != 0 {
+			lut[k] = uint16(i)
+			k++
+		}
+	}
+
+	n := k - 1
+
+	for k < ushortRange {
+		lut[k] = 0
+		k++
+	}
+
+	return uint16(n) // maximum k where lut[k] is non-zero
+}
+
+func applyLut(lut []uint16, data []uint16) {
+	for i := range data {
+		data[i] = lut[data[i]]
+	}
+}
+
+// pizChannels returns the layout of each channel in the word buffer for a block of
+// nx by ny pixels.
+func pizChannels(chans []channel, nx, ny int) (cd []pizChannel, nwords int) {
+	for i := range chans {
+		c := pizChannel{start: nwords, nx: nx, ny: ny, size: chans[i].size() / 2}
+		cd = append(cd, c)
+		nwords += nx * ny * c.size
+	}
+
+	return
+}
+
+// pizCompress compresses raw (in scanline/channel order) for a block of nx by ny pixels.
+func pizCompress(raw []byte, chans []channel, nx, ny int) []byte {
+	cd, nwords := pizChannels(chans, nx, ny)
+	tmp := make([]uint16, nwords)
+
+	// Rearrange into one contiguous region per channel
+	end := make([]int, len(cd))
+
+	for i := range cd {
+		end[i] = cd[i].start
+	}
+
+	p := 0
+
+	for y := 0; y < ny; y++ {
+		for i := range cd {
+			n := cd[i].nx * cd[i].size
+
+			for k := 0; k < n; k++ {
+				tmp[end[i]+k] = binary.LittleEndian.Uint16(raw[p:])
+				p += 2
+			}
+
+			end[i] += n
+		}
+	}
+
+	bitmap := make([]byte, bitmapSize)
+	minNonZero, maxNonZero := bitmapFromData(tmp, bitmap)
+
+	lut := make([]uint16, ushortRange)
+	maxValue := forwardLutFromBitmap(bitmap, lut)
+	applyLut(lut, tmp)
+
+	out := make([]byte, 4, len(raw))
+	binary.LittleEndian.PutUint16(out[0:], uint16(minNonZero))
+	binary.LittleEndian.PutUint16(out[2:], uint16(maxNonZero))
+
+	if minNonZero <= maxNonZero {
+		out = append(out, bitmap[minNonZero:maxNonZero+1]...)
+	}
+
+	for i := range cd {
+		for j := 0; j < cd[i].size; j++ {
+			wav2Encode(tmp[cd[i].start+j:], cd[i].nx, cd[i].size, cd[i].ny, cd[i].nx*cd[i].size, maxValue)
+		}
+	}
+
+	lenPos := len(out)
+	out = append(out, 0, 0, 0, 0)
+	out = hufCompress(tmp, out)
+	binary.LittleEndian.PutUint32(out[lenPos:], uint32(len(out)-lenPos-4))
+
+	return out
+}
+
+// pizUncompress decompresses data into raw which must be the size of the uncompressed block.
+func pizUncompress(data []byte, raw []byte, chans []channel, nx, ny int) error {
+	if len(data) < 4 {
+		return errPizCorrupt
+	}
+
+	minNonZero := int(binary.LittleEndian.Uint16(data[0:]))
+	maxNonZero := int(binary.LittleEndian.Uint16(data[2:]))
+	data = data[4:]
+
+	if maxNonZero >= bitmapSize {
+		return errPizCorrupt
+	}
+
+	bitmap := make([]byte, bitmapSize)
+
+	if minNonZero <= maxNonZero {
+		n := maxNonZero - minNonZero + 1
+
+		if len(data) < n {
+			return errPizCorrupt
+		}
+
+		copy(bitmap[minNonZero:], data[:n])
+		data = data[n:]
+	}
+
+	lut := make([]uint16, ushortRange)
+	maxValue := reverseLutFromBitmap(bitmap, lut)
+
+	if len(data) < 4 {
+		return errPizCorrupt
+	}
+
+	length := int(binary.LittleEndian.Uint32(data))
+	data = data[4:]
+
+	if length > len(data) {
+		return errPizCorrupt
+	}
+
+	cd, nwords := pizChannels(chans, nx, ny)
+
+	if nwords*2 != len(raw) {
+		return errPizCorrupt
+	}
+
+	tmp := make([]uint16, nwords)
+
+	if err := hufUncompress(data[:length], tmp); err != nil {
+		return err
+	}
+
+	for i := range cd {
+		for j := 0; j < cd[i].size; j++ {
+			wav2Decode(tmp[cd[i].start+j:], cd[i].nx, cd[i].size, cd[i].ny, cd[i].nx*cd[i].size, maxValue)
+		}
+	}
+
+	applyLut(lut, tmp)
+
+	// Rearrange back into scanline order
+	end := make([]int, len(cd))
+
+	for i := range cd {
+		end[i] = cd[i].start
+	}
+
+	p := 0
+
+	for y := 0; y < ny; y++ {
+		for i := range cd {
+			n := cd[i].nx * cd[i].size
+
+			for k := 0; k < n; k++ {
+				binary.LittleEndian.PutUint16(raw[p:], tmp[end[i]+k])
+				p += 2
+			}
+
+			end[i] += n
+		}
+	}
+
+	return nil
+}
+
+// Wavelet basis functions without modulo arithmetic, these produce the best compression
+// but only work for values up to 14 bits.
+
+func wenc14(a, b uint16) (l, h uint16) {
+	as := int(int16(a))
+	bs := int(int16(b))
+
+	ms := (as + bs) >> 1
+	ds := as - bs
+
+	return uint16(ms), uint16(ds)
+}
+
+func wdec14(l, h uint16) (a, b uint16) {
+	ls := int(int16(l))
+	hs := int(int16(h))
+
+	hi := hs
+	ai := ls + (hi & 1) + (hi >> 1)
+
+	return uint16(int16(ai)), uint16(int16(ai - hi))
+}
+
+// Wavelet basis functions with modulo arithmetic, these work with full 16 bit values.
+
+const (
+	waveNBits   = 16
+	waveAOffset = 1 << (waveNBits - 1)
+	waveMOffset = 1 << (waveNBits - 1)
+	waveModMask = (1 << waveNBits) - 1
+)
+
+func wenc16(a, b uint16) (l, h uint16) {
+	ao := (int(a) + waveAOffset) & waveModMask
+	m := (ao + int(b)) >> 1
+	d := ao - int(b)
+
+	if d < 0 {
+		m = (m + waveMOffset) & waveModMask
+	}
+
+	d &= waveModMask
+
+	return uint16(m), uint16(d)
+}
+
+func wdec16(l, h uint16) (a, b uint16) {
+	m := int(l)
+	d := int(h)
+	bb := (m - (d >> 1)) & waveModMask
+	aa := (d + bb - waveAOffset) & waveModMask
+
+	return uint16(aa), uint16(bb)
+}
+
+// wav2Encode performs a 2D wavelet encoding of the nx by ny words in in, with ox the
+// distance between horizontally adjacent words and oy between vertically adjacent.
+func wav2Encode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	w14 := mx < (1 << 14)
+	wenc := wenc16
+
+	if w14 {
+		wenc = wenc14
+	}
+
+	n := nx
+	if ny < n {
+		n = ny
+	}
+
+	p := 1  // == 1 <<  level
+	p2 := 2 // == 1 << (level+1)
+
+	// Hierarchical loop on smaller dimension n
+	for p2 <= n {
+		py := 0
+		ey := oy * (ny - p2)
+		oy1 := oy * p
+		oy2 := oy * p2
+		ox1 := ox * p
+		ox2 := ox * p2
+
+		// Y loop
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			// X loop
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				p10 := px + oy1
+				p11 := p10 + ox1
+
+				// 2D wavelet encoding
+				i00, i01 := wenc(in[px], in[p01])
+				i10, i11 := wenc(in[p10], in[p11])
+				in[px], in[p10] = wenc(i00, i10)
+				in[p01], in[p11] = wenc(i01, i11)
+			}
+
+			// Encode (1D) odd column (still in Y loop)
+			if nx&p != 0 {
+				p10 := px + oy1
+				var i00 uint16
+				i00, in[p10] = wenc(in[px], in[p10])
+				in[px] = i00
+			}
+		}
+
+		// Encode (1D) odd line (must loop in X)
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				var i00 uint16
+				i00, in[p01] = wenc(in[px], in[p01])
+				in[px] = i00
+			}
+		}
+
+		p = p2
+		p2 <<= 1
+	}
+}
+
+// wav2Decode reverses wav2Encode.
+func wav2Decode(in []uint16, nx, ox, ny, oy int, mx uint16) {
+	w14 := mx < (1 << 14)
+	wdec := wdec16
+
+	if w14 {
+		wdec = wdec14
+	}
+
+	n := nx
+	if ny < n {
+		n = ny
+	}
+
+	// Search max level
+	p := 1
+
+	for p <= n {
+		p <<= 1
+	}
+
+	p >>= 1
+	p2 := p
+	p >>= 1
+
+	// Hierarchical loop on smaller dimension n
+	for p >= 1 {
+		py := 0
+		ey := oy * (ny - p2)
+		oy1 := oy * p
+		oy2 := oy * p2
+		ox1 := ox * p
+		ox2 := ox * p2
+
+		// Y loop
+		for ; py <= ey; py += oy2 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			// X loop
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				p10 := px + oy1
+				p11 := p10 + ox1
+
+				// 2D wavelet decoding
+				i00, i10 := wdec(in[px], in[p10])
+				i01, i11 := wdec(in[p01], in[p11])
+				in[px], in[p01] = wdec(i00, i01)
+				in[p10], in[p11] = wdec(i10, i11)
+			}
+
+			// Decode (1D) odd column (still in Y loop)
+			if nx&p != 0 {
+				p10 := px + oy1
+				var i00 uint16
+				i00, in[p10] = wdec(in[px], in[p10])
+				in[px] = i00
+			}
+		}
+
+		// Decode (1D) odd line (must loop in X)
+		if ny&p != 0 {
+			px := py
+			ex := py + ox*(nx-p2)
+
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				var i00 uint16
+				i00, in[p01] = wdec(in[px], in[p01])
+				in[px] = i00
+			}
+		}
+
+		p2 = p
+		p >>= 1
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/jamiec7919/vermeer/image"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"os"
+)
+
+// Reader implements an image reader for OpenEXR files.
+type Reader struct {
+	file    *os.File
+	h       *header
+	spec    image.Spec
+	offsets []int64
+	specIdx []int // Spec channel index for each file channel
+
+	// Last decoded block of full width scanlines, used by ReadScanline
+	blockY, blockH int
+	block          []float32
+}
+
+func init() {
+	image.RegisterReader(func(filename string) (image.Reader, error) {
+		r, err := Open(filename)
+
+		if err == nil && r != nil {
+			return r, err
+		}
+		return nil, err
+	})
+}
+
+// Open attempts to open the given filename and returns an instance of the reader or nil and an error.
+func Open(filename string) (*Reader, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{file: file}
+
+	if err := r.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reader) readHeader() error {
+	rd := &reader{r: bufio.NewReader(r.file)}
+
+	h, err := readHeader(rd)
+
+	if err != nil {
+		return err
+	}
+
+	r.h = h
+	r.spec = h.spec()
+	r.specIdx = make([]int, len(h.channels))
+
+	for i, c := range specOrder(h.channels) {
+		r.specIdx[c] = i
+	}
+
+	n := h.chunkCount()
+	buf := make([]byte, 8*n)
+	rd.read(buf)
+
+	if rd.err != nil {
+		return rd.err
+	}
+
+	r.offsets = make([]int64, n)
+
+	for i := range r.offsets {
+		r.offsets[i] = int64(binary.LittleEndian.Uint64(buf[i*8:]))
+	}
+
+	return nil
+}
+
+// Spec returns the image spec fo the open reader.
+func (r *Reader) Spec() (image.Spec, error) { return r.spec, nil }
+
+// Close closes the reader.
+func (r *Reader) Close() { r.file.Close() }
+
+// readChunk reads and decompresses chunk idx returning the pixel rectangle it covers
+// (relative to the data window) and the pixels as float32 in Spec channel order.
+func (r *Reader) readChunk(idx int) (x0, y0, nx, ny int, pix []float32, err error) {
+	h := r.h
+	w, ht := h.dataWindow.width(), h.dataWindow.height()
+
+	var hdr [20]byte
+	var hdrSize int
+
+	if h.tiled {
+		hdrSize = 20
+
+		tx := idx % h.tilesX()
+		ty := idx / h.tilesX()
+
+		x0, y0 = tx*h.tileW, ty*h.tileH
+		nx, ny = h.tileW, h.tileH
+	} else {
+		hdrSize = 8
+
+		lpb := linesPerBlock(h.compression)
+
+		x0, y0 = 0, idx*lpb
+		nx, ny = w, lpb
+	}
+
+	if x0+nx > w {
+		nx = w - x0
+	}
+
+	if y0+ny > ht {
+		ny = ht - y0
+	}
+
+	if _, err = r.file.ReadAt(hdr[:hdrSize], r.offsets[idx]); err != nil {
+		return
+	}
+
+	le := binary.LittleEndian
+
+	if h.tiled {
+		if int(int32(le.Uint32(hdr[0:]))) != x0/h.tileW || int(int32(le.Uint32(hdr[4:]))) != y0/h.tileH {
+			err = errCorrupt
+			return
+		}
+	} else if int(int32(le.Uint32(hdr[0:]))) != y0+int(h.dataWindow.yMin) {
+		err = errCorrupt
+		return
+	}
+
+	size := int(int32(le.Uint32(hdr[hdrSize-4:])))
+	rawSize := h.bytesPerLine(nx) * ny
+
+	if size < 0 || size > rawSize+rawSize/2+1024 {
+		err = errCorrupt
+		return
+	}
+
+	data := make([]byte, size)
+
+	if _, err = r.file.ReadAt(data, r.offsets[idx]+int64(hdrSize)); err != nil {
+		return
+	}
+
+	raw := make([]byte, rawSize)
+
+	if err = uncompressBlock(h.compression, data, raw, h.channels, nx, ny); err != nil {
+		return
+	}
+
+	pix = r.unpack(raw, nx, ny)
+
+	return
+}
+
+// unpack converts the raw (scanline then channel ordered) pixels into interleaved float32.
+func (r *Reader) unpack(raw []byte, nx, ny int) []float32 {
+	nch := len(r.h.channels)
+	pix := make([]float32, nx*ny*nch)
+	le := binary.LittleEndian
+
+	p := 0
+
+	for y := 0; y < ny; y++ {
+		for c := range r.h.channels {
+			idx := y*nx*nch + r.specIdx[c]
+
+			switch r.h.channels[c].pixelType {
+			case pixelHalf:
+				for x := 0; x < nx; x++ {
+					pix[idx+x*nch] = m.Float16ToFloat32(m.Float16(le.Uint16(raw[p:])))
+					p += 2
+				}
+			case pixelFloat:
+				for x := 0; x < nx; x++ {
+					pix[idx+x*nch] = math.Float32frombits(le.Uint32(raw[p:]))
+					p += 4
+				}
+			case pixelUint:
+				for x := 0; x < nx; x++ {
+					pix[idx+x*nch] = float32(le.Uint32(raw[p:]))
+					p += 4
+				}
+			}
+		}
+	}
+
+	return pix
+}
+
+// ReadImage reads entire image into the given buf, translating into type ty (if possible).
+func (r *Reader) ReadImage(ty image.TypeDesc, buf interface{}) error {
+	w, h, nch := r.spec.Width, r.spec.Height, r.spec.NChannels
+	img := make([]float32, w*h*nch)
+
+	for idx := range r.offsets {
+		x0, y0, nx, ny, pix, err := r.readChunk(idx)
+
+		if err != nil {
+			return err
+		}
+
+		for y := 0; y < ny; y++ {
+			copy(img[((y0+y)*w+x0)*nch:((y0+y)*w+x0+nx)*nch], pix[y*nx*nch:(y+1)*nx*nch])
+		}
+	}
+
+	return image.FromFloat32(ty, img, buf)
+}
+
+// ReadScanline reads scanline y (in data window coordinates) into buf.
+func (r *Reader) ReadScanline(y, z int, ty image.TypeDesc, buf interface{}) error {
+	w, nch := r.spec.Width, r.spec.NChannels
+	y -= r.spec.Y
+
+	if y < 0 || y >= r.spec.Height {
+		return errors.New("exr: scanline out of range")
+	}
+
+	if r.block == nil || y < r.blockY || y >= r.blockY+r.blockH {
+		if err := r.readBlock(y); err != nil {
+			return err
+		}
+	}
+
+	j := y - r.blockY
+
+	return image.FromFloat32(ty, r.block[j*w*nch:(j+1)*w*nch], buf)
+}
+
+// readBlock decodes the full width block of scanlines containing line y.
+func (r *Reader) readBlock(y int) error {
+	w, nch := r.spec.Width, r.spec.NChannels
+
+	var first, count, lines int
+
+	if r.h.tiled {
+		first = (y / r.h.tileH) * r.h.tilesX()
+		count = r.h.tilesX()
+		lines = r.h.tileH
+	} else {
+		first = y / linesPerBlock(r.h.compression)
+		count = 1
+		lines = linesPerBlock(r.h.compression)
+	}
+
+	r.block = make([]float32, w*lines*nch)
+
+	for idx := first; idx < first+count; idx++ {
+		x0, y0, nx, ny, pix, err := r.readChunk(idx)
+
+		if err != nil {
+			r.block = nil
+			return err
+		}
+
+		r.blockY, r.blockH = y0, ny
+
+		for j := 0; j < ny; j++ {
+			copy(r.block[(j*w+x0)*nch:(j*w+x0+nx)*nch], pix[j*nx*nch:(j+1)*nx*nch])
+		}
+	}
+
+	return nil
+}
+
+// ReadTile reads the tile with origin x,y (in data window coordinates) into buf.  Tiles at
+// the right and bottom edges are padded to the full tile size.
+func (r *Reader) ReadTile(x, y, z int, ty image.TypeDesc, buf interface{}) error {
+	if !r.h.tiled {
+		return errors.New("exr: image isn't tiled")
+	}
+
+	x -= r.spec.X
+	y -= r.spec.Y
+
+	if x < 0 || y < 0 || x%r.h.tileW != 0 || y%r.h.tileH != 0 || x >= r.spec.Width || y >= r.spec.Height {
+		return errors.New("exr: invalid tile origin")
+	}
+
+	nch := r.spec.NChannels
+
+	_, _, nx, ny, pix, err := r.readChunk((y/r.h.tileH)*r.h.tilesX() + x/r.h.tileW)
+
+	if err != nil {
+		return err
+	}
+
+	tile := make([]float32, r.h.tileW*r.h.tileH*nch)
+
+	for j := 0; j < ny; j++ {
+		copy(tile[j*r.h.tileW*nch:], pix[j*nx*nch:(j+1)*nx*nch])
+	}
+
+	return image.FromFloat32(ty, tile, buf)
+}
+
+// Supports returns true if the reader supports the given feature.
+func (r *Reader) Supports(tag string) bool {
+	switch tag {
+	case "tiles", "channelformats", "displaywindow", "origin":
+		return true
+	}
+
+	return false
+}
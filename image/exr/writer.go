@@ -0,0 +1,367 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jamiec7919/vermeer/image"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Writer implements the OpenEXR writer.  Images are written as scanlines unless
+// Spec.TileWidth and Spec.TileHeight are set.  UINT8 channels are stored as half.
+type Writer struct {
+	file    *os.File
+	spec    image.Spec
+	h       *header
+	specIdx []int // Spec channel index for each file channel
+}
+
+func init() {
+	image.RegisterWriter(func(filename string) (image.Writer, error) {
+		if strings.ToLower(filepath.Ext(filename)) == ".exr" {
+			return &Writer{}, nil
+		}
+
+		return nil, image.ErrNoWriter
+	})
+}
+
+// Open opens file filename, fills in spec and returns nil on success.
+func (w *Writer) Open(filename string, spec *image.Spec) error {
+	return w.OpenMode(filename, spec, "")
+}
+
+// OpenMode opens file filename, fills in spec and returns nil on success.
+func (w *Writer) OpenMode(filename string, spec *image.Spec, mode string) error {
+	h, specIdx, err := newHeader(spec)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.spec = *spec
+	w.h = h
+	w.specIdx = specIdx
+
+	return nil
+}
+
+// standardAttribs are the attributes written from the header fields.  Spec.ExtraAttribs
+// entries with these names, such as those of a Spec returned by the reader, aren't written
+// again.
+var standardAttribs = map[string]bool{
+	"channels":           true,
+	"compression":        true,
+	"dataWindow":         true,
+	"displayWindow":      true,
+	"lineOrder":          true,
+	"pixelAspectRatio":   true,
+	"screenWindowCenter": true,
+	"screenWindowWidth":  true,
+	"tiles":              true,
+}
+
+// newHeader builds the file header for spec, returning the header and the Spec channel
+// index of each (sorted) file channel.  The "compression" and float32 "pixelAspectRatio"
+// and "screenWindowWidth" entries of spec.ExtraAttribs set those attributes.
+func newHeader(spec *image.Spec) (*header, []int, error) {
+	if spec.Width <= 0 || spec.Height <= 0 {
+		return nil, nil, errors.New("exr: invalid image size")
+	}
+
+	names := spec.ChannelNames
+	nch := spec.NChannels
+
+	if nch == 0 {
+		nch = 3
+	}
+
+	if names == nil {
+		switch nch {
+		case 1:
+			names = []string{"Y"}
+		case 3:
+			names = []string{"R", "G", "B"}
+		case 4:
+			names = []string{"R", "G", "B", "A"}
+		default:
+			return nil, nil, errors.New("exr: channel names required")
+		}
+	}
+
+	if len(names) != nch {
+		return nil, nil, errors.New("exr: channel names don't match NChannels")
+	}
+
+	h := &header{
+		compression:       compressZIP,
+		pixelAspectRatio:  1,
+		screenWindowWidth: 1,
+		extra:             make(map[string]interface{}),
+	}
+
+	for k, v := range spec.ExtraAttribs {
+		switch k {
+		case "compression":
+			c, present := compressionNames[fmt.Sprint(v)]
+
+			if !present {
+				return nil, nil, fmt.Errorf("exr: unsupported compression %v", v)
+			}
+
+			h.compression = c
+
+		case "pixelAspectRatio":
+			if a, ok := v.(float32); ok {
+				h.pixelAspectRatio = a
+			}
+
+		case "screenWindowWidth":
+			if a, ok := v.(float32); ok {
+				h.screenWindowWidth = a
+			}
+		}
+
+		if !standardAttribs[k] {
+			h.extra[k] = v
+		}
+	}
+
+	specIdx := make([]int, nch)
+
+	for i := range names {
+		c := channel{name: names[i], pixelType: pixelFloat, xSampling: 1, ySampling: 1}
+
+		ty := image.FLOAT
+
+		switch len(spec.Format) {
+		case 0:
+		case 1:
+			ty = spec.Format[0].BaseType
+		default:
+			ty = spec.Format[i].BaseType
+		}
+
+		if ty == image.HALF || ty == image.UINT8 {
+			c.pixelType = pixelHalf
+		}
+
+		h.channels = append(h.channels, c)
+		specIdx[i] = i
+	}
+
+	sort.Sort(byName{h.channels, specIdx})
+
+	for i := 1; i < len(h.channels); i++ {
+		if h.channels[i].name == h.channels[i-1].name {
+			return nil, nil, fmt.Errorf("exr: duplicate channel %v", h.channels[i].name)
+		}
+	}
+
+	h.dataWindow = box2i{int32(spec.X), int32(spec.Y), int32(spec.X + spec.Width - 1), int32(spec.Y + spec.Height - 1)}
+
+	if spec.FullWidth > 0 && spec.FullHeight > 0 {
+		h.displayWindow = box2i{int32(spec.FullX), int32(spec.FullY),
+			int32(spec.FullX + spec.FullWidth - 1), int32(spec.FullY + spec.FullHeight - 1)}
+	} else {
+		h.displayWindow = h.dataWindow
+	}
+
+	if spec.TileWidth > 0 && spec.TileHeight > 0 {
+		h.tiled = true
+		h.tileW = spec.TileWidth
+		h.tileH = spec.TileHeight
+	}
+
+	return h, specIdx, nil
+}
+
+// byName sorts channels (and the matching Spec indices) by name.
+type byName struct {
+	c   []channel
+	idx []int
+}
+
+func (s byName) Len() int           { return len(s.c) }
+func (s byName) Less(i, j int) bool { return s.c[i].name < s.c[j].name }
+func (s byName) Swap(i, j int) {
+	s.c[i], s.c[j] = s.c[j], s.c[i]
+	s.idx[i], s.idx[j] = s.idx[j], s.idx[i]
+}
+
+// Close closes the writer.
+func (w *Writer) Close() {
+	w.file.Close()
+}
+
+// pack converts the rectangle of img at x0,y0 of size nx,ny into raw scanline then channel
+// ordered pixels.
+func (w *Writer) pack(img []float32, x0, y0, nx, ny int) []byte {
+	nch := len(w.h.channels)
+	raw := make([]byte, w.h.bytesPerLine(nx)*ny)
+	le := binary.LittleEndian
+	p := 0
+
+	for y := y0; y < y0+ny; y++ {
+		for c := range w.h.channels {
+			idx := (y*w.spec.Width+x0)*nch + w.specIdx[c]
+
+			switch w.h.channels[c].pixelType {
+			case pixelHalf:
+				for x := 0; x < nx; x++ {
+					le.PutUint16(raw[p:], uint16(m.Float32ToFloat16(img[idx+x*nch])))
+					p += 2
+				}
+			default:
+				for x := 0; x < nx; x++ {
+					le.PutUint32(raw[p:], math.Float32bits(img[idx+x*nch]))
+					p += 4
+				}
+			}
+		}
+	}
+
+	return raw
+}
+
+// WriteImage writes the image in buf described by ty.
+func (w *Writer) WriteImage(ty image.TypeDesc, buf interface{}) error {
+	img, err := image.ToFloat32(ty, buf)
+
+	if err != nil {
+		return err
+	}
+
+	h := w.h
+	width, height := h.dataWindow.width(), h.dataWindow.height()
+
+	if len(img) < width*height*len(h.channels) {
+		return errors.New("exr: pixel buffer too small")
+	}
+
+	var chunks [][]byte
+	le := binary.LittleEndian
+
+	if h.tiled {
+		for tileY := 0; tileY < h.tilesY(); tileY++ {
+			for tileX := 0; tileX < h.tilesX(); tileX++ {
+				x0, y0 := tileX*h.tileW, tileY*h.tileH
+				nx, ny := h.tileW, h.tileH
+
+				if x0+nx > width {
+					nx = width - x0
+				}
+
+				if y0+ny > height {
+					ny = height - y0
+				}
+
+				data := compressBlock(h.compression, w.pack(img, x0, y0, nx, ny), h.channels, nx, ny)
+				chunk := make([]byte, 20, 20+len(data))
+
+				le.PutUint32(chunk[0:], uint32(tileX))
+				le.PutUint32(chunk[4:], uint32(tileY))
+				le.PutUint32(chunk[8:], 0)
+				le.PutUint32(chunk[12:], 0)
+				le.PutUint32(chunk[16:], uint32(len(data)))
+
+				chunks = append(chunks, append(chunk, data...))
+			}
+		}
+	} else {
+		lpb := linesPerBlock(h.compression)
+
+		for y0 := 0; y0 < height; y0 += lpb {
+			ny := lpb
+
+			if y0+ny > height {
+				ny = height - y0
+			}
+
+			data := compressBlock(h.compression, w.pack(img, 0, y0, width, ny), h.channels, width, ny)
+			chunk := make([]byte, 8, 8+len(data))
+
+			le.PutUint32(chunk[0:], uint32(int32(y0)+h.dataWindow.yMin))
+			le.PutUint32(chunk[4:], uint32(len(data)))
+
+			chunks = append(chunks, append(chunk, data...))
+		}
+	}
+
+	hdr := writeHeader(h)
+	offsets := make([]byte, 8*len(chunks))
+	offset := int64(len(hdr) + len(offsets))
+
+	for i := range chunks {
+		le.PutUint64(offsets[i*8:], uint64(offset))
+		offset += int64(len(chunks[i]))
+	}
+
+	if _, err := w.file.Write(hdr); err != nil {
+		return err
+	}
+
+	if _, err := w.file.Write(offsets); err != nil {
+		return err
+	}
+
+	for i := range chunks {
+		if _, err := w.file.Write(chunks[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteImageStride writes the image in buf described by ty with strides as given.
+func (w *Writer) WriteImageStride(ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("EXR WriteImageStride: unsupported")
+}
+
+// WriteScanline writes the scanline in buf described by ty at position y,z.
+func (w *Writer) WriteScanline(y, z int, ty image.TypeDesc, buf interface{}) error {
+	return errors.New("EXR WriteScanline: unsupported")
+}
+
+// WriteScanlineStride writes the scanline in buf described by ty with strides as given.
+func (w *Writer) WriteScanlineStride(y, z int, ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("EXR WriteScanlineStride: unsupported")
+}
+
+// WriteTile writes the image tile in buf described by ty at position x,y,z.
+func (w *Writer) WriteTile(x, y, z int, ty image.TypeDesc, buf interface{}) error {
+	return errors.New("EXR WriteTile: unsupported")
+}
+
+// WriteTileStride writes the image tile in buf described by ty with strides as given.
+func (w *Writer) WriteTileStride(x, y, z int, ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("EXR WriteTileStride: unsupported")
+}
+
+// Supports returns true if the feature in tag is supported.
+func (w *Writer) Supports(tag string) bool {
+	switch tag {
+	case "tiles", "channelformats", "displaywindow", "origin":
+		return true
+	}
+
+	return false
+}
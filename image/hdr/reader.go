@@ -17,6 +17,7 @@ type Reader struct {
 	file   *os.File
 	reader *bufio.Reader
 	spec   image.Spec
+	flip   bool // Scanlines are stored bottom to top (+Y)
 }
 
 func init() {
@@ -91,9 +92,10 @@ func (h *Reader) readHeaders() error {
 	h.spec.FullWidth = width
 	h.spec.FullX = 0 //xs
 	h.spec.FullY = 0 //ys
-	h.spec.NChannels = 4
-	h.spec.Format = []image.TypeDesc{image.TypeDesc{}, image.TypeDesc{}, image.TypeDesc{}, image.TypeDesc{}}
-	h.spec.ChannelNames = []string{"R", "G", "B", "E"}
+	h.flip = ys == "+Y"
+	h.spec.NChannels = 3
+	h.spec.Format = []image.TypeDesc{image.TypeDesc{BaseType: image.FLOAT}}
+	h.spec.ChannelNames = []string{"R", "G", "B"}
 	h.spec.AlphaChannel = -1
 	h.spec.ZChannel = -1
 
@@ -120,11 +122,18 @@ func (h *Reader) ReadImage(ty image.TypeDesc, buf interface{}) error {
 
 	scanline := make([]byte, h.spec.Width*4)
 
-	for j := 0; j < h.spec.Height; j++ {
-		if err := readScanline(h.reader, scanline[j*h.spec.Width*4:j*h.spec.Width*4+h.spec.Width*4]); err != nil {
+	for k := 0; k < h.spec.Height; k++ {
+		if err := readScanline(h.reader, scanline); err != nil {
 			return err
 		}
 
+		// Images are returned top to bottom.
+		j := k
+
+		if h.flip {
+			j = h.spec.Height - k - 1
+		}
+
 		for i := 0; i < h.spec.Width; i++ {
 			or := scanline[(i*4)+0]
 			og := scanline[(i*4)+1]
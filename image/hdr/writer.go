@@ -37,6 +37,10 @@ func (w *Writer) Open(filename string, spec *image.Spec) error {
 
 // OpenMode opens file filename, fills in spec and returns nil on success.
 func (w *Writer) OpenMode(filename string, spec *image.Spec, mode string) error {
+	if spec.NChannels != 0 && spec.NChannels != 3 {
+		return errors.New("HDR: only supports RGB images")
+	}
+
 	file, err := os.Create(filename)
 
 	if err != nil {
@@ -70,13 +74,12 @@ func (w *Writer) WriteImage(ty image.TypeDesc, buf interface{}) error {
 	fmt.Fprintf(w.file, "# %v\n", "Created by Vermeer Light Tools (http://www.vermeerlt.com)")
 	fmt.Fprintf(w.file, "FORMAT=32-bit_rle_rgbe\n")
 	fmt.Fprintf(w.file, "\n")
-	fmt.Fprintf(w.file, "+Y %v +X %v\n", w.spec.Height, w.spec.Width)
+	fmt.Fprintf(w.file, "-Y %v +X %v\n", w.spec.Height, w.spec.Width)
 
 	scanline := make([]byte, w.spec.Width*4)
 
-	for j := 0; j < w.spec.Height; j++ {
+	for k := 0; k < w.spec.Height; k++ {
 		for i := 0; i < w.spec.Width; i++ {
-			k := w.spec.Height - j - 1
 			r, g, b, e := convertRGBToRGBE(pbuf[(i+(k*w.spec.Width))*3+0], pbuf[(i+(k*w.spec.Width))*3+1], pbuf[(i+(k*w.spec.Width))*3+2])
 			scanline[i*4+0] = r
 			scanline[i*4+1] = g
@@ -26,6 +26,8 @@ type Aggregate int
 const (
 	UINT8 BaseType = iota
 	FLOAT
+	HALF   // 16 bit IEEE float (math.Float16)
+	UINT16 // 16 bit unsigned integer
 )
 
 // Enum for Aggregate.
@@ -45,7 +47,9 @@ type TypeDesc struct {
 	Aggregate Aggregate
 }
 
-// Spec describes an image.
+// Spec describes an image.  Format may hold a single TypeDesc for all channels or one per
+// channel.  If NChannels is 0 then the image is assumed to be RGB.  Pixel buffers are stored
+// with interleaved channels from the top scanline down.
 type Spec struct {
 	Width, Height, Depth             int
 	X, Y, Z                          int
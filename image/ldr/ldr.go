@@ -0,0 +1,266 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package ldr implements image writers for low dynamic range formats: PNG, TIFF and JPEG.
+
+PNG and TIFF files are written with 8 bits per channel unless the Spec Format is UINT16.
+JPEG is always 8 bit and ignores any alpha channel, the quality (1-100, default 90) may be
+set with the "quality" entry of Spec.ExtraAttribs.
+
+FLOAT and HALF pixels are treated as linear scene referred values and converted through the
+image.ViewTransform given by Spec.ExtraAttribs, UINT8 and UINT16 pixels are written as is.
+Images with 1 (grey), 3 (RGB) or 4 (RGBA) channels are supported.
+*/
+package ldr
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jamiec7919/vermeer/image"
+	"golang.org/x/image/tiff"
+	goimage "image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type format int
+
+const (
+	formatPNG format = iota
+	formatTIFF
+	formatJPEG
+)
+
+// Writer implements the PNG, TIFF and JPEG writers.
+type Writer struct {
+	file   *os.File
+	spec   image.Spec
+	format format
+	view   image.ViewTransform
+	depth  int // 8 or 16 bits per channel
+}
+
+func init() {
+	image.RegisterWriter(func(filename string) (image.Writer, error) {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".png":
+			return &Writer{format: formatPNG}, nil
+		case ".tif", ".tiff":
+			return &Writer{format: formatTIFF}, nil
+		case ".jpg", ".jpeg":
+			return &Writer{format: formatJPEG}, nil
+		}
+
+		return nil, image.ErrNoWriter
+	})
+}
+
+// Open opens file filename, fills in spec and returns nil on success.
+func (w *Writer) Open(filename string, spec *image.Spec) error {
+	return w.OpenMode(filename, spec, "")
+}
+
+// OpenMode opens file filename, fills in spec and returns nil on success.
+func (w *Writer) OpenMode(filename string, spec *image.Spec, mode string) error {
+	switch spec.NChannels {
+	case 0, 1, 3, 4:
+	default:
+		return errors.New("LDR: only supports grey, RGB or RGBA images")
+	}
+
+	view, err := image.ViewTransformFromSpec(spec)
+
+	if err != nil {
+		return fmt.Errorf("LDR: %v", err)
+	}
+
+	w.view = view
+	w.depth = 8
+
+	if len(spec.Format) > 0 && spec.Format[0].BaseType == image.UINT16 && w.format != formatJPEG {
+		w.depth = 16
+	}
+
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.spec = *spec
+
+	if w.spec.NChannels == 0 {
+		w.spec.NChannels = 3
+	}
+
+	return nil
+}
+
+// Close closes the writer.
+func (w *Writer) Close() {
+	w.file.Close()
+}
+
+// toImage returns the display referred pixels in [0,1] as a Go image.
+func (w *Writer) toImage(pix []float32) goimage.Image {
+	width, height, nch := w.spec.Width, w.spec.Height, w.spec.NChannels
+	rect := goimage.Rect(0, 0, width, height)
+
+	if w.depth == 16 {
+		q := func(x float32) uint16 { return uint16(x*65535 + 0.5) }
+
+		if nch == 1 {
+			img := goimage.NewGray16(rect)
+
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					img.SetGray16(x, y, color.Gray16{q(pix[y*width+x])})
+				}
+			}
+
+			return img
+		}
+
+		img := goimage.NewNRGBA64(rect)
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				p := pix[(y*width+x)*nch:]
+				c := color.NRGBA64{q(p[0]), q(p[1]), q(p[2]), 0xffff}
+
+				if nch == 4 {
+					c.A = q(p[3])
+				}
+
+				img.SetNRGBA64(x, y, c)
+			}
+		}
+
+		return img
+	}
+
+	q := func(x float32) uint8 { return uint8(x*255 + 0.5) }
+
+	if nch == 1 {
+		img := goimage.NewGray(rect)
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.SetGray(x, y, color.Gray{q(pix[y*width+x])})
+			}
+		}
+
+		return img
+	}
+
+	img := goimage.NewNRGBA(rect)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := pix[(y*width+x)*nch:]
+			c := color.NRGBA{q(p[0]), q(p[1]), q(p[2]), 0xff}
+
+			if nch == 4 && w.format != formatJPEG {
+				c.A = q(p[3])
+			}
+
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// WriteImage writes the image in buf described by ty.
+func (w *Writer) WriteImage(ty image.TypeDesc, buf interface{}) error {
+	src, err := image.ToFloat32(ty, buf)
+
+	if err != nil {
+		return err
+	}
+
+	nch := w.spec.NChannels
+
+	if len(src) < w.spec.Width*w.spec.Height*nch {
+		return errors.New("LDR: pixel buffer too small")
+	}
+
+	pix := make([]float32, w.spec.Width*w.spec.Height*nch)
+
+	for i := range pix {
+		v := src[i]
+
+		switch {
+		case ty.BaseType != image.FLOAT && ty.BaseType != image.HALF:
+		case nch == 4 && i%4 == 3:
+			// Alpha isn't view transformed
+		default:
+			v = w.view.Apply(v)
+		}
+
+		if v < 0 {
+			v = 0
+		} else if v > 1 {
+			v = 1
+		}
+
+		pix[i] = v
+	}
+
+	img := w.toImage(pix)
+
+	switch w.format {
+	case formatPNG:
+		return png.Encode(w.file, img)
+	case formatTIFF:
+		return tiff.Encode(w.file, img, &tiff.Options{Compression: tiff.Deflate})
+	default:
+		quality := 90
+
+		if q, present := w.spec.ExtraAttribs["quality"]; present {
+			if quality, err = strconv.Atoi(fmt.Sprint(q)); err != nil {
+				return fmt.Errorf("LDR: invalid quality %v", q)
+			}
+		}
+
+		return jpeg.Encode(w.file, img, &jpeg.Options{Quality: quality})
+	}
+}
+
+// WriteImageStride writes the image in buf described by ty with strides as given.
+func (w *Writer) WriteImageStride(ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("LDR WriteImageStride: unsupported")
+}
+
+// WriteScanline writes the scanline in buf described by ty at position y,z.
+func (w *Writer) WriteScanline(y, z int, ty image.TypeDesc, buf interface{}) error {
+	return errors.New("LDR WriteScanline: unsupported")
+}
+
+// WriteScanlineStride writes the scanline in buf described by ty with strides as given.
+func (w *Writer) WriteScanlineStride(y, z int, ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("LDR WriteScanlineStride: unsupported")
+}
+
+// WriteTile writes the image tile in buf described by ty at position x,y,z.
+func (w *Writer) WriteTile(x, y, z int, ty image.TypeDesc, buf interface{}) error {
+	return errors.New("LDR WriteTile: unsupported")
+}
+
+// WriteTileStride writes the image tile in buf described by ty with strides as given.
+func (w *Writer) WriteTileStride(x, y, z int, ty image.TypeDesc, buf interface{}, xstride, ystride, zstride int) error {
+	return errors.New("LDR WriteTileStride: unsupported")
+}
+
+// Supports returns true if the feature in tag is supported.
+func (w *Writer) Supports(tag string) bool {
+	return false
+}
@@ -0,0 +1,99 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package image
+
+import (
+	"fmt"
+	m "github.com/jamiec7919/vermeer/math"
+	"strconv"
+	"strings"
+)
+
+// ViewTransform converts linear scene referred values into display referred values in [0,1]
+// for writing to low dynamic range formats.  The exposure (in stops) is applied first, then
+// the tone curve and finally the OETF.
+//
+// Tone curves are "" or "none" (clip), "reinhard" and "aces" (Narkowicz's fit of the ACES
+// filmic curve).  OETFs are "" or "srgb" and "linear".
+type ViewTransform struct {
+	Exposure  float32
+	ToneCurve string
+	OETF      string
+}
+
+// ViewTransformFromSpec returns the view transform given by the "exposure", "tonecurve" and
+// "oetf" entries of spec.ExtraAttribs.
+func ViewTransformFromSpec(spec *Spec) (v ViewTransform, err error) {
+	if e, present := spec.ExtraAttribs["exposure"]; present {
+		switch t := e.(type) {
+		case float32:
+			v.Exposure = t
+		case float64:
+			v.Exposure = float32(t)
+		case int:
+			v.Exposure = float32(t)
+		default:
+			f, err := strconv.ParseFloat(fmt.Sprint(e), 32)
+
+			if err != nil {
+				return v, fmt.Errorf("invalid exposure %v", e)
+			}
+
+			v.Exposure = float32(f)
+		}
+	}
+
+	if c, present := spec.ExtraAttribs["tonecurve"]; present {
+		v.ToneCurve = strings.ToLower(fmt.Sprint(c))
+	}
+
+	if o, present := spec.ExtraAttribs["oetf"]; present {
+		v.OETF = strings.ToLower(fmt.Sprint(o))
+	}
+
+	switch v.ToneCurve {
+	case "", "none", "reinhard", "aces":
+	default:
+		return v, fmt.Errorf("unknown tone curve %v", v.ToneCurve)
+	}
+
+	switch v.OETF {
+	case "", "srgb", "linear":
+	default:
+		return v, fmt.Errorf("unknown OETF %v", v.OETF)
+	}
+
+	return v, nil
+}
+
+// Apply applies the transform to the linear value x.
+func (v *ViewTransform) Apply(x float32) float32 {
+	x *= m.Pow(2, v.Exposure)
+
+	if x < 0 {
+		x = 0
+	}
+
+	switch v.ToneCurve {
+	case "reinhard":
+		x = x / (1 + x)
+	case "aces":
+		x = (x * (2.51*x + 0.03)) / (x*(2.43*x+0.59) + 0.14)
+	}
+
+	x = m.Clamp(x, 0, 1)
+
+	switch v.OETF {
+	case "linear":
+	default:
+		if x <= 0.0031308 {
+			x *= 12.92
+		} else {
+			x = 1.055*m.Pow(x, 1/2.4) - 0.055
+		}
+	}
+
+	return x
+}
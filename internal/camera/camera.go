@@ -200,60 +200,120 @@ func (c *Camera) calcBasisLookat(from, to, up m.Vec3, roll float32) {
 	D := vm.Vec3Normalize(vm.Vec3Sub(s, e))
 
 */
-/*
-// SampleLensArea samples the lens according to area.
-func (c *Camera) SampleLensArea(rnd *rand.Rand, P *m.Vec3, We *float32, pdf *float32) error {
-	*P = c.From
+// transform returns the camera to world transform at time.
+func (c *Camera) transform(time float32) m.Matrix4 {
+	if c.decomp == nil {
+		return m.Matrix4Identity
+	}
+
+	k := time * float32(len(c.decomp)-1)
+
+	t := k - m.Floor(k)
+
+	key := int(m.Floor(k))
+	key2 := int(m.Ceil(k))
+
+	trn := m.TransformDecompLerp(c.decomp[key], c.decomp[key2], t)
 
+	return m.TransformDecompToMatrix4(trn)
+}
+
+// lensArea returns the area of the lens, 1 for a pinhole camera.
+func (c *Camera) lensArea() float32 {
 	if c.Radius > 0.0 {
-		x, y := sample.UniformDisk2D(c.Radius, rnd.Float32(), rnd.Float32())
-		e := m.Vec3Add(m.Vec3Scale(x, c.U), m.Vec3Scale(y, c.V))
-		*P = m.Vec3Add(*P, e)
+		return m.Pi * c.Radius * c.Radius
+	}
+	return 1
+}
 
-		*We = 1.0 / (m.Pi * c.Radius * c.Radius)
-		*pdf = 1.0 / (m.Pi * c.Radius * c.Radius)
-	} else {
-		*We = 1
-		*pdf = 1
+// screen returns the screen position that the camera space ray from lens point e in
+// direction d sees, ok is false if it is outside the screen.
+func (c *Camera) screen(e, d m.Vec3) (u, v float32, ok bool) {
+	if d[2] >= 0 {
+		return 0, 0, false
 	}
 
-	return nil
+	// Point on the plane in focus
+	s := m.Vec3Add(e, m.Vec3Scale(c.Focal/-d[2], d))
+
+	u = s[0] / c.TanThetaFocal
+	v = s[1] * c.Aspect / c.TanThetaFocal
+
+	return u, v, m.Abs(u) <= 1 && m.Abs(v) <= 1
 }
 
-// SampleImagePlaneDir samples a direction from the image plane.
-func (c *Camera) SampleImagePlaneDir(u, v float32, P m.Vec3, rnd *rand.Rand, omegaO *m.Vec3, We *float32, pdf *float32) error {
+// screenArea returns the area of the screen at distance 1 from the lens.
+func (c *Camera) screenArea() float32 {
+	tanTheta := c.TanThetaFocal / c.Focal
 
-	camu := u * c.TanThetaFocal
-	camv := v * (c.TanThetaFocal / c.Aspect)
+	return 4 * tanTheta * tanTheta / c.Aspect
+}
 
-	s := m.Vec3Sub(m.Vec3Add(m.Vec3Scale(camu, c.U), m.Vec3Scale(camv, c.V)), m.Vec3Scale(c.Focal, c.W))
-	*omegaO = m.Vec3Normalize(m.Vec3Sub(m.Vec3Add(c.From, s), P))
+// importance returns We for a ray leaving the lens with the given cosine to the view
+// direction, We = 1 / (A * lensArea * cos^4) for screen area A.
+func (c *Camera) importance(cosTheta float32) float32 {
+	cos2 := cosTheta * cosTheta
 
-	cosOmegaO := m.Vec3Dot(*omegaO, m.Vec3Neg(c.W))
-	Apixel := float32(1.0) // pixel density WRT image size
-	*We = (c.Focal * c.Focal) / (Apixel * cosOmegaO * cosOmegaO * cosOmegaO)
-	*pdf = (c.Focal * c.Focal) / (Apixel * cosOmegaO * cosOmegaO * cosOmegaO)
-	return nil
+	return 1 / (c.screenArea() * c.lensArea() * cos2 * cos2)
 }
-*/
 
-// ComputeRay calculates a position and direction for a sampled ray.
-func (c *Camera) ComputeRay(u, v, time float32, rnd *rand.Rand, ray *core.RayData, sg *core.ShaderGlobals) {
-	M := m.Matrix4Identity
+// SampleLens implements core.LensCamera.
+func (c *Camera) SampleLens(P m.Vec3, time float32, rnd *rand.Rand) (lens m.Vec3, u, v, We, pdf float32, ok bool) {
+	M := c.transform(time)
+	Minv, ok := m.Matrix4Inverse(M)
 
-	if c.decomp != nil {
-		k := time * float32(len(c.decomp)-1)
+	if !ok {
+		return
+	}
 
-		t := k - m.Floor(k)
+	e := m.Vec3{}
 
-		key := int(m.Floor(k))
-		key2 := int(m.Ceil(k))
+	if c.Radius > 0.0 {
+		x, y := sample.UniformDisk2D(c.Radius, rnd.Float32(), rnd.Float32())
+		e = m.Vec3{x, y, 0}
+	}
 
-		trn := m.TransformDecompLerp(c.decomp[key], c.decomp[key2], t)
+	d := m.Vec3Sub(m.Matrix4MulPoint(Minv, P), e)
 
-		M = m.TransformDecompToMatrix4(trn)
+	if u, v, ok = c.screen(e, d); !ok {
+		return
 	}
 
+	dist2 := m.Vec3Length2(d)
+	cosTheta := -d[2] / m.Sqrt(dist2)
+
+	lens = m.Matrix4MulPoint(M, e)
+	We = c.importance(cosTheta)
+	pdf = dist2 / (cosTheta * c.lensArea())
+
+	return
+}
+
+// PDF implements core.LensCamera.
+func (c *Camera) PDF(P, D m.Vec3, time float32) (pdfA, pdfD float32) {
+	M := c.transform(time)
+	Minv, ok := m.Matrix4Inverse(M)
+
+	if !ok {
+		return 0, 0
+	}
+
+	e := m.Matrix4MulPoint(Minv, P)
+	d := m.Vec3Normalize(m.Matrix4MulVec(Minv, D))
+
+	if _, _, ok := c.screen(e, d); !ok {
+		return 0, 0
+	}
+
+	cosTheta := -d[2]
+
+	return 1 / c.lensArea(), 1 / (c.screenArea() * cosTheta * cosTheta * cosTheta)
+}
+
+// ComputeRay calculates a position and direction for a sampled ray.
+func (c *Camera) ComputeRay(u, v, time float32, rnd *rand.Rand, ray *core.RayData, sg *core.ShaderGlobals) {
+	M := c.transform(time)
+
 	// D = || u*U + v*V - d*W  ||
 
 	camu := u * c.TanThetaFocal
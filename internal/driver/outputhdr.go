@@ -5,21 +5,33 @@
 package driver
 
 import (
+	"fmt"
 	"github.com/jamiec7919/vermeer/core"
 	"github.com/jamiec7919/vermeer/image"
 	"github.com/jamiec7919/vermeer/nodes"
 )
 
-// OutputHDR is a node which saves the rendered image intoa Radiance HDR file.
+// OutputHDR is a node which saves the rendered image intoa Radiance HDR file.  If LDR is
+// set a low dynamic range copy (e.g. a PNG) is also written using the view transform given
+// by Exposure and ToneCurve.
 type OutputHDR struct {
-	Filename string
+	Filename  string
+	AOV       string // Name of AOV to save instead of the beauty image (optional)
+	LDR       string // Filename of low dynamic range copy (optional)
+	Exposure  float32
+	ToneCurve string
 }
 
 // Name is a core.Node method.
 func (n *OutputHDR) Name() string { return "OutputHDR<>" }
 
 // PreRender is a core.Node method.
-func (n *OutputHDR) PreRender(rc *core.RenderContext) error { return nil }
+func (n *OutputHDR) PreRender(rc *core.RenderContext) error {
+	if n.AOV != "" {
+		rc.RequestAOV(n.AOV)
+	}
+	return nil
+}
 
 // PostRender is a core.Node method.
 func (n *OutputHDR) PostRender(rc *core.RenderContext) error {
@@ -29,11 +41,15 @@ func (n *OutputHDR) PostRender(rc *core.RenderContext) error {
 		return err
 	}
 
-	w, h := rc.OutputRes()
+	x, y, w, h := rc.DataWindow()
 
 	spec := image.Spec{
-		Width:  w,
-		Height: h,
+		Width:      w,
+		Height:     h,
+		X:          x,
+		Y:          y,
+		FullWidth:  rc.Globals().XRes,
+		FullHeight: rc.Globals().YRes,
 	}
 
 	if err := i.Open(n.Filename, &spec); err != nil {
@@ -42,18 +58,92 @@ func (n *OutputHDR) PostRender(rc *core.RenderContext) error {
 
 	ty := image.TypeDesc{BaseType: image.FLOAT}
 
-	if err := i.WriteImage(ty, rc.Image()); err != nil {
+	img := rc.Image()
+
+	if n.AOV != "" {
+		img = aovToRGB(rc.RequestAOV(n.AOV), rc.AOVImage(n.AOV))
+	}
+
+	img = flipRows(img, w, h, 3)
+
+	if err := i.WriteImage(ty, img); err != nil {
 		return err
 	}
 
 	i.Close()
 
+	if n.LDR != "" {
+		spec.ExtraAttribs = viewAttribs(n.Exposure, n.ToneCurve)
+
+		return writeImage(n.LDR, &spec, img)
+	}
+
 	return nil
 }
 
+// flipRows returns a copy of img with the rows in reverse order.  The render buffers are stored
+// from the bottom row up whereas image files are written top down.
+func flipRows(img []float32, w, h, nch int) []float32 {
+	out := make([]float32, len(img))
+
+	for j := 0; j < h; j++ {
+		copy(out[j*w*nch:(j+1)*w*nch], img[(h-j-1)*w*nch:(h-j)*w*nch])
+	}
+
+	return out
+}
+
+// viewAttribs returns the Spec.ExtraAttribs used to set the view transform for low dynamic
+// range outputs.
+func viewAttribs(exposure float32, toneCurve string) map[string]interface{} {
+	return map[string]interface{}{"exposure": exposure, "tonecurve": toneCurve}
+}
+
+// writeImage writes the float32 image img to filename.
+func writeImage(filename string, spec *image.Spec, img []float32) error {
+	out, err := image.NewWriter(filename)
+
+	if err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+
+	if err := out.Open(filename, spec); err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	return out.WriteImage(image.TypeDesc{BaseType: image.FLOAT}, img)
+}
+
+// aovToRGB expands the AOV image to 3 channels, single channel AOVs are replicated
+// and missing channels are 0.
+func aovToRGB(aov *core.AOV, img []float32) []float32 {
+	n := aov.Type.Components()
+
+	if n == 3 {
+		return img
+	}
+
+	rgb := make([]float32, len(img)/n*3)
+
+	for i := 0; i < len(img)/n; i++ {
+		for k := 0; k < 3; k++ {
+			switch {
+			case n == 1:
+				rgb[i*3+k] = img[i]
+			case k < n:
+				rgb[i*3+k] = img[i*n+k]
+			}
+		}
+	}
+
+	return rgb
+}
+
 func init() {
 	nodes.Register("OutputHDR", func() (core.Node, error) {
-		out := OutputHDR{"out.hdr"}
+		out := OutputHDR{Filename: "out.hdr"}
 
 		return &out, nil
 	})
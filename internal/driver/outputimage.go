@@ -0,0 +1,179 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package driver
+
+import (
+	"fmt"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/image"
+	"github.com/jamiec7919/vermeer/nodes"
+	"strings"
+)
+
+// OutputImage is a node which saves a set of channels (the beauty image and any AOVs) into
+// an image file.  The file format is chosen from the filename extension.  Multiple
+// OutputImage nodes may be used to write several files from one render.
+//
+// Each entry of Channels is the name of an AOV, or RGB for the beauty image, optionally
+// followed by a colon and the data type to store it as (uint8, uint16, half or float), e.g.
+// "N:half".  Channels without a type use Format.
+//
+// Compression selects the compression for formats that support it (e.g. none, rle, zips,
+// zip or piz for OpenEXR).  Exposure and ToneCurve set the view transform used when writing
+// low dynamic range formats (see image.ViewTransform).
+type OutputImage struct {
+	Filename    string
+	Channels    []string
+	Format      string // Default data type for channels
+	Compression string
+	Exposure    float32
+	ToneCurve   string
+
+	layers []outputLayer
+}
+
+// outputLayer is a single entry of Channels.
+type outputLayer struct {
+	name string
+	aov  *core.AOV // nil for the beauty image
+	ty   image.BaseType
+}
+
+// components returns the number of image channels in the layer.
+func (l *outputLayer) components() int {
+	if l.aov == nil {
+		return 3
+	}
+	return l.aov.Type.Components()
+}
+
+// channelNames returns the image channel names for the layer following the OpenEXR
+// naming conventions.
+func (l *outputLayer) channelNames() []string {
+	if l.aov == nil {
+		return []string{"R", "G", "B"}
+	}
+
+	switch l.aov.Type {
+	case core.AOVRGB:
+		return []string{l.name + ".R", l.name + ".G", l.name + ".B"}
+	case core.AOVVec3:
+		return []string{l.name + ".X", l.name + ".Y", l.name + ".Z"}
+	case core.AOVVec2:
+		return []string{l.name + ".X", l.name + ".Y"}
+	}
+
+	return []string{l.name}
+}
+
+func parseBaseType(s string) (image.BaseType, error) {
+	switch strings.ToLower(s) {
+	case "", "float":
+		return image.FLOAT, nil
+	case "half":
+		return image.HALF, nil
+	case "uint8":
+		return image.UINT8, nil
+	case "uint16":
+		return image.UINT16, nil
+	}
+
+	return image.FLOAT, fmt.Errorf("unknown channel type %v", s)
+}
+
+// Name is a core.Node method.
+func (n *OutputImage) Name() string { return "OutputImage<" + n.Filename + ">" }
+
+// PreRender is a core.Node method.  Requests all of the AOVs in Channels.
+func (n *OutputImage) PreRender(rc *core.RenderContext) error {
+	def, err := parseBaseType(n.Format)
+
+	if err != nil {
+		return fmt.Errorf("OutputImage %v: %v", n.Filename, err)
+	}
+
+	n.layers = nil
+
+	for _, ch := range n.Channels {
+		layer := outputLayer{name: ch, ty: def}
+
+		if i := strings.LastIndex(ch, ":"); i != -1 {
+			layer.name = ch[:i]
+
+			if layer.ty, err = parseBaseType(ch[i+1:]); err != nil {
+				return fmt.Errorf("OutputImage %v: %v", n.Filename, err)
+			}
+		}
+
+		if layer.name != "RGB" {
+			layer.aov = rc.RequestAOV(layer.name)
+		}
+
+		n.layers = append(n.layers, layer)
+	}
+
+	return nil
+}
+
+// PostRender is a core.Node method.  Interleaves all channels and writes the file.
+func (n *OutputImage) PostRender(rc *core.RenderContext) error {
+	x, y, w, h := rc.DataWindow()
+
+	spec := image.Spec{
+		Width:        w,
+		Height:       h,
+		X:            x,
+		Y:            y,
+		FullWidth:    rc.Globals().XRes,
+		FullHeight:   rc.Globals().YRes,
+		AlphaChannel: -1,
+		ZChannel:     -1,
+	}
+
+	for i := range n.layers {
+		for _, name := range n.layers[i].channelNames() {
+			spec.ChannelNames = append(spec.ChannelNames, name)
+			spec.Format = append(spec.Format, image.TypeDesc{BaseType: n.layers[i].ty})
+		}
+	}
+
+	spec.NChannels = len(spec.ChannelNames)
+	spec.ExtraAttribs = viewAttribs(n.Exposure, n.ToneCurve)
+
+	if n.Compression != "" {
+		spec.ExtraAttribs["compression"] = n.Compression
+	}
+
+	buf := make([]float32, w*h*spec.NChannels)
+
+	offset := 0
+
+	for i := range n.layers {
+		l := &n.layers[i]
+		nc := l.components()
+
+		img := rc.Image()
+
+		if l.aov != nil {
+			img = rc.AOVImage(l.name)
+		}
+
+		for p := 0; p < w*h; p++ {
+			copy(buf[p*spec.NChannels+offset:p*spec.NChannels+offset+nc], img[p*nc:p*nc+nc])
+		}
+
+		offset += nc
+	}
+
+	return writeImage(n.Filename, &spec, flipRows(buf, w, h, spec.NChannels))
+}
+
+func init() {
+	nodes.Register("OutputImage", func() (core.Node, error) {
+		out := OutputImage{Filename: "out.hdr", Channels: []string{"RGB"}}
+
+		return &out, nil
+	})
+}
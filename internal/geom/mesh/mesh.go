@@ -168,6 +168,7 @@ func (mesh *Meshfile) Name() string { return mesh.NodeName }
 
 // PreRender implements core.Node.
 func (mesh *Meshfile) PreRender(rc *core.RenderContext) error {
+	rc.AddAsset(mesh.Filename)
 
 	for _, open := range loaders {
 		loader, err := open(rc, mesh.Filename)
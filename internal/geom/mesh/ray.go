@@ -660,6 +660,7 @@ func (mesh *Mesh) traceRayAccelIndexed(ray *core.RayData, sg *core.ShaderGlobals
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			order := [4]int{0, 1, 2, 3} // actually in reverse order as this is order pushed on stack
@@ -746,6 +747,7 @@ func (mesh *Mesh) traceRayAccelIndexedEpsilon(ray *core.RayData, sg *core.Shader
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			order := [4]int{0, 1, 2, 3} // actually in reverse order as this is order pushed on stack
@@ -850,6 +852,7 @@ func (mesh *Mesh) visRayAccelIndexed(ray *core.RayData) {
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			for k := range pnode.Children {
@@ -898,6 +901,7 @@ func (mesh *Mesh) visRayAccelIndexedEpsilon(ray *core.RayData) {
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			for k := range pnode.Children {
@@ -946,6 +950,7 @@ func (mesh *Mesh) traceRayAccel(ray *core.RayData, sg *core.ShaderGlobals) int32
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			order := [4]int{0, 1, 2, 3} // actually in reverse order as this is order pushed on stack
@@ -1031,6 +1036,7 @@ func (mesh *Mesh) traceRayAccelEpsilon(ray *core.RayData, sg *core.ShaderGlobals
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			order := [4]int{0, 1, 2, 3} // actually in reverse order as this is order pushed on stack
@@ -1135,6 +1141,7 @@ func (mesh *Mesh) visRayAccel(ray *core.RayData) {
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			for k := range pnode.Children {
@@ -1183,6 +1190,7 @@ func (mesh *Mesh) visRayAccelEpsilon(ray *core.RayData) {
 
 		if node >= 0 {
 			pnode := &(mesh.nodes[node])
+			ray.Stats.Nnodes++
 			rayNodeIntersectAllASM(&ray.Ray, pnode, &ray.Supp.Hits, &ray.Supp.T)
 
 			for k := range pnode.Children {
@@ -41,6 +41,7 @@ func (mesh *PolyMesh) intersectRayMotion(ray *core.RayData, sg *core.ShaderGloba
 
 		if node >= 0 {
 			pnode := &(mesh.accel.mqbvh.Nodes[node])
+			ray.Stats.Nnodes++
 
 			for i := range ray.Supp.Boxes {
 				ray.Supp.Boxes[i] = (1.0-time)*mesh.accel.mqbvh.Boxes[key][node][i] + time*mesh.accel.mqbvh.Boxes[key2][node][i]
@@ -213,6 +214,7 @@ func (mesh *PolyMesh) intersectRayEpsilonMotion(ray *core.RayData, sg *core.Shad
 
 		if node >= 0 {
 			pnode := &(mesh.accel.mqbvh.Nodes[node])
+			ray.Stats.Nnodes++
 
 			for i := range ray.Supp.Boxes {
 				ray.Supp.Boxes[i] = (1.0-time)*mesh.accel.mqbvh.Boxes[key][node][i] + time*mesh.accel.mqbvh.Boxes[key2][node][i]
@@ -380,6 +382,7 @@ func (mesh *PolyMesh) intersectVisRayMotion(ray *core.RayData) bool {
 
 		if node >= 0 {
 			pnode := &(mesh.accel.mqbvh.Nodes[node])
+			ray.Stats.Nnodes++
 
 			for i := range ray.Supp.Boxes {
 				ray.Supp.Boxes[i] = (1.0-time)*mesh.accel.mqbvh.Boxes[key][node][i] + time*mesh.accel.mqbvh.Boxes[key2][node][i]
@@ -458,6 +461,7 @@ func (mesh *PolyMesh) intersectVisRayEpsilonMotion(ray *core.RayData, epsilon fl
 
 		if node >= 0 {
 			pnode := &(mesh.accel.mqbvh.Nodes[node])
+			ray.Stats.Nnodes++
 
 			for i := range ray.Supp.Boxes {
 				ray.Supp.Boxes[i] = (1.0-time)*mesh.accel.mqbvh.Boxes[key][node][i] + time*mesh.accel.mqbvh.Boxes[key2][node][i]
@@ -76,6 +76,7 @@ func (mesh *PolyMesh) intersectRay(ray *core.RayData, sg *core.ShaderGlobals) in
 
 		if node >= 0 {
 			pnode := &(mesh.accel.qbvh[node])
+			ray.Stats.Nnodes++
 
 			intersectBoxes(&ray.Ray, &pnode.Boxes, &ray.Supp.Hits, &ray.Supp.T)
 
@@ -209,6 +210,7 @@ func (mesh *PolyMesh) intersectRayEpsilon(ray *core.RayData, sg *core.ShaderGlob
 
 		if node >= 0 {
 			pnode := &(mesh.accel.qbvh[node])
+			ray.Stats.Nnodes++
 
 			intersectBoxes(&ray.Ray, &pnode.Boxes, &ray.Supp.Hits, &ray.Supp.T)
 
@@ -341,6 +343,7 @@ func (mesh *PolyMesh) intersectVisRay(ray *core.RayData) bool {
 
 		if node >= 0 {
 			pnode := &(mesh.accel.qbvh[node])
+			ray.Stats.Nnodes++
 
 			intersectBoxes(&ray.Ray, &pnode.Boxes, &ray.Supp.Hits, &ray.Supp.T)
 
@@ -368,6 +371,8 @@ func (mesh *PolyMesh) intersectVisRay(ray *core.RayData) bool {
 				face.V[2] = mesh.Verts.Elems[mesh.idxp[faceidx*3+2]]
 
 				if face.IntersectVisRay(ray) {
+					ray.Ray.Tclosest = 0.5
+
 					return true
 				}
 			}
@@ -398,6 +403,7 @@ func (mesh *PolyMesh) intersectVisRayEpsilon(ray *core.RayData, epsilon float32)
 
 		if node >= 0 {
 			pnode := &(mesh.accel.qbvh[node])
+			ray.Stats.Nnodes++
 
 			intersectBoxes(&ray.Ray, &pnode.Boxes, &ray.Supp.Hits, &ray.Supp.T)
 
@@ -426,6 +432,8 @@ func (mesh *PolyMesh) intersectVisRayEpsilon(ray *core.RayData, epsilon float32)
 				face.V[2] = mesh.Verts.Elems[mesh.idxp[faceidx*3+2]]
 
 				if face.IntersectVisRayEpsilon(ray, epsilon) {
+					ray.Ray.Tclosest = 0.5
+
 					return true
 				}
 			}
@@ -0,0 +1,90 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package polymesh
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Assert that PolyMesh can be sampled as a mesh light.
+var _ core.TriangleMesh = (*PolyMesh)(nil)
+
+// NumTriangles implements core.TriangleMesh.  Deforming meshes can't be sampled and return 0.
+func (mesh *PolyMesh) NumTriangles() int {
+	if mesh.Verts.MotionKeys > 1 {
+		return 0
+	}
+
+	return mesh.facecount
+}
+
+// Triangle implements core.TriangleMesh.
+func (mesh *PolyMesh) Triangle(i int) (V [3]m.Vec3, mtlid int32) {
+	for k := range V {
+		V[k] = mesh.Verts.Elems[mesh.idxp[i*3+k]]
+	}
+
+	return V, mesh.mtlid
+}
+
+// SetupTriangle implements core.TriangleMesh.
+func (mesh *PolyMesh) SetupTriangle(i int, b0, b1 float32, sg *core.ShaderGlobals) {
+	var face Face
+
+	face.V, _ = mesh.Triangle(i)
+	face.setup()
+
+	if mesh.UV.Elems != nil {
+		for k := range face.UV {
+			face.UV[k] = mesh.UV.Elems[mesh.uvtriidx[i*3+k]]
+		}
+	} else {
+		face.UV = [3]m.Vec2{{0, 0}, {1, 0}, {0, 1}}
+	}
+
+	if mesh.Normals.Elems != nil {
+		for k := range face.Ns {
+			face.Ns[k] = mesh.Normals.Elems[mesh.normalidx[i*3+k]]
+		}
+	} else {
+		face.Ns = [3]m.Vec3{face.N, face.N, face.N}
+	}
+
+	w := [3]float32{b0, b1, 1 - b0 - b1}
+
+	sg.P = m.Vec3Add3(m.Vec3Scale(w[0], face.V[0]), m.Vec3Scale(w[1], face.V[1]), m.Vec3Scale(w[2], face.V[2]))
+	sg.Ng = face.N
+	sg.Ns = m.Vec3Normalize(m.Vec3Add3(m.Vec3Scale(w[0], face.Ns[0]), m.Vec3Scale(w[1], face.Ns[1]), m.Vec3Scale(w[2], face.Ns[2])))
+	sg.N = sg.Ns
+	sg.U = w[0]*face.UV[0][0] + w[1]*face.UV[1][0] + w[2]*face.UV[2][0]
+	sg.V = w[0]*face.UV[0][1] + w[1]*face.UV[1][1] + w[2]*face.UV[2][1]
+	sg.Bu, sg.Bv = b0, b1
+	sg.ElemID = uint32(i)
+	sg.DdPdu, sg.DdPdv = derivatives(face.V, face.UV)
+}
+
+// derivatives returns dP/du and dP/dv of the triangle V with texture coordinates UV, or
+// the edges from V[2] if the texture coordinates are degenerate.
+func derivatives(V [3]m.Vec3, UV [3]m.Vec2) (dPdu, dPdv m.Vec3) {
+	s1 := UV[1][0] - UV[0][0]
+	t1 := UV[1][1] - UV[0][1]
+	s2 := UV[2][0] - UV[0][0]
+	t2 := UV[2][1] - UV[0][1]
+
+	e1 := m.Vec3Sub(V[1], V[0])
+	e2 := m.Vec3Sub(V[2], V[0])
+
+	det := s1*t2 - s2*t1
+
+	if det == 0 {
+		return m.Vec3Sub(V[0], V[2]), m.Vec3Sub(V[1], V[2])
+	}
+
+	dPdu = m.Vec3Scale(1/det, m.Vec3Sub(m.Vec3Scale(t2, e1), m.Vec3Scale(t1, e2)))
+	dPdv = m.Vec3Scale(1/det, m.Vec3Sub(m.Vec3Scale(s1, e2), m.Vec3Scale(s2, e1)))
+
+	return
+}
@@ -0,0 +1,339 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package shape provides analytic primitives (disks, quads and spheres) that are intersected
+exactly rather than being tessellated into triangles.  They are created by the area lights
+for their visible geometry.
+*/
+package shape
+
+import (
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+)
+
+// Disk is a flat circular disk with centre P, normal N and tangents T, B (all unit length).
+type Disk struct {
+	NodeName string
+	P        m.Vec3
+	T, B, N  m.Vec3
+	Radius   float32
+	MtlID    int32
+}
+
+// Quad is a rectangle with centre P, normal N and unit tangents T, B along the sides of
+// length Width and Height.
+type Quad struct {
+	NodeName      string
+	P             m.Vec3
+	T, B, N       m.Vec3
+	Width, Height float32
+	MtlID         int32
+}
+
+// Sphere is a sphere with centre P.
+type Sphere struct {
+	NodeName string
+	P        m.Vec3
+	Radius   float32
+	MtlID    int32
+}
+
+// offset returns the offset along the normal n which moves the hit point P off the surface
+// on the side the ray direction D arrived from.
+func offset(P, n, D m.Vec3) m.Vec3 {
+	d := float32(0)
+
+	for k := range P {
+		d += m.Max(m.Abs(P[k]), 0.08) * m.Abs(n[k])
+	}
+
+	o := m.Vec3Scale(m.Gamma(7)*d, n)
+
+	if m.Vec3Dot(D, n) > 0 { // Is it a back face hit?
+		o = m.Vec3Neg(o)
+	}
+
+	return o
+}
+
+// intersectPlane returns the distance along ray to the plane through P with normal N and the
+// hit point projected onto the plane, ok is false if the plane isn't hit in (0,Tclosest).
+func intersectPlane(ray *core.RayData, P, N m.Vec3) (t float32, hit m.Vec3, ok bool) {
+	denom := m.Vec3Dot(ray.Ray.D, N)
+
+	if denom == 0 {
+		return
+	}
+
+	t = m.Vec3Dot(m.Vec3Sub(P, ray.Ray.P), N) / denom
+
+	if !(t > 0 && t < ray.Ray.Tclosest) {
+		return
+	}
+
+	hit = m.Vec3Mad(ray.Ray.P, ray.Ray.D, t)
+
+	// Project back onto the plane to remove the error in t.
+	hit = m.Vec3Mad(hit, N, -m.Vec3Dot(m.Vec3Sub(hit, P), N))
+
+	return t, hit, true
+}
+
+// setHit sets up sg and ray for a hit at P with normal N, tangents dPdu, dPdv and surface
+// parameters u, v.
+func setHit(ray *core.RayData, sg *core.ShaderGlobals, t float32, P, N, dPdu, dPdv m.Vec3, u, v float32) {
+	ray.Ray.Tclosest = t
+	ray.Result.P = P
+	ray.Result.Ng = N
+	ray.Result.Ns = N
+	ray.Result.Bu = u
+	ray.Result.Bv = v
+	ray.Result.ElemID = 0
+	ray.Result.POffset = offset(P, N, ray.Ray.D)
+
+	sg.P = P
+	sg.Poffset = ray.Result.POffset
+	sg.N = N
+	sg.Ns = N
+	sg.Ng = N
+	sg.U = u
+	sg.V = v
+	sg.DdPdu = dPdu
+	sg.DdPdv = dPdv
+}
+
+// Name implements core.Node.
+func (d *Disk) Name() string { return d.NodeName }
+
+// PreRender implements core.Node.
+func (d *Disk) PreRender(rc *core.RenderContext) error { return nil }
+
+// PostRender implements core.Node.
+func (d *Disk) PostRender(rc *core.RenderContext) error { return nil }
+
+// Visible implements core.Primitive.
+func (d *Disk) Visible() bool { return true }
+
+// WorldBounds implements core.Primitive.
+func (d *Disk) WorldBounds() (box m.BoundingBox) {
+	box.Reset()
+
+	for k := 0; k < 3; k++ {
+		e := d.Radius * m.Sqrt(m.Max(0, 1-d.N[k]*d.N[k]))
+		box.GrowDim(k, d.P[k]-e)
+		box.GrowDim(k, d.P[k]+e)
+	}
+
+	return
+}
+
+// intersect returns the hit on the disk, ok is false if the ray misses.
+func (d *Disk) intersect(ray *core.RayData) (t float32, P m.Vec3, x, y float32, ok bool) {
+	t, P, ok = intersectPlane(ray, d.P, d.N)
+
+	if !ok {
+		return
+	}
+
+	V := m.Vec3Sub(P, d.P)
+	x, y = m.Vec3Dot(V, d.T), m.Vec3Dot(V, d.B)
+
+	ok = x*x+y*y <= d.Radius*d.Radius
+
+	return
+}
+
+// TraceRay implements core.Primitive.  The surface parameters are the polar coordinates
+// (angle/2Pi, distance from the centre/Radius).
+func (d *Disk) TraceRay(ray *core.RayData, sg *core.ShaderGlobals) int32 {
+	t, P, x, y, ok := d.intersect(ray)
+
+	if !ok {
+		return -1
+	}
+
+	phi := m.Atan2(y, x)
+
+	if phi < 0 {
+		phi += 2 * m.Pi
+	}
+
+	setHit(ray, sg, t, P, d.N, d.T, d.B, phi/(2*m.Pi), m.Sqrt(x*x+y*y)/d.Radius)
+
+	return d.MtlID
+}
+
+// VisRay implements core.Primitive.
+func (d *Disk) VisRay(ray *core.RayData) {
+	if t, _, _, _, ok := d.intersect(ray); ok {
+		ray.Ray.Tclosest = t
+	}
+}
+
+// Name implements core.Node.
+func (q *Quad) Name() string { return q.NodeName }
+
+// PreRender implements core.Node.
+func (q *Quad) PreRender(rc *core.RenderContext) error { return nil }
+
+// PostRender implements core.Node.
+func (q *Quad) PostRender(rc *core.RenderContext) error { return nil }
+
+// Visible implements core.Primitive.
+func (q *Quad) Visible() bool { return true }
+
+// WorldBounds implements core.Primitive.
+func (q *Quad) WorldBounds() (box m.BoundingBox) {
+	box.Reset()
+
+	U := m.Vec3Scale(q.Width/2, q.T)
+	V := m.Vec3Scale(q.Height/2, q.B)
+
+	box.GrowVec3(m.Vec3Add3(q.P, U, V))
+	box.GrowVec3(m.Vec3Sub(m.Vec3Add(q.P, U), V))
+	box.GrowVec3(m.Vec3Sub(m.Vec3Add(q.P, V), U))
+	box.GrowVec3(m.Vec3Sub(m.Vec3Sub(q.P, U), V))
+
+	return
+}
+
+// intersect returns the hit on the quad, ok is false if the ray misses.
+func (q *Quad) intersect(ray *core.RayData) (t float32, P m.Vec3, x, y float32, ok bool) {
+	t, P, ok = intersectPlane(ray, q.P, q.N)
+
+	if !ok {
+		return
+	}
+
+	V := m.Vec3Sub(P, q.P)
+	x, y = m.Vec3Dot(V, q.T), m.Vec3Dot(V, q.B)
+
+	ok = m.Abs(x) <= q.Width/2 && m.Abs(y) <= q.Height/2
+
+	return
+}
+
+// TraceRay implements core.Primitive.  The surface parameters run from 0 to 1 along the
+// width and height.
+func (q *Quad) TraceRay(ray *core.RayData, sg *core.ShaderGlobals) int32 {
+	t, P, x, y, ok := q.intersect(ray)
+
+	if !ok {
+		return -1
+	}
+
+	setHit(ray, sg, t, P, q.N, m.Vec3Scale(q.Width, q.T), m.Vec3Scale(q.Height, q.B), x/q.Width+0.5, y/q.Height+0.5)
+
+	return q.MtlID
+}
+
+// VisRay implements core.Primitive.
+func (q *Quad) VisRay(ray *core.RayData) {
+	if t, _, _, _, ok := q.intersect(ray); ok {
+		ray.Ray.Tclosest = t
+	}
+}
+
+// Name implements core.Node.
+func (s *Sphere) Name() string { return s.NodeName }
+
+// PreRender implements core.Node.
+func (s *Sphere) PreRender(rc *core.RenderContext) error { return nil }
+
+// PostRender implements core.Node.
+func (s *Sphere) PostRender(rc *core.RenderContext) error { return nil }
+
+// Visible implements core.Primitive.
+func (s *Sphere) Visible() bool { return true }
+
+// WorldBounds implements core.Primitive.
+func (s *Sphere) WorldBounds() (box m.BoundingBox) {
+	box.Reset()
+	box.GrowVec3(m.Vec3Sub(s.P, m.Vec3{s.Radius, s.Radius, s.Radius}))
+	box.GrowVec3(m.Vec3Add(s.P, m.Vec3{s.Radius, s.Radius, s.Radius}))
+
+	return
+}
+
+// intersect returns the distance to the first hit on the sphere, ok is false if the ray
+// misses.
+func (s *Sphere) intersect(ray *core.RayData) (t float32, ok bool) {
+	// Solved in double precision with the stable form of the quadratic.
+	var oc, D [3]float64
+
+	for k := range oc {
+		oc[k] = float64(ray.Ray.P[k] - s.P[k])
+		D[k] = float64(ray.Ray.D[k])
+	}
+
+	a := D[0]*D[0] + D[1]*D[1] + D[2]*D[2]
+	b := oc[0]*D[0] + oc[1]*D[1] + oc[2]*D[2]
+	c := oc[0]*oc[0] + oc[1]*oc[1] + oc[2]*oc[2] - float64(s.Radius)*float64(s.Radius)
+	disc := b*b - a*c
+
+	if disc < 0 || a == 0 {
+		return
+	}
+
+	q := -(b + math.Copysign(math.Sqrt(disc), b))
+	t0, t1 := q/a, c/q
+
+	if q == 0 {
+		t0, t1 = 0, 0
+	}
+
+	if t0 > t1 {
+		t0, t1 = t1, t0
+	}
+
+	tmax := float64(ray.Ray.Tclosest)
+
+	switch {
+	case t0 > 0 && t0 < tmax:
+		return float32(t0), true
+	case t1 > 0 && t1 < tmax:
+		return float32(t1), true
+	}
+
+	return
+}
+
+// TraceRay implements core.Primitive.  The surface parameters are the longitude/2Pi and
+// latitude/Pi about the z axis.
+func (s *Sphere) TraceRay(ray *core.RayData, sg *core.ShaderGlobals) int32 {
+	t, ok := s.intersect(ray)
+
+	if !ok {
+		return -1
+	}
+
+	N := m.Vec3Normalize(m.Vec3Sub(m.Vec3Mad(ray.Ray.P, ray.Ray.D, t), s.P))
+	P := m.Vec3Mad(s.P, N, s.Radius)
+
+	phi := m.Atan2(N[1], N[0])
+
+	if phi < 0 {
+		phi += 2 * m.Pi
+	}
+
+	dPdu := m.Vec3{1, 0, 0} // At a pole
+
+	if N[0] != 0 || N[1] != 0 {
+		dPdu = m.Vec3Normalize(m.Vec3{-N[1], N[0], 0})
+	}
+
+	setHit(ray, sg, t, P, N, dPdu, m.Vec3Cross(N, dPdu), phi/(2*m.Pi), m.Acos(m.Clamp(N[2], -1, 1))/m.Pi)
+
+	return s.MtlID
+}
+
+// VisRay implements core.Primitive.
+func (s *Sphere) VisRay(ray *core.RayData) {
+	if t, ok := s.intersect(ray); ok {
+		ray.Ray.Tclosest = t
+	}
+}
@@ -79,16 +79,20 @@ L2:
 // path is the path that the wfobj was opened.  First try opening file including path
 // then without path.
 func parseMtlLib(rc *core.RenderContext, _path, filename string) error {
-	fin, err := os.Open(path.Join(_path, filename))
+	name := path.Join(_path, filename)
+	fin, err := os.Open(name)
 
 	if err != nil {
-		fin, err = os.Open(filename)
+		name = filename
+		fin, err = os.Open(name)
 		if err != nil {
 			return err
 		}
 	}
 	defer fin.Close()
 
+	rc.AddAsset(name)
+
 	//var mtlid int
 	scanner := bufio.NewScanner(fin)
 	// bytes := make([]byte, DefaultBufferSize)
@@ -225,7 +229,9 @@ func parseMtlLib(rc *core.RenderContext, _path, filename string) error {
 		case "map_Kd":
 
 			mtl.Diffuse = "Lambert"
-			mtl.Kd = &core.TextureMap{lscan.Rest()}
+			filename := lscan.Rest()
+			mtl.Kd = &core.TextureMap{filename}
+			rc.AddAsset(filename)
 			//mtl.BSDF.Diffuse = TextureFile(toks[1])
 		case "map_bump":
 			i := 1
@@ -246,11 +252,13 @@ func parseMtlLib(rc *core.RenderContext, _path, filename string) error {
 				if filename != "" {
 					mtl.BumpMap = &core.TextureMap{filename}
 					mtl.BumpMapScale = scale
+					rc.AddAsset(filename)
 				}
 			} else {
 				if rest != "" {
 					mtl.BumpMap = &core.TextureMap{rest}
 					mtl.BumpMapScale = scale
+					rc.AddAsset(rest)
 				}
 			}
 
@@ -7,8 +7,10 @@ package light
 import (
 	"errors"
 	"github.com/jamiec7919/vermeer/core"
-	"github.com/jamiec7919/vermeer/internal/geom/mesh"
+	"github.com/jamiec7919/vermeer/internal/geom/shape"
+	"github.com/jamiec7919/vermeer/material/edf"
 	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
 	"github.com/jamiec7919/vermeer/nodes"
 )
 
@@ -20,6 +22,8 @@ type Disk struct {
 	Radius        float32
 	Material      string
 	MtlID         int32
+
+	edf edf.Diffuse
 }
 
 // ErrNoSample is returned by sampling function if no sample can be generated.
@@ -44,8 +48,8 @@ func (d *Disk) PreRender(rc *core.RenderContext) error {
 	}
 	d.MtlID = mtlid
 
-	mesh := d.CreateDisk(rc, d.P, d.LookAt, d.Up, d.Radius, mtlid)
-	rc.AddNode(mesh)
+	disk := d.CreateDisk(rc, d.P, d.LookAt, d.Up, d.Radius, mtlid)
+	rc.AddNode(disk)
 	return nil
 }
 
@@ -75,50 +79,110 @@ func (d *Disk) SamplePoint(rnd *rand.Rand, surf *core.SurfacePoint, pdf *float64
 }
 */
 
+// square returns the spherical rectangle of the square around the disk as seen from O.
+func (d *Disk) square(O m.Vec3) sample.SphericalRect {
+	corner := m.Vec3Sub(d.P, m.Vec3Add(m.Vec3Scale(d.Radius, d.B), m.Vec3Scale(d.Radius, d.T)))
+
+	return sample.NewSphericalRect(O, corner, m.Vec3Scale(2*d.Radius, d.B), m.Vec3Scale(2*d.Radius, d.T))
+}
+
 // SampleArea returns a sample on the surface of the light with PDF relative to solid angle
-// as seen from the point in sg.
+// as seen from the point in sg.  Points are sampled uniformly by solid angle over the square
+// around the disk and those outside the disk are rejected, so the PDF is 1/(solid angle of
+// the square).
 func (d *Disk) SampleArea(sg *core.ShaderGlobals) error {
-	r0 := sg.Rand().Float32()
-	r1 := sg.Rand().Float32()
+	if m.Vec3Dot(m.Vec3Sub(sg.P, d.P), d.N) <= 0 {
+		return ErrNoSample
+	}
 
-	u := d.Radius * m.Sqrt(r0) * m.Cos(2*m.Pi*r1)
-	v := d.Radius * m.Sqrt(r0) * m.Sin(2*m.Pi*r1)
+	sr := d.square(sg.P)
+	S := sr.SolidAngle()
 
-	pdf := float64(1.0 / (m.Pi * d.Radius * d.Radius))
+	if S == 0 {
+		return ErrNoSample
+	}
 
-	P := m.Vec3Add3(d.P, m.Vec3Scale(u, d.B), m.Vec3Scale(v, d.T))
+	P := sr.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+
+	if m.Vec3Length2(m.Vec3Sub(P, d.P)) > d.Radius*d.Radius {
+		return ErrNoSample
+	}
 
 	V := m.Vec3Sub(P, sg.P)
 
-	if m.Vec3Dot(V, sg.Ng) > 0.0 && m.Vec3Dot(V, d.N) < 0.0 {
-		sg.Ldist = m.Vec3Length(V)
-		sg.Ld = m.Vec3Normalize(V)
+	sg.Ldist = m.Vec3Length(V)
+	sg.Ld = m.Vec3Scale(1/sg.Ldist, V)
+
+	lightm := core.GetMaterial(d.MtlID)
 
-		lightm := core.GetMaterial(d.MtlID)
+	sg.Liu.Lambda = sg.Lambda
+	omegaO := m.Vec3BasisProject(d.B, d.T, d.N, m.Vec3Neg(sg.Ld))
+	E := lightm.Emission(sg, omegaO)
+	sg.Liu.FromRGB(E[0], E[1], E[2])
 
-		sg.Liu.Lambda = sg.Lambda
-		//lightm.EvalEDF(&P, P.WorldToTangent(m.Vec3Neg(sg.Ld)), &sg.Liu)
-		omegaO := m.Vec3BasisProject(d.B, d.T, d.N, m.Vec3Neg(sg.Ld))
-		ODotN := omegaO[2]
-		E := lightm.Emission(sg, omegaO)
-		sg.Liu.FromRGB(E[0]*ODotN, E[1]*ODotN, E[2]*ODotN)
+	sg.Lpdf = float32(1 / S)
+	sg.Weight = float32(S)
 
-		// geometry term / pdf
-		sg.Weight = m.Abs(m.Vec3Dot(sg.Ld, sg.N)) * m.Abs(m.Vec3Dot(sg.Ld, d.N)) / (sg.Ldist * sg.Ldist)
-		sg.Weight /= float32(pdf)
+	return nil
+}
 
-		return nil
+// PDF returns the solid angle PDF of sampling the point sg.P from sg.Ro, or 0 if it isn't on
+// the front of the disk.
+func (d *Disk) PDF(sg *core.ShaderGlobals) float32 {
+	if m.Vec3Dot(sg.Rd, d.N) >= 0 {
+		return 0
 	}
 
-	return ErrNoSample
+	V := m.Vec3Sub(sg.P, d.P)
 
+	if m.Abs(m.Vec3Dot(V, d.N)) > 1e-3*d.Radius || m.Vec3Length2(V) > d.Radius*d.Radius*1.0001 {
+		return 0
+	}
+
+	sr := d.square(sg.Ro)
+
+	if S := sr.SolidAngle(); S > 0 {
+		return float32(1 / S)
+	}
+
+	return 0
 }
 
-/*
-func (d *Disk) SampleDirection(surf *core.SurfacePoint, rnd *rand.Rand, omegaO *m.Vec3, Le *colour.Spectrum, pdf *float64) error {
+// SampleEmission implements core.Light.  Points are chosen uniformly on the disk and
+// directions with the diffuse EDF.
+func (d *Disk) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	r0 := sg.Rand().Float32()
+	r1 := sg.Rand().Float32()
+
+	u := d.Radius * m.Sqrt(r0) * m.Cos(2*m.Pi*r1)
+	v := d.Radius * m.Sqrt(r0) * m.Sin(2*m.Pi*r1)
+
+	omegaO := d.edf.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+
+	ls.P = m.Vec3Add3(d.P, m.Vec3Scale(u, d.B), m.Vec3Scale(v, d.T))
+	ls.D = m.Vec3BasisExpand(d.B, d.T, d.N, omegaO)
+
+	d.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return ErrNoSample
+	}
+
 	return nil
 }
-*/
+
+// EvalEmission implements core.Light.
+func (d *Disk) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	omegaO := m.Vec3BasisProject(d.B, d.T, d.N, ls.D)
+	E := core.GetMaterial(d.MtlID).Emission(sg, omegaO)
+
+	ls.N = d.N
+	ls.Le.Lambda = sg.Lambda
+	ls.Le.FromRGB(E[0], E[1], E[2])
+	ls.PdfA = 1.0 / (m.Pi * d.Radius * d.Radius)
+	ls.PdfD = d.edf.PDF(omegaO)
+}
+
 /*
 // shade is in space of point to need to project
 func (d *Disk) Sample(shade *core.ShadePoint, rnd *rand.Rand, sample *core.DirectionalSample) error {
@@ -155,46 +219,17 @@ func (d *Disk) Sample(shade *core.ShadePoint, rnd *rand.Rand, sample *core.Direc
 //	return d.P
 //}
 
-// CreateDisk creates the Light and mesh node for a disk light.
-func (d *Disk) CreateDisk(rc *core.RenderContext, P, t, up m.Vec3, radius float32, mtlid int32) *mesh.StaticMesh {
+// CreateDisk creates the geometry node for a disk light.
+func (d *Disk) CreateDisk(rc *core.RenderContext, P, t, up m.Vec3, radius float32, mtlid int32) *shape.Disk {
 	N := m.Vec3Normalize(m.Vec3Sub(t, P))
 	T := m.Vec3Normalize(m.Vec3Cross(N, up))
 	B := m.Vec3Cross(N, T)
 
-	nv := 10
-
-	dv := 2 * m.Pi / float32(nv)
-
-	msh := mesh.Mesh{}
-	ang := float32(0)
-
-	for i := 0; i < nv; i++ {
-		face := mesh.FaceGeom{}
-		face.V[0] = P
-		face.V[2] = m.Vec3Add(P, m.Vec3Add(m.Vec3Scale(radius*m.Cos(ang), B), m.Vec3Scale(radius*m.Sin(ang), T)))
-		face.V[1] = m.Vec3Add(P, m.Vec3Add(m.Vec3Scale(radius*m.Cos(ang+dv), B), m.Vec3Scale(radius*m.Sin(ang+dv), T)))
-		face.MtlID = int32(mtlid)
-		ang += dv
-		//face.setup()
-		//log.Printf("%v", face)
-		msh.Faces = append(msh.Faces, face)
-	}
-
-	//mesh.InitAccel()
-	/*
-		prim := core.Primitive{
-			Mesh:        &mesh,
-			M:           m.MatrixIdentity,
-			Minv:        m.MatrixIdentity,
-			WorldBounds: mesh.WorldBounds(m.MatrixIdentity),
-		}
-	*/
-
 	d.N = N
 	d.T = T
 	d.B = B
-	return &mesh.StaticMesh{"lightmesh", &msh}
 
+	return &shape.Disk{NodeName: d.NodeName + ":geom", P: P, T: T, B: B, N: N, Radius: radius, MtlID: mtlid}
 }
 
 func init() {
@@ -0,0 +1,178 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+	"math"
+)
+
+// Distant represents a distant (directional) light node such as the sun.  Direction is the
+// direction the light travels in.  Intensity (scaled by Colour) is the irradiance on a surface
+// facing the light.  If AngularDiameter (degrees) is greater than 0 the light is a disk of
+// that size in the sky which gives soft shadows, e.g. 0.53 for the sun.  The light isn't
+// visible to rays.
+type Distant struct {
+	NodeName        string `node:"Name"`
+	Direction       m.Vec3
+	AngularDiameter float32
+	Colour          core.RGBParam
+	Intensity       float32
+
+	dir, u, v   m.Vec3 // dir points towards the light
+	cosMax      float64
+	oneMinusCos float64 // Kept separately for precision with small angles
+}
+
+// Name implements core.Node.
+func (l *Distant) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *Distant) PreRender(rc *core.RenderContext) error {
+	if m.Vec3Length2(l.Direction) == 0 {
+		return errors.New("DistantLight: Direction must not be zero")
+	}
+
+	if l.AngularDiameter < 0 || l.AngularDiameter >= 180 {
+		return errors.New("DistantLight: AngularDiameter must be in [0,180)")
+	}
+
+	alpha := float64(l.AngularDiameter) * math.Pi / 360
+
+	l.dir = m.Vec3Neg(m.Vec3Normalize(l.Direction))
+	l.u, l.v = m.Vec3Basis(l.dir)
+	l.cosMax = math.Cos(alpha)
+	l.oneMinusCos = 2 * math.Sin(alpha/2) * math.Sin(alpha/2)
+
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *Distant) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *Distant) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// Delta implements core.DeltaLight.
+func (l *Distant) Delta() bool { return true }
+
+// spectrum returns Colour scaled by scale at the wavelength of sg.
+func (l *Distant) spectrum(sg *core.ShaderGlobals, scale float32) (s colour.Spectrum) {
+	c := l.Colour.RGB(sg)
+
+	s.Lambda = sg.Lambda
+	s.FromRGB(c[0]*scale, c[1]*scale, c[2]*scale)
+
+	return
+}
+
+// radiance returns the radiance of the disk in the sky that gives Intensity irradiance.
+func (l *Distant) radiance() float32 {
+	sin2 := l.oneMinusCos * (1 + l.cosMax)
+
+	return float32(float64(l.Intensity) / (math.Pi * sin2))
+}
+
+// disk returns the centre and radius of the disk facing the light that covers the scene.
+// Light arrives from points on the disk so that paths from the light and from the surfaces
+// agree on where the light vertex is.
+func (l *Distant) disk() (C m.Vec3, R float32) {
+	b := core.SceneBounds()
+	lo, hi := m.Vec3(b.Bounds[0]), m.Vec3(b.Bounds[1])
+
+	R = 0.5*m.Vec3Length(m.Vec3Sub(hi, lo)) + 1e-3
+	C = m.Vec3Mad(m.Vec3Scale(0.5, m.Vec3Add(lo, hi)), l.dir, R)
+
+	return
+}
+
+// SampleArea implements core.Light.  If the light has a size directions are sampled
+// uniformly in the cone it covers.
+func (l *Distant) SampleArea(sg *core.ShaderGlobals) error {
+	C, _ := l.disk()
+
+	if l.AngularDiameter == 0 {
+		sg.Ld = l.dir
+		sg.Liu = l.spectrum(sg, l.Intensity)
+		sg.Lpdf = 1
+		sg.Weight = 1
+	} else {
+		d := sample.UniformCone(1-l.oneMinusCos, sg.Rand().Float64(), sg.Rand().Float64())
+		pdf := 1 / (2 * math.Pi * l.oneMinusCos)
+
+		sg.Ld = m.Vec3BasisExpand(l.u, l.v, l.dir, d)
+		sg.Liu = l.spectrum(sg, l.radiance())
+		sg.Lpdf = float32(pdf)
+		sg.Weight = float32(1 / pdf)
+	}
+
+	// Distance to the plane of the disk.
+	sg.Ldist = m.Max(0, m.Vec3Dot(m.Vec3Sub(C, sg.P), l.dir)/m.Vec3Dot(sg.Ld, l.dir))
+
+	return nil
+}
+
+// PDF implements core.Light.  Rays can't hit the light.
+func (l *Distant) PDF(sg *core.ShaderGlobals) float32 { return 0 }
+
+// SampleEmission implements core.Light.  Rays start uniformly on the disk covering the scene.
+func (l *Distant) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	C, R := l.disk()
+	x, y := sample.UniformDisk2D(R, sg.Rand().Float32(), sg.Rand().Float32())
+
+	ls.P = m.Vec3Add3(C, m.Vec3Scale(x, l.u), m.Vec3Scale(y, l.v))
+
+	if l.AngularDiameter == 0 {
+		ls.D = m.Vec3Neg(l.dir)
+	} else {
+		d := sample.UniformCone(1-l.oneMinusCos, sg.Rand().Float64(), sg.Rand().Float64())
+		ls.D = m.Vec3Neg(m.Vec3BasisExpand(l.u, l.v, l.dir, d))
+	}
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *Distant) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	_, R := l.disk()
+
+	ls.N = m.Vec3Neg(l.dir)
+	ls.PdfA = 1 / (m.Pi * R * R)
+
+	if l.AngularDiameter == 0 {
+		ls.Le = l.spectrum(sg, l.Intensity)
+		ls.PdfD = 1
+		return
+	}
+
+	if -m.Vec3Dot(ls.D, l.dir) >= float32(l.cosMax) {
+		ls.Le = l.spectrum(sg, l.radiance())
+		ls.PdfD = float32(1 / (2 * math.Pi * l.oneMinusCos))
+	} else {
+		ls.Le = l.spectrum(sg, 0)
+		ls.PdfD = 0
+	}
+}
+
+func init() {
+	nodes.Register("DistantLight", func() (core.Node, error) {
+
+		return &Distant{Direction: m.Vec3{0, -1, 0}, Colour: &core.ConstantMap{C: [3]float32{1, 1, 1}}, Intensity: 1}, nil
+
+	})
+}
@@ -0,0 +1,460 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	vimage "github.com/jamiec7919/vermeer/image"
+	_ "github.com/jamiec7919/vermeer/image/exr" // Imported for effect
+	_ "github.com/jamiec7919/vermeer/image/hdr" // Imported for effect
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+	"math"
+)
+
+// Environment represents an image based light node surrounding the scene (a skydome), e.g. an
+// HDR photograph of the sky.  Filename is a lat-long image, or with Mapping "cubemap" a cube
+// map laid out as a horizontal or vertical cross, and is scaled by Colour and Intensity.  With
+// no Filename the environment is constant.  Rotation turns the map by angles in degrees about
+// the X, Y and Z axes in that order.  Camera and Lighting choose whether camera rays see the
+// map and whether it lights the scene.  Directions are sampled in proportion to the brightness
+// of the map.
+type Environment struct {
+	NodeName  string `node:"Name"`
+	Filename  string
+	Mapping   string
+	Rotation  m.Vec3
+	Colour    core.RGBParam
+	Intensity float32
+	Camera    bool
+	Lighting  bool
+
+	w, h       int
+	pix        []float32 // Lat-long RGB image, the top row is +Y and the centre faces -Z
+	ex, ey, ez m.Vec3    // World directions of the map's axes
+	dist       *sample.Distribution2D
+}
+
+// maxDistWidth is the largest number of columns in the sampling distribution, larger maps are
+// averaged over blocks of texels.
+const maxDistWidth = 1024
+
+// Name implements core.Node.
+func (l *Environment) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *Environment) PreRender(rc *core.RenderContext) error {
+	if l.Filename != "" {
+		rc.AddAsset(l.Filename)
+	}
+
+	switch {
+	case l.Filename == "":
+		l.w, l.h, l.pix = 1, 1, []float32{1, 1, 1}
+
+	case l.Mapping == "latlong":
+		w, h, pix, err := load(l.Filename)
+
+		if err != nil {
+			return err
+		}
+
+		l.w, l.h, l.pix = w, h, pix
+
+	case l.Mapping == "cubemap":
+		w, h, pix, err := load(l.Filename)
+
+		if err != nil {
+			return err
+		}
+
+		if err := l.fromCross(w, h, pix); err != nil {
+			return err
+		}
+
+	default:
+		return errors.New("EnvironmentLight: Mapping must be latlong or cubemap")
+	}
+
+	rad := float32(math.Pi / 180)
+	rx := m.Matrix4Rotate(l.Rotation[0]*rad, 1, 0, 0)
+	ry := m.Matrix4Rotate(l.Rotation[1]*rad, 0, 1, 0)
+	rz := m.Matrix4Rotate(l.Rotation[2]*rad, 0, 0, 1)
+
+	rotate := func(v m.Vec3) m.Vec3 {
+		return m.Matrix4MulVec(rz, m.Matrix4MulVec(ry, m.Matrix4MulVec(rx, v)))
+	}
+
+	l.ex, l.ey, l.ez = rotate(m.Vec3{1, 0, 0}), rotate(m.Vec3{0, 1, 0}), rotate(m.Vec3{0, 0, 1})
+
+	l.initDistribution()
+
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *Environment) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *Environment) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// load returns the RGB pixels of the image in filename from the top row down.
+func load(filename string) (w, h int, pix []float32, err error) {
+	r, err := vimage.Open(filename)
+
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	spec, err := r.Spec()
+
+	if err != nil {
+		return
+	}
+
+	nch := spec.NChannels
+
+	if nch == 0 {
+		nch = 3
+	}
+
+	img := make([]float32, spec.Width*spec.Height*nch)
+
+	if err = r.ReadImage(vimage.TypeDesc{BaseType: vimage.FLOAT}, img); err != nil {
+		return
+	}
+
+	w, h = spec.Width, spec.Height
+	pix = make([]float32, w*h*3)
+
+	// Single channel images are replicated to RGB.
+	for i := 0; i < w*h; i++ {
+		for c := 0; c < 3; c++ {
+			if c < nch {
+				pix[i*3+c] = img[i*nch+c]
+			} else {
+				pix[i*3+c] = img[i*nch]
+			}
+		}
+	}
+
+	return
+}
+
+// crossFace is a face of a cube map cross at column col and row row (in faces), with the
+// directions of its centre, right side and top side.
+type crossFace struct {
+	col, row int
+	F, R, U  m.Vec3
+}
+
+// The faces of the horizontal (4:3) and vertical (3:4) crosses.  The middle row of the
+// horizontal cross is a continuous panorama centred on -Z, the vertical cross has +Z upside
+// down below -Y.
+var (
+	horizontalCross = []crossFace{
+		{0, 1, m.Vec3{-1, 0, 0}, m.Vec3{0, 0, -1}, m.Vec3{0, 1, 0}},
+		{1, 1, m.Vec3{0, 0, -1}, m.Vec3{1, 0, 0}, m.Vec3{0, 1, 0}},
+		{2, 1, m.Vec3{1, 0, 0}, m.Vec3{0, 0, 1}, m.Vec3{0, 1, 0}},
+		{3, 1, m.Vec3{0, 0, 1}, m.Vec3{-1, 0, 0}, m.Vec3{0, 1, 0}},
+		{1, 0, m.Vec3{0, 1, 0}, m.Vec3{1, 0, 0}, m.Vec3{0, 0, 1}},
+		{1, 2, m.Vec3{0, -1, 0}, m.Vec3{1, 0, 0}, m.Vec3{0, 0, -1}},
+	}
+
+	verticalCross = []crossFace{
+		{0, 1, m.Vec3{-1, 0, 0}, m.Vec3{0, 0, -1}, m.Vec3{0, 1, 0}},
+		{1, 1, m.Vec3{0, 0, -1}, m.Vec3{1, 0, 0}, m.Vec3{0, 1, 0}},
+		{2, 1, m.Vec3{1, 0, 0}, m.Vec3{0, 0, 1}, m.Vec3{0, 1, 0}},
+		{1, 3, m.Vec3{0, 0, 1}, m.Vec3{1, 0, 0}, m.Vec3{0, -1, 0}},
+		{1, 0, m.Vec3{0, 1, 0}, m.Vec3{1, 0, 0}, m.Vec3{0, 0, 1}},
+		{1, 2, m.Vec3{0, -1, 0}, m.Vec3{1, 0, 0}, m.Vec3{0, 0, -1}},
+	}
+)
+
+// fromCross sets the map to the lat-long resampling of the cube map cross in pix.
+func (l *Environment) fromCross(w, h int, pix []float32) error {
+	var faces []crossFace
+	var size int
+
+	switch {
+	case w*3 == h*4:
+		faces, size = horizontalCross, w/4
+	case w*4 == h*3:
+		faces, size = verticalCross, w/3
+	default:
+		return errors.New("EnvironmentLight: cube map must be a 4:3 or 3:4 cross")
+	}
+
+	l.w, l.h = 4*size, 2*size
+	l.pix = make([]float32, l.w*l.h*3)
+
+	for j := 0; j < l.h; j++ {
+		for i := 0; i < l.w; i++ {
+			d := latlong((float64(i)+0.5)/float64(l.w), (float64(j)+0.5)/float64(l.h))
+
+			f := &faces[0]
+
+			for k := range faces {
+				if m.Vec3Dot(d, faces[k].F) > m.Vec3Dot(d, f.F) {
+					f = &faces[k]
+				}
+			}
+
+			z := m.Vec3Dot(d, f.F)
+			s := m.Vec3Dot(d, f.R) / z
+			t := -m.Vec3Dot(d, f.U) / z
+
+			x := f.col*size + clamp(int((s+1)/2*float32(size)), size)
+			y := f.row*size + clamp(int((t+1)/2*float32(size)), size)
+
+			copy(l.pix[(j*l.w+i)*3:(j*l.w+i)*3+3], pix[(y*w+x)*3:])
+		}
+	}
+
+	return nil
+}
+
+// clamp returns i clamped to [0,n).
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	} else if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// initDistribution sets up the sampling distribution from the luminance of the map weighted
+// by the solid angle of each texel.  Every texel is given at least a thousandth of the mean
+// as bilinear filtering spreads bright texels into their neighbours.
+func (l *Environment) initDistribution() {
+	block := (l.w + maxDistWidth - 1) / maxDistWidth
+	nu, nv := (l.w+block-1)/block, (l.h+block-1)/block
+
+	lum := make([]float32, nu*nv)
+	mean := 0.0
+
+	for j := 0; j < l.h; j++ {
+		for i := 0; i < l.w; i++ {
+			p := l.pix[(j*l.w+i)*3:]
+			Y := m.Max(0, colour.RGB{p[0], p[1], p[2]}.Luminance())
+
+			lum[(j/block)*nu+i/block] += Y
+			mean += float64(Y)
+		}
+	}
+
+	mean /= float64(l.w * l.h)
+
+	f := make([]float32, nu*nv)
+
+	for j := 0; j < nv; j++ {
+		sinTheta := float32(math.Sin(math.Pi * (float64(j) + 0.5) / float64(nv)))
+
+		for i := 0; i < nu; i++ {
+			Y := lum[j*nu+i] / float32(block*block)
+			f[j*nu+i] = m.Max(Y, float32(1e-3*mean)) * sinTheta
+		}
+	}
+
+	l.dist = sample.NewDistribution2D(f, nu, nv)
+}
+
+// latlong returns the direction, in the map's space, of the point u,v of the lat-long map.
+func latlong(u, v float64) m.Vec3 {
+	phi, theta := 2*math.Pi*u, math.Pi*v
+	sinTheta := math.Sin(theta)
+
+	return m.Vec3{float32(-sinTheta * math.Sin(phi)), float32(math.Cos(theta)), float32(sinTheta * math.Cos(phi))}
+}
+
+// direction returns the world direction of the point u,v of the lat-long map.
+func (l *Environment) direction(u, v float64) m.Vec3 {
+	return m.Vec3BasisExpand(l.ex, l.ey, l.ez, latlong(u, v))
+}
+
+// mapCoord returns the point of the lat-long map in the world direction d (unit length).
+func (l *Environment) mapCoord(d m.Vec3) (u, v float64) {
+	d = m.Vec3BasisProject(l.ex, l.ey, l.ez, d)
+
+	phi := math.Atan2(float64(-d[0]), float64(d[2]))
+
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+
+	return phi / (2 * math.Pi), math.Acos(math.Max(-1, math.Min(1, float64(d[1])))) / math.Pi
+}
+
+// texel returns the texel at column i (wrapped around) and row j (clamped) of the map.
+func (l *Environment) texel(i, j int) colour.RGB {
+	i = (i%l.w + l.w) % l.w
+	j = clamp(j, l.h)
+
+	p := l.pix[(j*l.w+i)*3:]
+
+	return colour.RGB{p[0], p[1], p[2]}
+}
+
+// radiance returns the radiance arriving from direction d (unit length, pointing away from
+// the scene) at the wavelength of sg.
+func (l *Environment) radiance(sg *core.ShaderGlobals, d m.Vec3) (L colour.Spectrum) {
+	u, v := l.mapCoord(d)
+
+	// Bilinear filter, wrapping around in u.
+	x, y := u*float64(l.w)-0.5, v*float64(l.h)-0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := float32(x-x0), float32(y-y0)
+	i, j := int(x0), int(y0)
+
+	c00, c10 := l.texel(i, j), l.texel(i+1, j)
+	c01, c11 := l.texel(i, j+1), l.texel(i+1, j+1)
+
+	var rgb colour.RGB
+
+	for k := range rgb {
+		rgb[k] = (1-fy)*((1-fx)*c00[k]+fx*c10[k]) + fy*((1-fx)*c01[k]+fx*c11[k])
+	}
+
+	rgb.Mul(l.Colour.RGB(sg))
+	rgb.Scale(l.Intensity)
+
+	L.Lambda = sg.Lambda
+	L.FromRGB(rgb[0], rgb[1], rgb[2])
+
+	return
+}
+
+// pdf returns the solid angle PDF of sampling the world direction d.
+func (l *Environment) pdf(d m.Vec3) float32 {
+	u, v := l.mapCoord(d)
+	sinTheta := math.Sin(math.Pi * v)
+
+	if sinTheta == 0 {
+		return 0
+	}
+
+	return float32(float64(l.dist.PDF(u, v)) / (2 * math.Pi * math.Pi * sinTheta))
+}
+
+// sample returns a direction chosen in proportion to the map and its solid angle PDF.
+func (l *Environment) sample(sg *core.ShaderGlobals) (d m.Vec3, pdf float32) {
+	u, v, pdfUV := l.dist.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+	sinTheta := math.Sin(math.Pi * v)
+
+	if pdfUV == 0 || sinTheta == 0 {
+		return
+	}
+
+	return l.direction(u, v), float32(float64(pdfUV) / (2 * math.Pi * math.Pi * sinTheta))
+}
+
+// bounds returns the centre and radius of a sphere around the scene.
+func (l *Environment) bounds() (C m.Vec3, R float32) {
+	b := core.SceneBounds()
+	lo, hi := m.Vec3(b.Bounds[0]), m.Vec3(b.Bounds[1])
+
+	R = 0.5*m.Vec3Length(m.Vec3Sub(hi, lo)) + 1e-3
+	C = m.Vec3Scale(0.5, m.Vec3Add(lo, hi))
+
+	return
+}
+
+// Background implements core.BackgroundLight.
+func (l *Environment) Background(sg *core.ShaderGlobals, camera bool) colour.Spectrum {
+	if (camera && !l.Camera) || (!camera && !l.Lighting) {
+		return colour.Spectrum{Lambda: sg.Lambda}
+	}
+
+	return l.radiance(sg, m.Vec3Normalize(sg.Rd))
+}
+
+// SampleArea implements core.Light.
+func (l *Environment) SampleArea(sg *core.ShaderGlobals) error {
+	if !l.Lighting {
+		return core.ErrNoSample
+	}
+
+	d, pdf := l.sample(sg)
+
+	if pdf == 0 {
+		return core.ErrNoSample
+	}
+
+	C, R := l.bounds()
+
+	sg.Ld = d
+	sg.Ldist = m.Vec3Length(m.Vec3Sub(sg.P, C)) + R
+	sg.Liu = l.radiance(sg, d)
+	sg.Lpdf = pdf
+	sg.Weight = 1 / pdf
+
+	return nil
+}
+
+// PDF implements core.Light.  Rays can't hit the light.
+func (l *Environment) PDF(sg *core.ShaderGlobals) float32 { return 0 }
+
+// SampleEmission implements core.Light.  Rays start uniformly on the disk facing the chosen
+// direction that covers the scene.
+func (l *Environment) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	if !l.Lighting {
+		return core.ErrNoSample
+	}
+
+	d, pdf := l.sample(sg)
+
+	if pdf == 0 {
+		return core.ErrNoSample
+	}
+
+	C, R := l.bounds()
+	U, V := m.Vec3Basis(d)
+	x, y := sample.UniformDisk2D(R, sg.Rand().Float32(), sg.Rand().Float32())
+
+	ls.P = m.Vec3Add3(m.Vec3Mad(C, d, R), m.Vec3Scale(x, U), m.Vec3Scale(y, V))
+	ls.D = m.Vec3Neg(d)
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *Environment) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	_, R := l.bounds()
+
+	ls.N = m.Vec3Normalize(ls.D)
+	ls.PdfA = 1 / (m.Pi * R * R)
+
+	if !l.Lighting {
+		ls.Le = colour.Spectrum{Lambda: sg.Lambda}
+		ls.PdfD = 0
+		return
+	}
+
+	d := m.Vec3Neg(ls.N)
+
+	ls.Le = l.radiance(sg, d)
+	ls.PdfD = l.pdf(d)
+}
+
+func init() {
+	nodes.Register("EnvironmentLight", func() (core.Node, error) {
+
+		return &Environment{Mapping: "latlong", Colour: &core.ConstantMap{C: [3]float32{1, 1, 1}}, Intensity: 1, Camera: true, Lighting: true}, nil
+
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+	"math"
+)
+
+// Point represents a point light node.  Intensity (scaled by Colour) is the radiant intensity,
+// the power per unit solid angle, so the irradiance falls off with the square of the distance.
+// If Radius is greater than 0 the light is a sphere of the same power which gives soft
+// shadows.  The light isn't visible to rays.
+type Point struct {
+	NodeName  string `node:"Name"`
+	P         m.Vec3
+	Radius    float32
+	Colour    core.RGBParam
+	Intensity float32
+}
+
+// Name implements core.Node.
+func (l *Point) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *Point) PreRender(rc *core.RenderContext) error {
+	if l.Radius < 0 {
+		return errors.New("PointLight: Radius must not be negative")
+	}
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *Point) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *Point) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// Delta implements core.DeltaLight.
+func (l *Point) Delta() bool { return true }
+
+// spectrum returns Colour scaled by scale at the wavelength of sg.
+func (l *Point) spectrum(sg *core.ShaderGlobals, scale float32) (s colour.Spectrum) {
+	c := l.Colour.RGB(sg)
+
+	s.Lambda = sg.Lambda
+	s.FromRGB(c[0]*scale, c[1]*scale, c[2]*scale)
+
+	return
+}
+
+// SampleArea implements core.Light.  The sphere is sampled uniformly over the cone of
+// directions it covers from sg.P.
+func (l *Point) SampleArea(sg *core.ShaderGlobals) error {
+	V := m.Vec3Sub(l.P, sg.P)
+	dist2 := m.Vec3Length2(V)
+
+	if l.Radius == 0 {
+		if dist2 == 0 {
+			return core.ErrNoSample
+		}
+
+		sg.Ldist = m.Sqrt(dist2)
+		sg.Ld = m.Vec3Scale(1/sg.Ldist, V)
+		sg.Liu = l.spectrum(sg, l.Intensity/dist2)
+		sg.Lpdf = 1
+		sg.Weight = 1
+
+		return nil
+	}
+
+	r2 := l.Radius * l.Radius
+
+	if dist2 <= r2 {
+		return core.ErrNoSample
+	}
+
+	// 1-cosMax is computed as sin^2/(1+cosMax) to keep precision for small or distant spheres.
+	sin2Max := float64(r2) / float64(dist2)
+	cosMax := math.Sqrt(1 - sin2Max)
+	pdf := 1 / (2 * math.Pi * sin2Max / (1 + cosMax))
+
+	dist := m.Sqrt(dist2)
+	W := m.Vec3Scale(1/dist, V)
+	U, Vb := m.Vec3Basis(W)
+
+	d := sample.UniformCone(cosMax, sg.Rand().Float64(), sg.Rand().Float64())
+
+	// Distance to the near side of the sphere.
+	sin2 := m.Max(0, 1-d[2]*d[2])
+
+	sg.Ld = m.Vec3BasisExpand(U, Vb, W, d)
+	sg.Ldist = dist*d[2] - m.Sqrt(m.Max(0, r2-dist2*sin2))
+	sg.Liu = l.spectrum(sg, l.Intensity/(m.Pi*r2))
+	sg.Lpdf = float32(pdf)
+	sg.Weight = float32(1 / pdf)
+
+	return nil
+}
+
+// PDF implements core.Light.  Rays can't hit the light.
+func (l *Point) PDF(sg *core.ShaderGlobals) float32 { return 0 }
+
+// SampleEmission implements core.Light.  Points have uniform directions, spheres uniform
+// points with cosine weighted directions.
+func (l *Point) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	if l.Radius == 0 {
+		ls.P = l.P
+		ls.D = sample.UniformSphere(sg.Rand().Float64(), sg.Rand().Float64())
+	} else {
+		N := sample.UniformSphere(sg.Rand().Float64(), sg.Rand().Float64())
+		U, V := m.Vec3Basis(N)
+
+		ls.P = m.Vec3Mad(l.P, N, l.Radius)
+		ls.D = m.Vec3BasisExpand(U, V, N, sample.CosineHemisphere(sg.Rand().Float64(), sg.Rand().Float64()))
+	}
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *Point) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	if l.Radius == 0 {
+		ls.N = ls.D
+		ls.Le = l.spectrum(sg, l.Intensity)
+		ls.PdfA = 1
+		ls.PdfD = 1 / (4 * m.Pi)
+		return
+	}
+
+	r2 := l.Radius * l.Radius
+
+	ls.N = m.Vec3Normalize(m.Vec3Sub(ls.P, l.P))
+	ls.PdfA = 1 / (4 * m.Pi * r2)
+
+	if cosTheta := m.Vec3Dot(ls.N, ls.D); cosTheta > 0 {
+		ls.Le = l.spectrum(sg, l.Intensity/(m.Pi*r2))
+		ls.PdfD = cosTheta / m.Pi
+	} else {
+		ls.Le = l.spectrum(sg, 0)
+		ls.PdfD = 0
+	}
+}
+
+func init() {
+	nodes.Register("PointLight", func() (core.Node, error) {
+
+		return &Point{Colour: &core.ConstantMap{C: [3]float32{1, 1, 1}}, Intensity: 1}, nil
+
+	})
+}
@@ -0,0 +1,254 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/internal/geom/shape"
+	"github.com/jamiec7919/vermeer/material/edf"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+)
+
+// Quad represents a rectangular area light node facing from P towards LookAt.  The emission
+// comes from Material and may be narrowed by Spread (1 is diffuse, smaller values restrict
+// the light to a cone of Spread*90 degrees around the normal) and BarnDoors, the depth of
+// four flaps along the edges that block light leaving at grazing angles.  Points are sampled
+// uniformly by solid angle.
+type Quad struct {
+	NodeName      string `node:"Name"`
+	P, Up, LookAt m.Vec3
+	Width, Height float32
+	Material      string
+	Spread        float32
+	BarnDoors     float32
+
+	T, B, N   m.Vec3
+	mtl       core.Material
+	cosSpread float32
+	edf       edf.Diffuse
+}
+
+// emitter wraps the material of the quad so that rays hitting the light see the same
+// emission as light samples, including the spread and barn doors.
+type emitter struct {
+	core.Material
+	name  string
+	id    int32
+	light *Quad
+}
+
+// Name implements core.Material.
+func (e *emitter) Name() string { return e.name }
+
+// SetID implements core.Material.
+func (e *emitter) SetID(id int32) { e.id = id }
+
+// ID implements core.Material.
+func (e *emitter) ID() int32 { return e.id }
+
+// PreRender implements core.Node.
+func (e *emitter) PreRender(rc *core.RenderContext) error { return nil }
+
+// PostRender implements core.Node.
+func (e *emitter) PostRender(rc *core.RenderContext) error { return nil }
+
+// Emission implements core.Material.
+func (e *emitter) Emission(sg *core.ShaderGlobals, omegaO m.Vec3) colour.RGB {
+	E := e.Material.Emission(sg, omegaO)
+	s := e.light.directional(sg.P, m.Vec3Neg(m.Vec3Normalize(sg.Rd)))
+
+	return colour.RGB{E[0] * s, E[1] * s, E[2] * s}
+}
+
+// Name implements core.Node.
+func (l *Quad) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *Quad) PreRender(rc *core.RenderContext) error {
+	mtlid := rc.GetMaterialID(l.Material)
+
+	if mtlid == -1 {
+		return errors.New("QuadLight: can't find material " + l.Material)
+	}
+
+	if l.Width <= 0 || l.Height <= 0 {
+		return errors.New("QuadLight: Width and Height must be greater than 0")
+	}
+
+	if l.Spread <= 0 || l.Spread > 1 {
+		return errors.New("QuadLight: Spread must be in (0,1]")
+	}
+
+	l.N = m.Vec3Normalize(m.Vec3Sub(l.LookAt, l.P))
+
+	if T := m.Vec3Cross(l.N, l.Up); m.Vec3Length2(T) > 0 {
+		l.T = m.Vec3Normalize(T)
+	} else {
+		l.T, _ = m.Vec3Basis(l.N) // Up is parallel to the normal
+	}
+
+	l.B = m.Vec3Cross(l.N, l.T)
+	l.mtl = rc.GetMaterial(mtlid)
+	l.cosSpread = m.Cos(l.Spread * m.Pi / 2)
+
+	e := &emitter{Material: l.mtl, name: l.NodeName + ":material", light: l}
+	rc.AddNode(e)
+
+	rc.AddNode(&shape.Quad{NodeName: l.NodeName + ":geom", P: l.P, T: l.T, B: l.B, N: l.N, Width: l.Width, Height: l.Height, MtlID: e.ID()})
+
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *Quad) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *Quad) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// directional returns the scale of the light leaving P in direction D from the spread and
+// barn doors.
+func (l *Quad) directional(P, D m.Vec3) float32 {
+	cosTheta := m.Vec3Dot(D, l.N)
+
+	if cosTheta <= 0 {
+		return 0
+	}
+
+	if l.BarnDoors > 0 {
+		// Where the ray leaves the box formed by the flaps.
+		V := m.Vec3Mad(m.Vec3Sub(P, l.P), D, l.BarnDoors/cosTheta)
+
+		if m.Abs(m.Vec3Dot(V, l.T)) > l.Width/2 || m.Abs(m.Vec3Dot(V, l.B)) > l.Height/2 {
+			return 0
+		}
+	}
+
+	if l.Spread < 1 {
+		t := (cosTheta - l.cosSpread) / (1 - l.cosSpread)
+
+		if t <= 0 {
+			return 0
+		}
+
+		return t * t * (3 - 2*t)
+	}
+
+	return 1
+}
+
+// rect returns the spherical rectangle of the quad as seen from O.
+func (l *Quad) rect(O m.Vec3) sample.SphericalRect {
+	U := m.Vec3Scale(l.Width, l.T)
+	V := m.Vec3Scale(l.Height, l.B)
+	corner := m.Vec3Sub(l.P, m.Vec3Scale(0.5, m.Vec3Add(U, V)))
+
+	return sample.NewSphericalRect(O, corner, U, V)
+}
+
+// SampleArea implements core.Light.  Points are sampled uniformly by the solid angle of the
+// quad as seen from sg.P.
+func (l *Quad) SampleArea(sg *core.ShaderGlobals) error {
+	if m.Vec3Dot(m.Vec3Sub(sg.P, l.P), l.N) <= 0 {
+		return core.ErrNoSample
+	}
+
+	sr := l.rect(sg.P)
+	S := sr.SolidAngle()
+
+	if S == 0 {
+		return core.ErrNoSample
+	}
+
+	P := sr.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+	V := m.Vec3Sub(P, sg.P)
+
+	sg.Ldist = m.Vec3Length(V)
+	sg.Ld = m.Vec3Scale(1/sg.Ldist, V)
+
+	s := l.directional(P, m.Vec3Neg(sg.Ld))
+
+	if s == 0 {
+		return core.ErrNoSample
+	}
+
+	omegaO := m.Vec3BasisProject(l.T, l.B, l.N, m.Vec3Neg(sg.Ld))
+	E := l.mtl.Emission(sg, omegaO)
+
+	sg.Liu.Lambda = sg.Lambda
+	sg.Liu.FromRGB(E[0]*s, E[1]*s, E[2]*s)
+	sg.Lpdf = float32(1 / S)
+	sg.Weight = float32(S)
+
+	return nil
+}
+
+// PDF implements core.Light.
+func (l *Quad) PDF(sg *core.ShaderGlobals) float32 {
+	if m.Vec3Dot(sg.Rd, l.N) >= 0 {
+		return 0
+	}
+
+	V := m.Vec3Sub(sg.P, l.P)
+
+	if m.Abs(m.Vec3Dot(V, l.N)) > 1e-3*(l.Width+l.Height) ||
+		m.Abs(m.Vec3Dot(V, l.T)) > l.Width*0.50001 || m.Abs(m.Vec3Dot(V, l.B)) > l.Height*0.50001 {
+		return 0
+	}
+
+	sr := l.rect(sg.Ro)
+
+	if S := sr.SolidAngle(); S > 0 {
+		return float32(1 / S)
+	}
+
+	return 0
+}
+
+// SampleEmission implements core.Light.  Points are chosen uniformly on the quad and
+// directions with the diffuse EDF.
+func (l *Quad) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	u := (sg.Rand().Float32() - 0.5) * l.Width
+	v := (sg.Rand().Float32() - 0.5) * l.Height
+
+	omegaO := l.edf.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+
+	ls.P = m.Vec3Add3(l.P, m.Vec3Scale(u, l.T), m.Vec3Scale(v, l.B))
+	ls.D = m.Vec3BasisExpand(l.T, l.B, l.N, omegaO)
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *Quad) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	omegaO := m.Vec3BasisProject(l.T, l.B, l.N, ls.D)
+	E := l.mtl.Emission(sg, omegaO)
+	s := l.directional(ls.P, ls.D)
+
+	ls.N = l.N
+	ls.Le.Lambda = sg.Lambda
+	ls.Le.FromRGB(E[0]*s, E[1]*s, E[2]*s)
+	ls.PdfA = 1 / (l.Width * l.Height)
+	ls.PdfD = l.edf.PDF(omegaO)
+}
+
+func init() {
+	nodes.Register("QuadLight", func() (core.Node, error) {
+
+		return &Quad{Width: 1, Height: 1, Up: m.Vec3{0, 1, 0}, Spread: 1}, nil
+
+	})
+}
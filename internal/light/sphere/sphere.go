@@ -0,0 +1,170 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/internal/geom/shape"
+	"github.com/jamiec7919/vermeer/material/edf"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+	"math"
+)
+
+// Sphere represents a spherical area light node with centre P, the emission comes from
+// Material.  Points are sampled uniformly over the cone of directions the sphere covers.
+type Sphere struct {
+	NodeName string `node:"Name"`
+	P        m.Vec3
+	Radius   float32
+	Material string
+
+	mtl core.Material
+	edf edf.Diffuse
+}
+
+// Name implements core.Node.
+func (l *Sphere) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *Sphere) PreRender(rc *core.RenderContext) error {
+	mtlid := rc.GetMaterialID(l.Material)
+
+	if mtlid == -1 {
+		return errors.New("SphereLight: can't find material " + l.Material)
+	}
+
+	if l.Radius <= 0 {
+		return errors.New("SphereLight: Radius must be greater than 0")
+	}
+
+	l.mtl = rc.GetMaterial(mtlid)
+
+	rc.AddNode(&shape.Sphere{NodeName: l.NodeName + ":geom", P: l.P, Radius: l.Radius, MtlID: mtlid})
+
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *Sphere) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *Sphere) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// cone returns cos of the half angle of the cone that the sphere covers from a point dist2
+// squared distance from the centre and the solid angle PDF of sampling it uniformly.
+func (l *Sphere) cone(dist2 float32) (cosMax, pdf float64) {
+	// 1-cosMax is computed as sin^2/(1+cosMax) to keep precision for small or distant spheres.
+	sin2Max := float64(l.Radius) * float64(l.Radius) / float64(dist2)
+	cosMax = math.Sqrt(1 - sin2Max)
+	pdf = 1 / (2 * math.Pi * sin2Max / (1 + cosMax))
+
+	return
+}
+
+// SampleArea implements core.Light.  No samples are generated for points inside the sphere.
+func (l *Sphere) SampleArea(sg *core.ShaderGlobals) error {
+	V := m.Vec3Sub(l.P, sg.P)
+	dist2 := m.Vec3Length2(V)
+	r2 := l.Radius * l.Radius
+
+	if dist2 <= r2 {
+		return core.ErrNoSample
+	}
+
+	cosMax, pdf := l.cone(dist2)
+
+	dist := m.Sqrt(dist2)
+	W := m.Vec3Scale(1/dist, V)
+	U, Vb := m.Vec3Basis(W)
+
+	d := sample.UniformCone(cosMax, sg.Rand().Float64(), sg.Rand().Float64())
+
+	// Distance to the near side of the sphere.
+	sin2 := m.Max(0, 1-d[2]*d[2])
+
+	sg.Ld = m.Vec3BasisExpand(U, Vb, W, d)
+	sg.Ldist = dist*d[2] - m.Sqrt(m.Max(0, r2-dist2*sin2))
+
+	P := m.Vec3Mad(sg.P, sg.Ld, sg.Ldist)
+	N := m.Vec3Normalize(m.Vec3Sub(P, l.P))
+	T, B := m.Vec3Basis(N)
+
+	omegaO := m.Vec3BasisProject(T, B, N, m.Vec3Neg(sg.Ld))
+	E := l.mtl.Emission(sg, omegaO)
+
+	sg.Liu.Lambda = sg.Lambda
+	sg.Liu.FromRGB(E[0], E[1], E[2])
+	sg.Lpdf = float32(pdf)
+	sg.Weight = float32(1 / pdf)
+
+	return nil
+}
+
+// PDF implements core.Light.
+func (l *Sphere) PDF(sg *core.ShaderGlobals) float32 {
+	N := m.Vec3Sub(sg.P, l.P)
+
+	if m.Abs(m.Vec3Length(N)-l.Radius) > 1e-3*l.Radius || m.Vec3Dot(sg.Rd, N) >= 0 {
+		return 0
+	}
+
+	dist2 := m.Vec3Length2(m.Vec3Sub(l.P, sg.Ro))
+
+	if dist2 <= l.Radius*l.Radius {
+		return 0
+	}
+
+	_, pdf := l.cone(dist2)
+
+	return float32(pdf)
+}
+
+// SampleEmission implements core.Light.  Points are chosen uniformly on the sphere and
+// directions with the diffuse EDF.
+func (l *Sphere) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	N := sample.UniformSphere(sg.Rand().Float64(), sg.Rand().Float64())
+	T, B := m.Vec3Basis(N)
+
+	omegaO := l.edf.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+
+	ls.P = m.Vec3Mad(l.P, N, l.Radius)
+	ls.D = m.Vec3BasisExpand(T, B, N, omegaO)
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *Sphere) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	N := m.Vec3Normalize(m.Vec3Sub(ls.P, l.P))
+	T, B := m.Vec3Basis(N)
+
+	omegaO := m.Vec3BasisProject(T, B, N, ls.D)
+	E := l.mtl.Emission(sg, omegaO)
+
+	ls.N = N
+	ls.Le.Lambda = sg.Lambda
+	ls.Le.FromRGB(E[0], E[1], E[2])
+	ls.PdfA = 1 / (4 * m.Pi * l.Radius * l.Radius)
+	ls.PdfD = l.edf.PDF(omegaO)
+}
+
+func init() {
+	nodes.Register("SphereLight", func() (core.Node, error) {
+
+		return &Sphere{Radius: 1}, nil
+
+	})
+}
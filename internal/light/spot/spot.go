@@ -0,0 +1,169 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+	"math"
+)
+
+// Spot represents a spot light node, a point light at P shining towards LookAt.  ConeAngle is
+// the full angle of the cone in degrees, the light fades to black over the Penumbra degrees
+// inside the edge of the cone and is scaled by cos^Falloff of the angle from the axis.
+// Intensity (scaled by Colour) is the radiant intensity along the axis.  The light isn't
+// visible to rays.
+type Spot struct {
+	NodeName  string `node:"Name"`
+	P, LookAt m.Vec3
+	ConeAngle float32
+	Penumbra  float32
+	Falloff   float32
+	Colour    core.RGBParam
+	Intensity float32
+
+	axis, u, v         m.Vec3
+	cosOuter, cosInner float32
+	oneMinusCosOuter   float64 // Kept separately for precision with narrow cones
+}
+
+// Name implements core.Node.
+func (l *Spot) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *Spot) PreRender(rc *core.RenderContext) error {
+	axis := m.Vec3Sub(l.LookAt, l.P)
+
+	if m.Vec3Length2(axis) == 0 {
+		return errors.New("SpotLight: LookAt must be different from P")
+	}
+
+	if l.ConeAngle <= 0 || l.ConeAngle > 180 {
+		return errors.New("SpotLight: ConeAngle must be in (0,180]")
+	}
+
+	outer := float64(l.ConeAngle) * math.Pi / 360
+	inner := math.Max(0, outer-float64(l.Penumbra)*math.Pi/180)
+
+	l.axis = m.Vec3Normalize(axis)
+	l.u, l.v = m.Vec3Basis(l.axis)
+	l.cosOuter = float32(math.Cos(outer))
+	l.cosInner = float32(math.Cos(inner))
+	l.oneMinusCosOuter = 2 * math.Sin(outer/2) * math.Sin(outer/2)
+
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *Spot) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *Spot) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// Delta implements core.DeltaLight.
+func (l *Spot) Delta() bool { return true }
+
+// attenuation returns the scale of the intensity in a direction at cosTheta to the axis.
+func (l *Spot) attenuation(cosTheta float32) float32 {
+	if cosTheta <= l.cosOuter {
+		return 0
+	}
+
+	a := float32(1)
+
+	if cosTheta < l.cosInner {
+		t := (cosTheta - l.cosOuter) / (l.cosInner - l.cosOuter)
+		a = t * t * (3 - 2*t)
+	}
+
+	if l.Falloff != 0 {
+		a *= m.Pow(cosTheta, l.Falloff)
+	}
+
+	return a
+}
+
+// spectrum returns Colour scaled by scale at the wavelength of sg.
+func (l *Spot) spectrum(sg *core.ShaderGlobals, scale float32) (s colour.Spectrum) {
+	c := l.Colour.RGB(sg)
+
+	s.Lambda = sg.Lambda
+	s.FromRGB(c[0]*scale, c[1]*scale, c[2]*scale)
+
+	return
+}
+
+// SampleArea implements core.Light.
+func (l *Spot) SampleArea(sg *core.ShaderGlobals) error {
+	V := m.Vec3Sub(l.P, sg.P)
+	dist2 := m.Vec3Length2(V)
+
+	if dist2 == 0 {
+		return core.ErrNoSample
+	}
+
+	dist := m.Sqrt(dist2)
+	Ld := m.Vec3Scale(1/dist, V)
+	a := l.attenuation(-m.Vec3Dot(Ld, l.axis))
+
+	if a == 0 {
+		return core.ErrNoSample
+	}
+
+	sg.Ld = Ld
+	sg.Ldist = dist
+	sg.Liu = l.spectrum(sg, l.Intensity*a/dist2)
+	sg.Lpdf = 1
+	sg.Weight = 1
+
+	return nil
+}
+
+// PDF implements core.Light.  Rays can't hit the light.
+func (l *Spot) PDF(sg *core.ShaderGlobals) float32 { return 0 }
+
+// SampleEmission implements core.Light.  Directions are chosen uniformly in the cone.
+func (l *Spot) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	d := sample.UniformCone(1-l.oneMinusCosOuter, sg.Rand().Float64(), sg.Rand().Float64())
+
+	ls.P = l.P
+	ls.D = m.Vec3BasisExpand(l.u, l.v, l.axis, d)
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *Spot) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	cosTheta := m.Vec3Dot(ls.D, l.axis)
+
+	ls.N = ls.D
+	ls.Le = l.spectrum(sg, l.Intensity*l.attenuation(cosTheta))
+	ls.PdfA = 1
+	ls.PdfD = 0
+
+	if cosTheta > l.cosOuter {
+		ls.PdfD = float32(1 / (2 * math.Pi * l.oneMinusCosOuter))
+	}
+}
+
+func init() {
+	nodes.Register("SpotLight", func() (core.Node, error) {
+
+		return &Spot{ConeAngle: 45, Colour: &core.ConstantMap{C: [3]float32{1, 1, 1}}, Intensity: 1}, nil
+
+	})
+}
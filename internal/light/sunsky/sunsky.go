@@ -0,0 +1,460 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package light
+
+import (
+	"errors"
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	m "github.com/jamiec7919/vermeer/math"
+	"github.com/jamiec7919/vermeer/math/sample"
+	"github.com/jamiec7919/vermeer/nodes"
+	"math"
+	"time"
+)
+
+// SunSky represents the clear daylight sky of Preetham, Shirley and Smits (1999, A Practical
+// Analytic Model for Daylight) together with the disk of the sun.  The sky is the CIE daylight
+// spectrum of the luminance and chromaticity given by the model and the sun the solar spectrum
+// after Rayleigh and aerosol extinction, both are in thousands of cd/m^2 scaled by Intensity.
+//
+// The sun is at Elevation and Azimuth (degrees, clockwise from North), or if Date is given at
+// the position seen from Latitude, Longitude (degrees, north and east positive) at Time (hours
+// of local standard time in TimeZone, hours east of UTC).  Y is up.  With the sun below the
+// horizon the sky is black.  Camera and Lighting choose whether camera rays see the sky and
+// whether it lights the scene.  Sun turns the disk of the sun on or off.
+type SunSky struct {
+	NodeName  string `node:"Name"`
+	Turbidity float32
+	Elevation float32
+	Azimuth   float32
+	Latitude  float32
+	Longitude float32
+	TimeZone  float32
+	Date      string
+	Time      float32
+	North     m.Vec3
+	Intensity float32
+	Sun       bool
+	Camera    bool
+	Lighting  bool
+
+	up, north, east m.Vec3
+	sunDir          m.Vec3 // Towards the sun
+	sunU, sunV      m.Vec3
+	night           bool
+
+	perez  [3][5]float64 // Distribution coefficients A-E of Y, x and y
+	zenith [3]float64    // Y, x and y at the zenith divided by the distribution there
+
+	sun  [len(solarSpectrum)]float32 // Radiance of the sun at each wavelength of solarSpectrum
+	pSun float32                     // Probability of sampling the sun rather than the sky
+	dist *sample.Distribution2D      // Sky luminance over the hemisphere
+	sunY float64                     // Luminance of the sun
+}
+
+// Angular radius of the sun.
+const sunRadius = 0.2667 * math.Pi / 180
+
+var sunOneMinusCos = 2 * math.Sin(sunRadius/2) * math.Sin(sunRadius/2)
+
+// Illuminance from the sun outside the atmosphere in thousands of lux.
+const solarIlluminance = 128
+
+// Extraterrestrial solar spectral radiance from 380 to 750nm in 10nm steps (Preetham et al.
+// 1999), only the shape is used.
+var solarSpectrum = [...]float32{
+	165.5, 162.3, 211.2, 258.8, 258.2, 242.3, 267.6, 296.6, 305.4, 300.6, 306.6, 288.3, 287.1,
+	278.2, 271.0, 272.3, 263.6, 255.0, 250.6, 253.1, 253.5, 251.3, 246.3, 241.7, 236.8, 232.1,
+	228.2, 223.4, 219.7, 215.3, 211.0, 207.3, 202.4, 198.7, 194.3, 190.7, 186.3, 182.6,
+}
+
+const (
+	solarLambdaMin  = 380
+	solarLambdaStep = 10
+)
+
+// Sky grid the sampling distribution is built on.
+const (
+	distU = 256 // Azimuth
+	distV = 64  // Zenith angle, horizon to zenith
+)
+
+// Name implements core.Node.
+func (l *SunSky) Name() string { return l.NodeName }
+
+// PreRender implements core.Node.
+func (l *SunSky) PreRender(rc *core.RenderContext) error {
+	if l.Turbidity < 2 || l.Turbidity > 10 {
+		return errors.New("SunSky: Turbidity must be in [2,10]")
+	}
+
+	l.up = m.Vec3{0, 1, 0}
+	l.north = m.Vec3Sub(l.North, m.Vec3Scale(m.Vec3Dot(l.North, l.up), l.up))
+
+	if m.Vec3Length2(l.north) == 0 {
+		return errors.New("SunSky: North must not be vertical or zero")
+	}
+
+	l.north = m.Vec3Normalize(l.north)
+	l.east = m.Vec3Cross(l.north, l.up)
+
+	elevation, azimuth := float64(l.Elevation)*math.Pi/180, float64(l.Azimuth)*math.Pi/180
+
+	if l.Date != "" {
+		date, err := time.Parse("2006-01-02", l.Date)
+
+		if err != nil {
+			return errors.New("SunSky: Date must be YYYY-MM-DD")
+		}
+
+		elevation, azimuth = sunPosition(float64(l.Latitude), float64(l.Longitude), float64(l.TimeZone), date.YearDay(), float64(l.Time))
+	}
+
+	l.sunDir = l.direction(math.Pi/2-elevation, azimuth)
+	l.sunU, l.sunV = m.Vec3Basis(l.sunDir)
+	l.night = elevation < 0
+
+	if l.night {
+		return nil
+	}
+
+	l.initSky(math.Pi/2 - elevation)
+	l.initSun(math.Pi/2 - elevation)
+	l.initDistribution()
+
+	return nil
+}
+
+// PostRender implements core.Node.
+func (l *SunSky) PostRender(rc *core.RenderContext) error { return nil }
+
+// DiffuseShadeMult implements core.Light.
+func (l *SunSky) DiffuseShadeMult() float32 {
+	return 1.0
+}
+
+// sunPosition returns the elevation and azimuth (clockwise from north) of the sun seen from
+// latitude, longitude (degrees) on day of the year at time (hours of standard time in
+// timeZone).  From Preetham et al. (1999) appendix A.6.
+func sunPosition(latitude, longitude, timeZone float64, day int, t float64) (elevation, azimuth float64) {
+	J := float64(day)
+
+	// Solar time.
+	t += 0.170*math.Sin(4*math.Pi*(J-80)/373) - 0.129*math.Sin(2*math.Pi*(J-8)/355) + (longitude-15*timeZone)/15
+
+	decl := 0.4093 * math.Sin(2*math.Pi*(J-81)/368)
+	lat := latitude * math.Pi / 180
+	hour := math.Pi * (t - 12) / 12
+
+	elevation = math.Asin(math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(hour))
+	azimuth = math.Atan2(-math.Cos(decl)*math.Sin(hour), math.Cos(lat)*math.Sin(decl)-math.Sin(lat)*math.Cos(decl)*math.Cos(hour))
+
+	return
+}
+
+// direction returns the world direction at zenith angle theta and azimuth phi (clockwise
+// from north).
+func (l *SunSky) direction(theta, phi float64) m.Vec3 {
+	sinTheta, cosTheta := math.Sincos(theta)
+	sinPhi, cosPhi := math.Sincos(phi)
+
+	d := m.Vec3Scale(float32(sinTheta*cosPhi), l.north)
+	d = m.Vec3Mad(d, l.east, float32(sinTheta*sinPhi))
+
+	return m.Vec3Mad(d, l.up, float32(cosTheta))
+}
+
+// initSky sets up the sky for the sun at zenith angle thetaS.
+func (l *SunSky) initSky(thetaS float64) {
+	T := float64(l.Turbidity)
+
+	l.perez = [3][5]float64{
+		{0.1787*T - 1.4630, -0.3554*T + 0.4275, -0.0227*T + 5.3251, 0.1206*T - 2.5771, -0.0670*T + 0.3703},
+		{-0.0193*T - 0.2592, -0.0665*T + 0.0008, -0.0004*T + 0.2125, -0.0641*T - 0.8989, -0.0033*T + 0.0452},
+		{-0.0167*T - 0.2608, -0.0950*T + 0.0092, -0.0079*T + 0.2102, -0.0441*T - 1.6537, -0.0109*T + 0.0529},
+	}
+
+	chi := (4.0/9 - T/120) * (math.Pi - 2*thetaS)
+	Yz := math.Max(0, (4.0453*T-4.9710)*math.Tan(chi)-0.2155*T+2.4192)
+
+	T2, t2, t3 := T*T, thetaS*thetaS, thetaS*thetaS*thetaS
+
+	xz := (0.00166*t3-0.00375*t2+0.00209*thetaS)*T2 +
+		(-0.02903*t3+0.06377*t2-0.03202*thetaS+0.00394)*T +
+		(0.11693*t3 - 0.21196*t2 + 0.06052*thetaS + 0.25886)
+
+	yz := (0.00275*t3-0.00610*t2+0.00317*thetaS)*T2 +
+		(-0.04214*t3+0.08970*t2-0.04153*thetaS+0.00516)*T +
+		(0.15346*t3 - 0.26756*t2 + 0.06670*thetaS + 0.26688)
+
+	for k, z := range [3]float64{Yz, xz, yz} {
+		l.zenith[k] = z / l.distribution(k, 1, thetaS)
+	}
+}
+
+// distribution returns the Perez et al. function for component k (Y, x or y) in the direction
+// with cosine cosTheta to the zenith and angle gamma to the sun.
+func (l *SunSky) distribution(k int, cosTheta, gamma float64) float64 {
+	c := &l.perez[k]
+	cosGamma := math.Cos(gamma)
+
+	return (1 + c[0]*math.Exp(c[1]/cosTheta)) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+}
+
+// sky returns the luminance and chromaticity of the sky in direction d (unit length).
+func (l *SunSky) sky(d m.Vec3) (Y, x, y float64) {
+	cosTheta := float64(m.Vec3Dot(d, l.up))
+
+	if cosTheta <= 0 {
+		return
+	}
+
+	gamma := math.Acos(math.Max(-1, math.Min(1, float64(m.Vec3Dot(d, l.sunDir)))))
+
+	Y = l.zenith[0] * l.distribution(0, cosTheta, gamma)
+	x = l.zenith[1] * l.distribution(1, cosTheta, gamma)
+	y = l.zenith[2] * l.distribution(2, cosTheta, gamma)
+
+	return
+}
+
+// initSun sets up the radiance of the sun at zenith angle thetaS after extinction by the
+// atmosphere (Preetham et al. 1999 appendix A.5, without absorption by gases).
+func (l *SunSky) initSun(thetaS float64) {
+	// Relative optical mass (Kasten 1966).
+	mass := 1 / (math.Cos(thetaS) + 0.15*math.Pow(93.885-thetaS*180/math.Pi, -1.253))
+	beta := 0.04608*float64(l.Turbidity) - 0.04586
+
+	solar := func(lambda float32) float32 {
+		return colour.Tabulated(solarSpectrum[:], solarLambdaMin, solarLambdaStep, lambda)
+	}
+
+	// Scaled to the luminance of the sun outside the atmosphere.
+	scale := solarIlluminance / (2 * math.Pi * sunOneMinusCos) / float64(colour.SpectralLuminance(solar))
+
+	for i, S := range solarSpectrum {
+		lambda := float64(solarLambdaMin+i*solarLambdaStep) / 1000 // micrometres
+
+		tauR := math.Exp(-0.008735 * math.Pow(lambda, -4.08) * mass)
+		tauA := math.Exp(-beta * math.Pow(lambda, -1.3) * mass)
+
+		l.sun[i] = float32(scale * float64(S) * tauR * tauA)
+	}
+
+	l.sunY = float64(colour.SpectralLuminance(func(lambda float32) float32 {
+		return colour.Tabulated(l.sun[:], solarLambdaMin, solarLambdaStep, lambda)
+	}))
+}
+
+// initDistribution sets up the sampling distribution from the sky luminance weighted by the
+// solid angle of each cell of the grid and the probability of sampling the sun from the
+// share of the light it gives.
+func (l *SunSky) initDistribution() {
+	f := make([]float32, distU*distV)
+	power := 0.0
+
+	for j := 0; j < distV; j++ {
+		theta := math.Pi / 2 * (float64(j) + 0.5) / distV
+		sinTheta := math.Sin(theta)
+
+		for i := 0; i < distU; i++ {
+			Y, _, _ := l.sky(l.direction(theta, 2*math.Pi*(float64(i)+0.5)/distU))
+
+			f[j*distU+i] = float32(Y * sinTheta)
+			power += Y * sinTheta
+		}
+	}
+
+	power *= math.Pi * math.Pi / (distU * distV)
+
+	l.dist = sample.NewDistribution2D(f, distU, distV)
+	l.pSun = 0
+
+	if l.Sun {
+		sun := l.sunY * 2 * math.Pi * sunOneMinusCos
+		l.pSun = float32(math.Min(0.9, math.Max(0.1, sun/(sun+power))))
+	}
+}
+
+// mapCoord returns the point of the sky grid in the world direction d (unit length, above the
+// horizon).
+func (l *SunSky) mapCoord(d m.Vec3) (u, v float64) {
+	phi := math.Atan2(float64(m.Vec3Dot(d, l.east)), float64(m.Vec3Dot(d, l.north)))
+
+	if phi < 0 {
+		phi += 2 * math.Pi
+	}
+
+	theta := math.Acos(math.Max(-1, math.Min(1, float64(m.Vec3Dot(d, l.up)))))
+
+	return phi / (2 * math.Pi), theta / (math.Pi / 2)
+}
+
+// inSun returns true if the world direction d (unit length) is in the disk of the sun.
+func (l *SunSky) inSun(d m.Vec3) bool {
+	return l.Sun && float64(m.Vec3Dot(d, l.sunDir)) >= 1-sunOneMinusCos
+}
+
+// radiance returns the radiance arriving from direction d (unit length, pointing away from
+// the scene) at the wavelength of sg.
+func (l *SunSky) radiance(sg *core.ShaderGlobals, d m.Vec3) (L colour.Spectrum) {
+	L.Lambda = sg.Lambda
+
+	if l.night {
+		return
+	}
+
+	Y, x, y := l.sky(d)
+
+	if Y > 0 {
+		L.FromDaylight(float32(x), float32(y), float32(Y))
+	}
+
+	if l.inSun(d) {
+		for k := range L.C {
+			L.C[k] += colour.Tabulated(l.sun[:], solarLambdaMin, solarLambdaStep, L.Wavelength(k))
+		}
+	}
+
+	L.Scale(l.Intensity)
+
+	return
+}
+
+// pdf returns the solid angle PDF of sampling the world direction d.
+func (l *SunSky) pdf(d m.Vec3) float32 {
+	pdf := float32(0)
+
+	if l.inSun(d) {
+		pdf = l.pSun / float32(2*math.Pi*sunOneMinusCos)
+	}
+
+	if m.Vec3Dot(d, l.up) > 0 {
+		u, v := l.mapCoord(d)
+
+		if sinTheta := math.Sin(math.Pi / 2 * v); sinTheta > 0 {
+			pdf += (1 - l.pSun) * float32(float64(l.dist.PDF(u, v))/(math.Pi*math.Pi*sinTheta))
+		}
+	}
+
+	return pdf
+}
+
+// sample returns a direction chosen from the sun or in proportion to the sky and its solid
+// angle PDF.
+func (l *SunSky) sample(sg *core.ShaderGlobals) (d m.Vec3, pdf float32) {
+	if sg.Rand().Float32() < l.pSun {
+		c := sample.UniformCone(1-sunOneMinusCos, sg.Rand().Float64(), sg.Rand().Float64())
+		d = m.Vec3BasisExpand(l.sunU, l.sunV, l.sunDir, c)
+	} else {
+		u, v, _ := l.dist.Sample(sg.Rand().Float64(), sg.Rand().Float64())
+		d = l.direction(math.Pi/2*v, 2*math.Pi*u)
+	}
+
+	return d, l.pdf(d)
+}
+
+// bounds returns the centre and radius of a sphere around the scene.
+func (l *SunSky) bounds() (C m.Vec3, R float32) {
+	b := core.SceneBounds()
+	lo, hi := m.Vec3(b.Bounds[0]), m.Vec3(b.Bounds[1])
+
+	R = 0.5*m.Vec3Length(m.Vec3Sub(hi, lo)) + 1e-3
+	C = m.Vec3Scale(0.5, m.Vec3Add(lo, hi))
+
+	return
+}
+
+// Background implements core.BackgroundLight.
+func (l *SunSky) Background(sg *core.ShaderGlobals, camera bool) colour.Spectrum {
+	if (camera && !l.Camera) || (!camera && !l.Lighting) {
+		return colour.Spectrum{Lambda: sg.Lambda}
+	}
+
+	return l.radiance(sg, m.Vec3Normalize(sg.Rd))
+}
+
+// SampleArea implements core.Light.
+func (l *SunSky) SampleArea(sg *core.ShaderGlobals) error {
+	if !l.Lighting || l.night {
+		return core.ErrNoSample
+	}
+
+	d, pdf := l.sample(sg)
+
+	if pdf == 0 {
+		return core.ErrNoSample
+	}
+
+	C, R := l.bounds()
+
+	sg.Ld = d
+	sg.Ldist = m.Vec3Length(m.Vec3Sub(sg.P, C)) + R
+	sg.Liu = l.radiance(sg, d)
+	sg.Lpdf = pdf
+	sg.Weight = 1 / pdf
+
+	return nil
+}
+
+// PDF implements core.Light.  Rays can't hit the light.
+func (l *SunSky) PDF(sg *core.ShaderGlobals) float32 { return 0 }
+
+// SampleEmission implements core.Light.  Rays start uniformly on the disk facing the chosen
+// direction that covers the scene.
+func (l *SunSky) SampleEmission(sg *core.ShaderGlobals, ls *core.LightSample) error {
+	if !l.Lighting || l.night {
+		return core.ErrNoSample
+	}
+
+	d, pdf := l.sample(sg)
+
+	if pdf == 0 {
+		return core.ErrNoSample
+	}
+
+	C, R := l.bounds()
+	U, V := m.Vec3Basis(d)
+	x, y := sample.UniformDisk2D(R, sg.Rand().Float32(), sg.Rand().Float32())
+
+	ls.P = m.Vec3Add3(m.Vec3Mad(C, d, R), m.Vec3Scale(x, U), m.Vec3Scale(y, V))
+	ls.D = m.Vec3Neg(d)
+
+	l.EvalEmission(sg, ls)
+
+	if ls.PdfD == 0 {
+		return core.ErrNoSample
+	}
+
+	return nil
+}
+
+// EvalEmission implements core.Light.
+func (l *SunSky) EvalEmission(sg *core.ShaderGlobals, ls *core.LightSample) {
+	_, R := l.bounds()
+
+	ls.N = m.Vec3Normalize(ls.D)
+	ls.PdfA = 1 / (m.Pi * R * R)
+
+	if !l.Lighting || l.night {
+		ls.Le = colour.Spectrum{Lambda: sg.Lambda}
+		ls.PdfD = 0
+		return
+	}
+
+	d := m.Vec3Neg(ls.N)
+
+	ls.Le = l.radiance(sg, d)
+	ls.PdfD = l.pdf(d)
+}
+
+func init() {
+	nodes.Register("SunSky", func() (core.Node, error) {
+
+		return &SunSky{Turbidity: 3, Elevation: 45, Azimuth: 135, Time: 12, North: m.Vec3{0, 0, -1}, Intensity: 1, Sun: true, Camera: true, Lighting: true}, nil
+
+	})
+}
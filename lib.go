@@ -9,11 +9,20 @@ package main
 */
 
 import (
+	_ "github.com/jamiec7919/vermeer/image/exr"
 	_ "github.com/jamiec7919/vermeer/image/hdr"
+	_ "github.com/jamiec7919/vermeer/image/ldr"
 	_ "github.com/jamiec7919/vermeer/internal/camera"
 	_ "github.com/jamiec7919/vermeer/internal/driver"
 	_ "github.com/jamiec7919/vermeer/internal/geom/instance"
 	_ "github.com/jamiec7919/vermeer/internal/geom/polymesh"
 	_ "github.com/jamiec7919/vermeer/internal/geom/wfobj"
 	_ "github.com/jamiec7919/vermeer/internal/light/disk"
+	_ "github.com/jamiec7919/vermeer/internal/light/distant"
+	_ "github.com/jamiec7919/vermeer/internal/light/env"
+	_ "github.com/jamiec7919/vermeer/internal/light/point"
+	_ "github.com/jamiec7919/vermeer/internal/light/quad"
+	_ "github.com/jamiec7919/vermeer/internal/light/sphere"
+	_ "github.com/jamiec7919/vermeer/internal/light/spot"
+	_ "github.com/jamiec7919/vermeer/internal/light/sunsky"
 )
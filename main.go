@@ -7,35 +7,75 @@ The vermeer command.
 
 Execute as:
 
-	vermeer [-maxiter=n] [-cpuprofile=filename.prof] <file.vnf>
+	vermeer [-maxiter=n] [-time=seconds] [-spp=n] [-noise=error] [-threads=n] [-headless] [-resume] [-cpuprofile=filename.prof] <file.vnf>
+
+If no display is available (or -headless is given) the render runs without a preview
+window and the command exits once the render is complete, which needs a limit.  -time,
+-spp and -noise override the TimeLimit, MaxSamples and AdaptiveThreshold of the Globals node.
+
+By default one render goroutine runs per CPU, -threads (or MaxGoRoutines in Globals) sets the
+number and negative values leave that many CPUs free.
+A non-zero exit code is returned if any stage of the render fails.
+
+On SIGINT or SIGTERM the render finishes the current iteration and the outputs are written
+as if it had completed.  A second signal exits immediately.
+
+If the Globals node names a Checkpoint file the render state is saved to it periodically and
+-resume continues the render from it, -maxiter then counts the iterations of the original
+render too.  The checkpoint is rejected if the scene file or the files it reads have changed or
+the Sampler, Seed or MaxSamples differ (including through -spp).
 */
 package main
 
 import (
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/jamiec7919/vermeer/core"
 	"github.com/jamiec7919/vermeer/nodes"
 	"github.com/jamiec7919/vermeer/preview"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"syscall"
 )
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 var maxiter = flag.Int("maxiter", -1, "Maximum iterations")
 var stats = flag.Bool("stats", false, "stats will be appended to file")
 var statsfile = flag.String("statsfile", "stats.txt", "file to append stats to")
+var headless = flag.Bool("headless", false, "render without a preview window")
+var resume = flag.Bool("resume", false, "continue the render from the checkpoint file")
+var timelimit = flag.Float64("time", 0, "render time limit in seconds")
+var spp = flag.Int("spp", 0, "samples per pixel")
+var noise = flag.Float64("noise", 0, "adaptive sampling noise threshold (relative standard error)")
+var threads = flag.Int("threads", 0, "render goroutines, 0 for one per CPU or negative to leave CPUs free")
+
+// errNoIterLimit is returned when a headless render would never terminate.
+var errNoIterLimit = errors.New("headless render requires -maxiter, -time, -spp, -noise or a limit in Globals")
+
+// interrupted is closed when a signal has asked the render to finish.
+var interrupted = make(chan struct{})
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes the command and returns the exit code. Split from main so that deferred
+// calls are run before exiting.
+func run() int {
 	flag.Parse()
 
 	if *cpuprofile != "" {
 		log.Printf("CPU profile: %v", *cpuprofile)
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
-			log.Fatal(err)
+			log.Print(err)
+			return 1
 		}
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
@@ -49,62 +89,177 @@ func main() {
 
 	rc := core.NewRenderContext()
 
+	handleSignals(rc)
+
+	if *headless || !hasDisplay() {
+		return runHeadless(rc, filename)
+	}
+
 	pview, err := preview.Init()
 
 	if err != nil {
-		log.Printf("Error: preview: %v", err)
-		os.Exit(1)
+		log.Printf("Warning: preview: %v, rendering headless", err)
+		return runHeadless(rc, filename)
 	}
 
 	rc.StartPreview(pview)
 
-	renderstatus := make(chan error)
+	renderstatus := make(chan error, 1)
 
 	go func() {
 		defer pview.Close()
 
-		if err := nodes.Parse(rc, filename); err != nil {
-			log.Printf("Error: LoadNodeFile: %v", err)
-			renderstatus <- err
-			return
-		}
-		if err := rc.PreRender(); err != nil {
-			log.Printf("Error: PreRender: %v", err)
-			renderstatus <- err
-			return
-		}
+		renderstatus <- render(rc, filename, false)
+	}()
+
+	pview.Run() // This blocks until window is closed
+
+	rc.Finish() // If render is still going we finish it
 
-		if raystats, err := rc.Render(*maxiter); err == nil {
+	if err := <-renderstatus; err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+// runHeadless renders filename without a preview window, returning the exit code.
+func runHeadless(rc *core.RenderContext, filename string) int {
+	if err := render(rc, filename, true); err != nil {
+		return 1
+	}
 
-			if *stats {
-				f, err := os.OpenFile(*statsfile, os.O_APPEND|os.O_WRONLY, 0600)
-				if err != nil {
-					panic(err)
-				}
+	return 0
+}
+
+// handleSignals finishes the render on SIGINT or SIGTERM so the outputs are still written,
+// a second signal exits straight away.
+func handleSignals(rc *core.RenderContext) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
-				defer f.Close()
+	go func() {
+		sig := <-sigs
+		log.Printf("%v: finishing the current iteration, signal again to exit immediately", sig)
+		close(interrupted)
+		rc.Finish()
 
-				if _, err := fmt.Fprintln(f, filename, raystats, runtime.Version()); err != nil {
-					panic(err)
-				}
-			}
-		} else {
-			log.Printf("Error: Render: %v", err)
-			renderstatus <- err
-			return
+		<-sigs
+		os.Exit(1)
+	}()
+}
+
+// applyFlags overrides the Globals with any settings given on the command line.
+func applyFlags(g *core.Globals) {
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "threads" {
+			g.MaxGoRoutines = *threads
 		}
+	})
+
+	if *timelimit > 0 {
+		g.TimeLimit = float32(*timelimit)
+	}
+
+	if *spp > 0 {
+		g.MaxSamples = *spp
+	}
+
+	if *noise > 0 {
+		g.AdaptiveThreshold = float32(*noise)
+	}
+}
 
-		if err := rc.PostRender(); err != nil {
-			log.Printf("Error: PostRender: %v", err)
-			renderstatus <- err
-			return
+// hasDisplay returns false if the platform is known to have no display to open
+// a preview window on.
+func hasDisplay() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return true
+	}
+
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// render loads filename and performs all stages of the render.  Errors are logged and returned.
+// Headless renders must have some limit as nothing else will end them.
+func render(rc *core.RenderContext, filename string, headless bool) error {
+	if err := setSceneHash(rc, filename); err != nil {
+		log.Printf("Error: LoadNodeFile: %v", err)
+		return err
+	}
+
+	if *resume {
+		rc.Resume()
+	}
+
+	if err := nodes.Parse(rc, filename); err != nil {
+		log.Printf("Error: LoadNodeFile: %v", err)
+		return err
+	}
+
+	applyFlags(rc.Globals())
+
+	if err := rc.PreRender(); err != nil {
+		log.Printf("Error: PreRender: %v", err)
+		return err
+	}
+
+	if headless && *maxiter < 0 && !rc.Globals().Limited() {
+		log.Printf("Error: %v", errNoIterLimit)
+		return errNoIterLimit
+	}
+
+	raystats, err := rc.Render(*maxiter)
+
+	if err != nil {
+		log.Printf("Error: Render: %v", err)
+		return err
+	}
+
+	if *stats {
+		if err := appendStats(filename, raystats); err != nil {
+			log.Printf("Error: stats: %v", err)
+			return err
 		}
-		renderstatus <- nil
-	}()
+	}
 
-	pview.Run() // This blocks until window is closed
+	if err := rc.PostRender(); err != nil {
+		log.Printf("Error: PostRender: %v", err)
+		return err
+	}
 
-	rc.Finish() // If render is still going we finish it
+	select {
+	case <-interrupted:
+		log.Printf("Render interrupted after %v iterations (%.1f samples per pixel), outputs written", raystats.Iterations, raystats.MeanSamples)
+	default:
+	}
+
+	return nil
+}
+
+// setSceneHash sets the hash of the scene file, which identifies the scene in checkpoints
+// along with the assets the nodes record and the sampler settings after applyFlags.
+func setSceneHash(rc *core.RenderContext, filename string) error {
+	b, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	h := sha256.Sum256(b)
+	rc.SetSceneHash(h[:])
+	return nil
+}
+
+func appendStats(filename string, raystats core.Stats) error {
+	f, err := os.OpenFile(*statsfile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
 
-	<-renderstatus
+	_, err = fmt.Fprintln(f, filename, &raystats, runtime.Version())
+	return err
 }
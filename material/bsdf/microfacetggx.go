@@ -109,8 +109,9 @@ func (b *MicrofacetGGX) PDF(omegaI m.Vec3) float64 {
 
 	omegaM = m.Vec3Scale(sign(b.OmegaR[2]), m.Vec3Normalize(m.Vec3Add(b.OmegaR, omegaI)))
 
+	// Half vector PDF times the Jacobian of the reflection mapping.
 	//log.Printf("D: %v", ggxD(omegaM, alpha))
-	return float64(ggxD(omegaM, alpha) * omegaM[2])
+	return float64(ggxD(omegaM, alpha) * omegaM[2] / (4 * m.Vec3DotAbs(omegaI, omegaM)))
 }
 
 // Eval implements core.BSDF.
@@ -56,7 +56,14 @@ func (b *MicrofacetTransmissionGGX) Sample(r0, r1 float64) (omegaO m.Vec3) {
 
 	c := m.Vec3Dot(b.OmegaR, omegaM)
 
-	a := eta*c - sign*m.Sqrt(1.0+eta*(c*c-1.0))
+	k := 1.0 + eta*eta*(c*c-1.0)
+
+	if k < 0 {
+		// Total internal reflection
+		return m.Vec3{}
+	}
+
+	a := eta*c - sign*m.Sqrt(k)
 
 	omegaO = m.Vec3Sub(m.Vec3Scale(a, omegaM), m.Vec3Scale(eta, b.OmegaR))
 
@@ -81,11 +88,27 @@ func (b *MicrofacetTransmissionGGX) PDF(omegaO m.Vec3) float64 {
 
 	h := m.Vec3Normalize(m.Vec3Neg(m.Vec3Add(m.Vec3Scale(etaI, b.OmegaR), m.Vec3Scale(etaO, omegaO))))
 
-	return float64(ggxD(h, alpha) * h[2])
+	if h[2] < 0 {
+		h = m.Vec3Neg(h)
+	}
+
+	// Half vector PDF times the Jacobian of the refraction mapping.
+	denom := sqr32(etaI*m.Vec3Dot(b.OmegaR, h) + etaO*m.Vec3Dot(omegaO, h))
+
+	return float64(ggxD(h, alpha) * h[2] * sqr32(etaO) * m.Vec3DotAbs(omegaO, h) / denom)
 }
 
 // Eval implements core.BSDF.
 func (b *MicrofacetTransmissionGGX) Eval(omegaO m.Vec3) (rho colour.Spectrum) {
+	if b.thin {
+		// Straight through so treated as perfect specular (see SpecularTransmission)
+		fresnel := b.fresnel.Kr(m.Abs(b.OmegaR[2]))
+
+		rho.Lambda = b.Lambda
+		rho.FromRGB(1-fresnel[0], 1-fresnel[1], 1-fresnel[2])
+		return
+	}
+
 	alpha := sqr32(b.Roughness)
 
 	etaI := float32(1)
@@ -97,7 +120,11 @@ func (b *MicrofacetTransmissionGGX) Eval(omegaO m.Vec3) (rho colour.Spectrum) {
 
 	h := m.Vec3Normalize(m.Vec3Neg(m.Vec3Add(m.Vec3Scale(etaI, b.OmegaR), m.Vec3Scale(etaO, omegaO))))
 
-	factor1 := (m.Vec3DotAbs(b.OmegaR, h) * m.Vec3DotAbs(omegaO, h)) / (b.OmegaR[2] * omegaO[2])
+	if h[2] < 0 {
+		h = m.Vec3Neg(h)
+	}
+
+	factor1 := (m.Vec3DotAbs(b.OmegaR, h) * m.Vec3DotAbs(omegaO, h)) / m.Abs(b.OmegaR[2]*omegaO[2])
 
 	fresnel := b.fresnel.Kr(m.Vec3DotAbs(b.OmegaR, h))
 
@@ -30,7 +30,14 @@ func refract(omegaR m.Vec3, ior float32) (omegaO m.Vec3) {
 
 	c := m.Vec3Dot(omegaR, m.Vec3{0, 0, 1})
 
-	a := eta*c - sign*m.Sqrt(1.0+eta*(c*c-1.0))
+	k := 1.0 + eta*eta*(c*c-1.0)
+
+	if k < 0 {
+		// Total internal reflection
+		return m.Vec3{}
+	}
+
+	a := eta*c - sign*m.Sqrt(k)
 
 	omegaO = m.Vec3Sub(m.Vec3Scale(a, m.Vec3{0, 0, 1}), m.Vec3Scale(eta, omegaR))
 
@@ -81,12 +88,12 @@ func (b *Specular2) PDF(omegaO m.Vec3) float64 {
 	return 1
 }
 
-// Eval implements core.BSDF.
+// Eval implements core.BSDF.  As the BSDF is a delta distribution this returns the weight
+// for the sampled direction (i.e. the cosine cancels).
 func (b *Specular2) Eval(omegaO m.Vec3) (rho colour.Spectrum) {
 	fresnel := b.fresnel.Kr(b.OmegaR[2])
 
 	rho.Lambda = b.Lambda
 	rho.FromRGB(fresnel[0], fresnel[1], fresnel[2])
-	rho.Scale(m.Vec3DotAbs(omegaO, m.Vec3{0, 0, 1}))
 	return
 }
@@ -45,7 +45,8 @@ func (b *SpecularTransmission) PDF(omegaO m.Vec3) float64 {
 	return 1
 }
 
-// Eval implements core.BSDF.
+// Eval implements core.BSDF.  As the BSDF is a delta distribution this returns the weight
+// for the sampled direction (i.e. the cosine cancels).
 func (b *SpecularTransmission) Eval(omegaO m.Vec3) (rho colour.Spectrum) {
 	//	fresnel := DielectricFresnel(b.OmegaR, m.Vec3{0, 0, 1}, b.ior)
 
@@ -59,6 +60,5 @@ func (b *SpecularTransmission) Eval(omegaO m.Vec3) (rho colour.Spectrum) {
 
 	rho.Lambda = b.Lambda
 	rho.FromRGB(1-fresnel[0], 1-fresnel[1], 1-fresnel[2])
-	rho.Scale(m.Vec3DotAbs(omegaO, m.Vec3{0, 0, 1}))
 	return
 }
@@ -0,0 +1,132 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package material
+
+import (
+	"github.com/jamiec7919/vermeer/colour"
+	"github.com/jamiec7919/vermeer/core"
+	"github.com/jamiec7919/vermeer/material/bsdf"
+	fr "github.com/jamiec7919/vermeer/material/fresnel"
+	m "github.com/jamiec7919/vermeer/math"
+)
+
+// Closure implements core.Material.  Sets up the diffuse, specular and transmission lobes for
+// the surface point in sg with the same parameters as Eval.
+func (mtl *Material) Closure(sg *core.ShaderGlobals, c *core.Closure) {
+	if mtl.HasBumpMap() {
+		mtl.ApplyBumpMap(sg)
+	} else {
+		sg.N = m.Vec3Normalize(sg.N)
+	}
+
+	transWeight := float32(0)
+
+	if mtl.TransStrength != nil {
+		transWeight = mtl.TransStrength.Float32(sg)
+	}
+
+	ior := float32(1.7)
+
+	if mtl.IOR != nil {
+		ior = mtl.IOR.Float32(sg)
+	}
+
+	// The closure is set up with the view direction above the surface, hitting the back of a
+	// transmissive surface means light is leaving the medium.
+	if m.Vec3Dot(sg.Rd, sg.Ng) > 0 {
+		sg.N = m.Vec3Neg(sg.N)
+		sg.Ng = m.Vec3Neg(sg.Ng)
+		sg.Ns = m.Vec3Neg(sg.Ns)
+
+		if transWeight > 0 && !mtl.TransThin {
+			ior = 1 / ior
+		}
+	}
+
+	// Also keep the shading normal on the viewer's side.
+	if m.Vec3Dot(sg.Rd, sg.N) > 0 {
+		sg.N = sg.Ng
+	}
+
+	roughness := float32(0.5)
+	if mtl.Roughness != nil {
+		roughness = mtl.Roughness.Float32(sg)
+	}
+
+	specRoughness := float32(0.5)
+	if mtl.SpecularRoughness != nil {
+		specRoughness = mtl.SpecularRoughness.Float32(sg)
+	}
+
+	var fresnel core.Fresnel
+
+	switch mtl.spec1FresnelModel {
+	case FresnelDielectric:
+		fresnel = fr.NewDielectric(ior)
+	case FresnelMetal:
+		refl := colour.RGB{0.5, 0.5, 0.5}
+		edge := colour.RGB{0.5, 0.5, 0.5}
+
+		if mtl.Spec1FresnelRefl != nil {
+			refl = mtl.Spec1FresnelRefl.RGB(sg)
+		}
+
+		if mtl.Spec1FresnelEdge != nil {
+			edge = mtl.Spec1FresnelEdge.RGB(sg)
+		}
+
+		fresnel = fr.NewConductor(0, refl, edge)
+	}
+
+	diffWeight := float32(0.5)
+	specWeight := float32(0.5)
+
+	if mtl.DiffuseStrength != nil {
+		diffWeight = mtl.DiffuseStrength.Float32(sg)
+	}
+
+	if mtl.SpecularStrength != nil {
+		specWeight = mtl.SpecularStrength.Float32(sg)
+	}
+
+	// Normalize the weights
+	if l := m.Sqrt(diffWeight*diffWeight + specWeight*specWeight); l != 0.0 {
+		diffWeight /= l
+		specWeight /= l
+	}
+
+	if diffWeight > 0 && mtl.Kd != nil {
+		Kd := mtl.Kd.RGB(sg)
+		Kd.Scale(diffWeight)
+
+		c.Add(bsdf.NewOrenNayar(sg, roughness), Kd, core.ScatterDiffuse)
+	}
+
+	if mtl.Ks != nil {
+		Ks := mtl.Ks.RGB(sg)
+		Ks.Scale(specWeight)
+
+		if specRoughness == 0.0 {
+			c.Add(bsdf.NewSpecular(sg, fresnel, transWeight, mtl.TransThin), Ks, core.ScatterSpecular)
+		} else {
+			c.Add(bsdf.NewMicrofacetGGX(sg, fresnel, specRoughness, transWeight > 0, mtl.TransThin), Ks, core.ScatterGlossy)
+		}
+	}
+
+	if transWeight > 0 && mtl.Kt != nil {
+		Kt := mtl.Kt.RGB(sg)
+		Kt.Scale(specWeight)
+
+		switch {
+		case specRoughness == 0.0:
+			c.Add(bsdf.NewSpecularTransmission(sg, ior, fresnel, mtl.TransThin), Kt, core.ScatterTransmission|core.ScatterSpecular)
+		case mtl.TransThin:
+			// Thin rough transmission passes straight through
+			c.Add(bsdf.NewMicrofacetTransmissionGGX(sg, ior, specRoughness, fresnel, true), Kt, core.ScatterTransmission|core.ScatterSpecular)
+		default:
+			c.Add(bsdf.NewMicrofacetTransmissionGGX(sg, ior, specRoughness, fresnel, false), Kt, core.ScatterTransmission|core.ScatterGlossy)
+		}
+	}
+}
@@ -9,31 +9,48 @@ This package is in heavy development so documentation somewhat sketchy. */
 package material
 
 import (
+	"fmt"
 	"github.com/jamiec7919/vermeer/colour"
 	"github.com/jamiec7919/vermeer/core"
 	m "github.com/jamiec7919/vermeer/math"
 	"github.com/jamiec7919/vermeer/nodes"
 )
 
-// Debug is the default surface shader.
+// Debug is the default surface shader.  It shows the constant Colour or, if Mode is set, one
+// of the surface debug modes of the core.DebugIntegrator (e.g. "n" or "wireframe") so models can
+// be checked per material.
 type Debug struct {
 	MtlName string `node:"Name"`
 	id      int32  // This should only be assinged by RenderContext
 
 	Sides  int           // One or two sided
 	Colour core.RGBParam // Colour parameter
+	Mode   string        // Debug mode name, "" for Colour
+
+	mode core.DebugMode
 }
 
 // Assert that Debug satisfies important interfaces.
 var _ core.Node = (*Debug)(nil)
 var _ core.Material = (*Debug)(nil)
+var _ core.FlatShader = (*Debug)(nil)
 
 // Name is a core.Node method.
 func (mtl *Debug) Name() string { return mtl.MtlName }
 
 // PreRender is a core.Node method.
-func (mtl *Debug) PreRender(rc *core.RenderContext) error {
-	return nil
+func (mtl *Debug) PreRender(rc *core.RenderContext) (err error) {
+	if mtl.Mode == "" {
+		return nil
+	}
+
+	mtl.mode, err = core.ParseDebugMode(mtl.Mode)
+
+	if err == nil && (mtl.mode == core.DebugCost || mtl.mode == core.DebugTime) {
+		err = fmt.Errorf("MaterialDebug: mode %v is only supported by DebugIntegrator", mtl.Mode)
+	}
+
+	return
 }
 
 // PostRender is a core.Node method.
@@ -55,17 +72,34 @@ func (mtl *Debug) SetID(id int32) {
 // Eval implements core.Material.  Performs all shading for the surface point in sg.  May trace
 // rays and shadow rays.
 func (mtl *Debug) Eval(sg *core.ShaderGlobals) {
-	sg.OutRGB = mtl.Colour.RGB(sg)
+	sg.OutRGB = mtl.colour(sg)
 }
 
 // HasBumpMap implements core.Material.
 func (mtl *Debug) HasBumpMap() bool { return false }
 
+// HasEDF implements core.Material.  The colour is treated as emission so that debug surfaces
+// appear flat shaded.
+func (mtl *Debug) HasEDF() bool { return true }
+
+// FlatShaded implements core.FlatShader, debug surfaces aren't sampled as lights.
+func (mtl *Debug) FlatShaded() {}
+
 // Emission returns the RGB emission for the given direction.
 func (mtl *Debug) Emission(sg *core.ShaderGlobals, omegaO m.Vec3) colour.RGB {
-	return colour.RGB{}
+	return mtl.colour(sg)
+}
+
+func (mtl *Debug) colour(sg *core.ShaderGlobals) colour.RGB {
+	if mtl.Mode == "" {
+		return mtl.Colour.RGB(sg)
+	}
+	return core.DebugColour(mtl.mode, sg)
 }
 
+// Closure implements core.Material.  Debug surfaces don't scatter light.
+func (mtl *Debug) Closure(sg *core.ShaderGlobals, c *core.Closure) {}
+
 func init() {
 	nodes.Register("MaterialDebug", func() (core.Node, error) {
 
@@ -4,31 +4,32 @@
 
 /*
 Package edf provides the built-in EDFs (Emission Distribution Functions) for Vermeer.
+
+EDFs describe the directional distribution of light leaving an emitter, they are used by
+lights to sample rays leaving their surface.  As with BSDFs directions are in the tangent
+space of the emitting point with the normal along z.
 */
 package edf
 
 import (
-	"github.com/jamiec7919/vermeer/colour"
-	"github.com/jamiec7919/vermeer/core"
 	m "github.com/jamiec7919/vermeer/math"
-	"math/rand"
+	"github.com/jamiec7919/vermeer/math/sample"
 )
 
-// Diffuse implements basic diffuse distribution (cosine weighted).
-type Diffuse struct {
-	E [3]float32
-}
+// Diffuse implements basic diffuse distribution (cosine weighted), the emitted radiance is
+// the same in all directions above the surface.
+type Diffuse struct{}
 
-// Eval evaluates the emission in the given direction.
-func (b *Diffuse) Eval(surf *core.SurfacePoint, omegaO m.Vec3, Le *colour.Spectrum) error {
-	d := omegaO[2]
-	Le.FromRGB(b.E[0]*d, b.E[1]*d, b.E[2]*d)
-	return nil
+// Sample returns a direction given two (quasi)random numbers.
+func (b *Diffuse) Sample(r0, r1 float64) m.Vec3 {
+	return sample.CosineHemisphere(r0, r1)
 }
 
-// Sample samples the emission distribution.
-//
-// Deprecated: unused.
-func (b *Diffuse) Sample(surf *core.SurfacePoint, rnd *rand.Rand, omegaO *m.Vec3, Le *colour.Spectrum, pdf *float64) error {
-	return nil
+// PDF returns the solid angle probability density of sampling omegaO.
+func (b *Diffuse) PDF(omegaO m.Vec3) float32 {
+	if omegaO[2] <= 0 {
+		return 0
+	}
+
+	return omegaO[2] / m.Pi
 }
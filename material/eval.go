@@ -131,7 +131,7 @@ func (mtl *Material) Eval(sg *core.ShaderGlobals) {
 
 		for sg.LightsGetSample() {
 
-			if sg.Lp.DiffuseShadeMult() > 0.0 {
+			if sg.Lp.DiffuseShadeMult() > 0.0 && m.Vec3Dot(sg.Ld, sg.Ng) > 0.0 {
 
 				// In this example the brdf passed is an interface
 				// allowing sampling, pdf and bsdf eval
@@ -153,11 +153,12 @@ func (mtl *Material) Eval(sg *core.ShaderGlobals) {
 
 		}
 	*/
-	var speccontrib colour.RGB
+	var speccontrib, transcontrib colour.RGB
 
 	if mtl.Ks != nil {
 		var samp core.ScreenSample
-		ray := new(core.RayData)
+		ray := sg.NewRay()
+		defer sg.ReleaseRay(ray)
 
 		s := m.Vec3{}
 
@@ -232,7 +233,7 @@ func (mtl *Material) Eval(sg *core.ShaderGlobals) {
 				specrgb := colour.RGB(Kt)
 				specrgb.Mul(colour.RGB{r, g, b})
 				specrgb.Mul(samp.Colour)
-				speccontrib.Add(specrgb)
+				transcontrib.Add(specrgb)
 
 			}
 
@@ -256,15 +257,22 @@ func (mtl *Material) Eval(sg *core.ShaderGlobals) {
 		}
 
 		speccontrib.Scale(specWeight)
+		transcontrib.Scale(specWeight)
 		diffcontrib.Scale(diffWeight)
 		sg.OutRGB.Add(speccontrib)
+		sg.OutRGB.Add(transcontrib)
 	}
 skip:
 	sg.OutRGB.Add(diffcontrib)
 
+	sg.AOVSetRGB("diffuse", diffcontrib)
+	sg.AOVSetRGB("specular", speccontrib)
+	sg.AOVSetRGB("transmission", transcontrib)
+
 	if mtl.E != nil {
 		E := mtl.E.RGB(sg)
 		sg.OutRGB.Add(E)
+		sg.AOVSetRGB("emission", E)
 	}
 
 }
@@ -124,8 +124,13 @@ func (m *Material) SampleBSDF(surf *core.SurfacePoint, omega_i m.Vec3, rnd *rand
 }
 */
 
-// Emission returns the RGB emission for the given direction.
+// Emission returns the RGB emission for the given direction (in tangent space).  Surfaces
+// only emit on the side the normal faces, as lights are only sampled on that side.
 func (mtl *Material) Emission(sg *core.ShaderGlobals, omegaO m.Vec3) colour.RGB {
+	if omegaO[2] <= 0 {
+		return colour.RGB{}
+	}
+
 	return mtl.E.RGB(sg)
 }
 
@@ -15,12 +15,17 @@ package texture
 
 import (
 	//_ "github.com/ftrvxmtrx/tga"
-	_ "golang.org/x/image/tiff" // Imported for effect
+	vimage "github.com/jamiec7919/vermeer/image"
+	_ "github.com/jamiec7919/vermeer/image/exr" // Imported for effect
+	_ "github.com/jamiec7919/vermeer/image/hdr" // Imported for effect
+	_ "golang.org/x/image/tiff"                 // Imported for effect
 	"image"
 	_ "image/jpeg" // Imported for effect
 	_ "image/png"  // Imported for effect
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -31,6 +36,8 @@ type Texture struct {
 	fmt  int
 	w, h int
 	data []byte
+
+	fdata []float32 // Used instead of data for floating point (HDR) images
 }
 
 // TexStore is the type of the cache.
@@ -64,6 +71,11 @@ draw.Draw(m, m.Bounds(), src, b.Min, draw.Src)
 // future network texture server. (shouldn't be public)
 func LoadTexture(url string) (*Texture, error) {
 
+	switch strings.ToLower(filepath.Ext(url)) {
+	case ".exr", ".hdr":
+		return loadFloatTexture(url)
+	}
+
 	file, err := os.Open(url)
 	if err != nil {
 		return testTexture, err
@@ -119,6 +131,54 @@ func LoadTexture(url string) (*Texture, error) {
 	return t, nil
 }
 
+// loadFloatTexture loads a floating point image using the vermeer image readers.
+func loadFloatTexture(url string) (*Texture, error) {
+	r, err := vimage.Open(url)
+
+	if err != nil {
+		return testTexture, err
+	}
+	defer r.Close()
+
+	spec, err := r.Spec()
+
+	if err != nil {
+		return testTexture, err
+	}
+
+	nch := spec.NChannels
+
+	if nch == 0 {
+		nch = 3
+	}
+
+	img := make([]float32, spec.Width*spec.Height*nch)
+
+	if err := r.ReadImage(vimage.TypeDesc{BaseType: vimage.FLOAT}, img); err != nil {
+		return testTexture, err
+	}
+
+	t := &Texture{url: url, w: spec.Width, h: spec.Height, fdata: make([]float32, spec.Width*spec.Height*3)}
+
+	// Flip vertically to match LoadTexture, single channel images are replicated to RGB.
+	for j := 0; j < t.h; j++ {
+		for i := 0; i < t.w; i++ {
+			src := img[((t.h-1-j)*t.w+i)*nch:]
+			dst := t.fdata[(j*t.w+i)*3:]
+
+			for c := 0; c < 3; c++ {
+				if c < nch {
+					dst[c] = src[c]
+				} else {
+					dst[c] = src[0]
+				}
+			}
+		}
+	}
+
+	return t, nil
+}
+
 // SetRGB sets a pixel in a Texture object. (shouldn't be public)
 func (tex *Texture) SetRGB(x, y int, r, g, b byte) {
 	tex.data[(x+(y*tex.w))*3+0] = r
@@ -149,7 +209,6 @@ func cacheMiss(filename string) (*Texture, error) {
 	tex, err := LoadTexture(filename)
 
 	if err != nil {
-		loadMutex.Unlock()
 		log.Printf("texture.SampleRGB: \"%v\": %v", filename, err)
 		return nil, err
 	}
@@ -198,6 +257,11 @@ func SampleRGB(filename string, s, t, ds, dt float32) (out [3]float32) {
 		y += img.h
 	}
 
+	if img.fdata != nil {
+		copy(out[:], img.fdata[(x+(y*img.w))*3:])
+		return
+	}
+
 	out[0] = float32(img.data[(x+(y*img.w))*3+0]) / 255.0
 	out[1] = float32(img.data[(x+(y*img.w))*3+1]) / 255.0
 	out[2] = float32(img.data[(x+(y*img.w))*3+2]) / 255.0
@@ -2,7 +2,6 @@ package sample
 
 import (
 	"math"
-	"math/rand"
 )
 
 func permute(i, l, p int) int {
@@ -42,17 +41,36 @@ func permute(i, l, p int) int {
 	return (i + p) % l
 }
 
+// randfloat returns a float in [0,1) hashed from i and p.
+func randfloat(i, p int) float64 {
+	u, q := uint32(i), uint32(p)
+
+	u ^= q
+	u ^= u >> 17
+	u ^= u >> 10
+	u *= 0xb36534e5
+	u ^= u >> 12
+	u ^= u >> 21
+	u *= 0x93fc4795
+	u ^= 0xdf6e307f
+	u ^= u >> 17
+	u *= 1 | q>>18
+
+	return float64(u) / 4294967808.0
+}
+
 /*
 CMJ1 implements correlated multi-jittering.
 
 Implementation of http://graphics.pixar.com/library/MultiJitteredSampling/paper.pdf
+The result only depends on s, m, n and p.
 */
 func CMJ1(s, m, n, p int) (x, y float64) {
 	sx := float64(permute(s%m, m, p*0xa511e9b3))
 	sy := float64(permute(s/m, n, p*0x63d83595))
 
-	jx := rand.Float64()
-	jy := rand.Float64()
+	jx := randfloat(s, p*0x967a889b)
+	jy := randfloat(s, p*0x368cc8b7)
 
 	x = (float64(s%m) + (sy+jx)/float64(n)) / float64(m)
 	y = (float64(s/m) + (sx+jy)/float64(m)) / float64(n)
@@ -63,6 +81,7 @@ func CMJ1(s, m, n, p int) (x, y float64) {
 CMJ2 implements correlated multi-jittering.
 
 Implementation of http://graphics.pixar.com/library/MultiJitteredSampling/paper.pdf
+Sample s of N with pattern p, the result only depends on the arguments.
 */
 func CMJ2(s, N, p int) (x, y float64) {
 	a := 1.0
@@ -75,8 +94,8 @@ func CMJ2(s, N, p int) (x, y float64) {
 	sx := float64(permute(s%m, m, p*0x68bc21eb))
 	sy := float64(permute(s/m, n, p*0x368cc8b7))
 
-	jx := rand.Float64()
-	jy := rand.Float64()
+	jx := randfloat(s, p*0x967a889b)
+	jy := randfloat(s, p*0x368cc8b7)
 
 	x = (sx + (sy+jx)/float64(n)) / float64(m)
 	y = (float64(s) + jy) / float64(N)
@@ -0,0 +1,135 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sample
+
+import (
+	"sort"
+)
+
+// Distribution1D is a piecewise-constant distribution on [0,1) proportional to a function
+// given by its values on n equal pieces.  If the function is zero everywhere the pieces are
+// chosen uniformly.
+type Distribution1D struct {
+	f   []float32
+	cdf []float64 // cdf[i] is the probability of choosing pieces 0..i-1, len(f)+1 entries
+	sum float64   // Integral of the function over [0,1)
+}
+
+// NewDistribution1D returns the distribution for the non-negative values f.
+func NewDistribution1D(f []float32) *Distribution1D {
+	n := len(f)
+	d := &Distribution1D{f: f, cdf: make([]float64, n+1)}
+
+	for i, v := range f {
+		d.cdf[i+1] = d.cdf[i] + float64(v)/float64(n)
+	}
+
+	d.sum = d.cdf[n]
+
+	for i := 1; i <= n; i++ {
+		if d.sum > 0 {
+			d.cdf[i] /= d.sum
+		} else {
+			d.cdf[i] = float64(i) / float64(n)
+		}
+	}
+
+	d.cdf[n] = 1
+
+	return d
+}
+
+// Integral returns the integral of the function over [0,1).
+func (d *Distribution1D) Integral() float64 { return d.sum }
+
+// Sample returns x in [0,1) chosen by u, its PDF and the piece it is in.
+func (d *Distribution1D) Sample(u float64) (x float64, pdf float32, i int) {
+	// Last cdf entry not greater than u, skipping empty pieces.
+	i = sort.Search(len(d.cdf), func(k int) bool { return d.cdf[k] > u }) - 1
+
+	if i < 0 {
+		i = 0
+	} else if i >= len(d.f) {
+		i = len(d.f) - 1
+	}
+
+	du := u - d.cdf[i]
+
+	if w := d.cdf[i+1] - d.cdf[i]; w > 0 {
+		du /= w
+	}
+
+	x = (float64(i) + du) / float64(len(d.f))
+
+	if x >= 1 {
+		x = 1 - 1e-7
+	}
+
+	return x, d.PDF(i), i
+}
+
+// PDF returns the density of the distribution in piece i.
+func (d *Distribution1D) PDF(i int) float32 {
+	if d.sum == 0 {
+		return 1
+	}
+
+	return float32(float64(d.f[i]) / d.sum)
+}
+
+// Distribution2D is a piecewise-constant distribution on [0,1)^2 proportional to a function
+// given by its values on a grid of nu by nv pieces.  v is chosen first from the marginal
+// distribution of the rows and then u from the chosen row.
+type Distribution2D struct {
+	rows     []*Distribution1D
+	marginal *Distribution1D
+}
+
+// NewDistribution2D returns the distribution for the non-negative values f, stored in rows
+// of nu values for each of the nv pieces in v.
+func NewDistribution2D(f []float32, nu, nv int) *Distribution2D {
+	d := &Distribution2D{rows: make([]*Distribution1D, nv)}
+
+	marginal := make([]float32, nv)
+
+	for j := range d.rows {
+		d.rows[j] = NewDistribution1D(f[j*nu : (j+1)*nu])
+		marginal[j] = float32(d.rows[j].Integral())
+	}
+
+	d.marginal = NewDistribution1D(marginal)
+
+	return d
+}
+
+// Sample returns the point u,v chosen by u0, u1 and its PDF.
+func (d *Distribution2D) Sample(u0, u1 float64) (u, v float64, pdf float32) {
+	v, pdfV, j := d.marginal.Sample(u1)
+	u, pdfU, _ := d.rows[j].Sample(u0)
+
+	return u, v, pdfU * pdfV
+}
+
+// PDF returns the density of the distribution at u,v.
+func (d *Distribution2D) PDF(u, v float64) float32 {
+	j := clampIndex(v, len(d.rows))
+	row := d.rows[j]
+	i := clampIndex(u, len(row.f))
+
+	return row.PDF(i) * d.marginal.PDF(j)
+}
+
+// clampIndex returns the piece of n that x in [0,1) is in.
+func clampIndex(x float64, n int) int {
+	i := int(x * float64(n))
+
+	if i < 0 {
+		return 0
+	} else if i >= n {
+		return n - 1
+	}
+
+	return i
+}
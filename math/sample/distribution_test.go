@@ -0,0 +1,143 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sample
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+var distribution1DTests = [][]float32{
+	{7},
+	{1, 2, 3, 4},
+	{0, 0, 5, 0},
+	{1, 0, 0, 1, 0, 3},
+	{0, 0, 0},
+	{0.001, 1000, 0.5},
+}
+
+func TestDistribution1D(t *testing.T) {
+	const n = 100000
+
+	for _, f := range distribution1DTests {
+		d := NewDistribution1D(f)
+
+		// The PDF integrates to 1 and the integral is the mean of f.
+		integral, mean := 0.0, 0.0
+
+		for i, v := range f {
+			integral += float64(d.PDF(i)) / float64(len(f))
+			mean += float64(v) / float64(len(f))
+		}
+
+		if math.Abs(integral-1) > 1e-5 {
+			t.Errorf("%v: PDF integrates to %v", f, integral)
+		}
+
+		if math.Abs(d.Integral()-mean) > 1e-5*mean {
+			t.Errorf("%v: integral %v, want %v", f, d.Integral(), mean)
+		}
+
+		// A histogram of stratified samples matches the PDF.
+		hist := make([]int, len(f))
+
+		for k := 0; k < n; k++ {
+			x, pdf, i := d.Sample((float64(k) + 0.5) / n)
+
+			if x < 0 || x >= 1 || clampIndex(x, len(f)) != i {
+				t.Fatalf("%v: sample %v outside piece %v", f, x, i)
+			}
+
+			if pdf != d.PDF(i) || !(pdf > 0) {
+				t.Fatalf("%v: sample pdf %v, PDF %v", f, pdf, d.PDF(i))
+			}
+
+			hist[i]++
+		}
+
+		for i := range f {
+			want := float64(d.PDF(i)) / float64(len(f))
+
+			if got := float64(hist[i]) / n; math.Abs(got-want) > 2.0/n {
+				t.Errorf("%v: piece %v has %v of the samples, want %v", f, i, got, want)
+			}
+		}
+	}
+}
+
+func TestDistribution2D(t *testing.T) {
+	const nu, nv = 5, 4
+	const n = 400000
+
+	tests := [][]float32{
+		{
+			1, 2, 3, 4, 5,
+			0, 0, 0, 0, 0,
+			0, 7, 0, 0, 1,
+			2, 2, 2, 2, 2,
+		},
+		{
+			0, 0, 0, 0, 0,
+			0, 0, 0, 0, 0,
+			0, 0, 3, 0, 0,
+			0, 0, 0, 0, 0,
+		},
+		make([]float32, nu*nv),
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, f := range tests {
+		d := NewDistribution2D(f, nu, nv)
+
+		// The PDF integrates to 1.
+		integral := 0.0
+
+		for j := 0; j < nv; j++ {
+			for i := 0; i < nu; i++ {
+				integral += float64(d.PDF((float64(i)+0.5)/nu, (float64(j)+0.5)/nv)) / (nu * nv)
+			}
+		}
+
+		if math.Abs(integral-1) > 1e-5 {
+			t.Errorf("%v: PDF integrates to %v", f, integral)
+		}
+
+		// A histogram of the samples matches the PDF, no samples are in empty cells (unless f
+		// is zero everywhere).
+		var hist [nv][nu]int
+
+		for k := 0; k < n; k++ {
+			u, v, pdf := d.Sample(rnd.Float64(), rnd.Float64())
+
+			if u < 0 || u >= 1 || v < 0 || v >= 1 {
+				t.Fatalf("%v: sample %v,%v outside [0,1)^2", f, u, v)
+			}
+
+			if want := d.PDF(u, v); pdf != want || !(pdf > 0) {
+				t.Fatalf("%v: sample %v,%v pdf %v, PDF %v", f, u, v, pdf, want)
+			}
+
+			hist[clampIndex(v, nv)][clampIndex(u, nu)]++
+		}
+
+		for j := 0; j < nv; j++ {
+			for i := 0; i < nu; i++ {
+				want := float64(d.PDF((float64(i)+0.5)/nu, (float64(j)+0.5)/nv)) / (nu * nv)
+				got := float64(hist[j][i]) / n
+
+				if want == 0 && got != 0 {
+					t.Errorf("%v: empty cell %v,%v sampled", f, i, j)
+				}
+
+				// Allow 5 standard deviations.
+				if tol := 5 * math.Sqrt(want*(1-want)/n); math.Abs(got-want) > tol {
+					t.Errorf("%v: cell %v,%v has %v of the samples, want %v", f, i, j, got, want)
+				}
+			}
+		}
+	}
+}
@@ -67,3 +67,23 @@ func UniformDisk2D(radius, r0, r1 float32) (xo, yo float32) {
 	yo = radius * r * m.Sin(theta)
 	return
 }
+
+// UniformCone returns a unit vector uniformly sampled from the cone of directions within
+// angle theta of [0,0,1], where cosMax = cos(theta).
+// pdf is 1/(2*Pi*(1-cosMax))
+func UniformCone(cosMax, u0, u1 float64) m.Vec3 {
+	cosTheta := 1 - u0*(1-cosMax)
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * u1
+
+	return m.Vec3{float32(sinTheta * math.Cos(phi)), float32(sinTheta * math.Sin(phi)), float32(cosTheta)}
+}
+
+// UniformTriangle returns the barycentric coordinates b0, b1 (the weights of the first two
+// vertices) of a point uniformly sampled from a triangle.
+// pdf is 1/area
+func UniformTriangle(u0, u1 float64) (b0, b1 float32) {
+	su := math.Sqrt(u0)
+
+	return float32(1 - su), float32(u1 * su)
+}
@@ -1,9 +1,9 @@
 package sample
 
 import (
+	"github.com/jamiec7919/vermeer/math"
 	"math/rand"
 	"testing"
-	"vermeer/math"
 )
 
 func TestSample(t *testing.T) {
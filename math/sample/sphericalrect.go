@@ -0,0 +1,138 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sample
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+)
+
+// SphericalRect is the spherical rectangle formed by projecting a rectangle onto the unit
+// sphere around a point, for sampling points on the rectangle uniformly by solid angle
+// (Ureña et al. 2013).  The PDF with respect to solid angle is 1/SolidAngle.
+type SphericalRect struct {
+	o, x, y, z     m.Vec3
+	x0, y0, y1, z0 float64
+	x1             float64
+	b0, b1, k, s   float64
+}
+
+// NewSphericalRect returns the spherical rectangle for the rectangle with corner P and
+// perpendicular edges U and V as seen from O.
+func NewSphericalRect(O, P, U, V m.Vec3) (r SphericalRect) {
+	exl := float64(m.Vec3Length(U))
+	eyl := float64(m.Vec3Length(V))
+
+	r.o = O
+	r.x = m.Vec3Normalize(U)
+	r.y = m.Vec3Normalize(V)
+	r.z = m.Vec3Cross(r.x, r.y)
+
+	d := m.Vec3Sub(P, O)
+
+	r.z0 = float64(m.Vec3Dot(d, r.z))
+
+	// Flip z so that it points away from the rectangle.
+	if r.z0 > 0 {
+		r.z = m.Vec3Neg(r.z)
+		r.z0 = -r.z0
+	}
+
+	r.x0 = float64(m.Vec3Dot(d, r.x))
+	r.y0 = float64(m.Vec3Dot(d, r.y))
+	r.x1 = r.x0 + exl
+	r.y1 = r.y0 + eyl
+
+	if r.z0 == 0 {
+		return
+	}
+
+	// Normals of the planes through O and each edge.
+	v00 := [3]float64{r.x0, r.y0, r.z0}
+	v01 := [3]float64{r.x0, r.y1, r.z0}
+	v10 := [3]float64{r.x1, r.y0, r.z0}
+	v11 := [3]float64{r.x1, r.y1, r.z0}
+
+	n0 := crossNormalize(v00, v10)
+	n1 := crossNormalize(v10, v11)
+	n2 := crossNormalize(v11, v01)
+	n3 := crossNormalize(v01, v00)
+
+	g0 := math.Acos(clamp(-dot(n0, n1)))
+	g1 := math.Acos(clamp(-dot(n1, n2)))
+	g2 := math.Acos(clamp(-dot(n2, n3)))
+	g3 := math.Acos(clamp(-dot(n3, n0)))
+
+	r.b0 = n0[2]
+	r.b1 = n2[2]
+	r.k = 2*math.Pi - g2 - g3
+	r.s = g0 + g1 - r.k
+
+	return
+}
+
+// SolidAngle returns the solid angle of the rectangle, 0 if it is edge on or too small to
+// sample.
+func (r *SphericalRect) SolidAngle() float64 {
+	if !(r.s > 1e-10) {
+		return 0
+	}
+
+	return r.s
+}
+
+// Sample returns a point on the rectangle given two (quasi)random numbers.
+func (r *SphericalRect) Sample(u0, u1 float64) m.Vec3 {
+	au := u0*r.s + r.k
+	fu := (math.Cos(au)*r.b0 - r.b1) / math.Sin(au)
+	cu := 1 / math.Sqrt(fu*fu+r.b0*r.b0)
+
+	if fu <= 0 {
+		cu = -cu
+	}
+
+	cu = clamp(cu)
+
+	xu := -(cu * r.z0) / math.Sqrt(math.Max(0, 1-cu*cu))
+	xu = math.Min(math.Max(xu, r.x0), r.x1)
+
+	d := math.Sqrt(xu*xu + r.z0*r.z0)
+	h0 := r.y0 / math.Sqrt(d*d+r.y0*r.y0)
+	h1 := r.y1 / math.Sqrt(d*d+r.y1*r.y1)
+	hv := h0 + u1*(h1-h0)
+
+	yv := r.y1
+
+	if hv*hv < 1-1e-12 {
+		yv = (hv * d) / math.Sqrt(1-hv*hv)
+	}
+
+	P := m.Vec3Mad(r.o, r.x, float32(xu))
+	P = m.Vec3Mad(P, r.y, float32(yv))
+
+	return m.Vec3Mad(P, r.z, float32(r.z0))
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func crossNormalize(a, b [3]float64) (c [3]float64) {
+	c[0] = a[1]*b[2] - a[2]*b[1]
+	c[1] = a[2]*b[0] - a[0]*b[2]
+	c[2] = a[0]*b[1] - a[1]*b[0]
+
+	l := math.Sqrt(dot(c, c))
+
+	c[0] /= l
+	c[1] /= l
+	c[2] /= l
+
+	return
+}
+
+func clamp(x float64) float64 {
+	return math.Min(math.Max(x, -1), 1)
+}
@@ -0,0 +1,124 @@
+// Copyright 2016 The Vermeer Light Tools Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sample
+
+import (
+	m "github.com/jamiec7919/vermeer/math"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// rectSolidAngle integrates the solid angle of the part of the rectangle with corner P and
+// edges U, V between s0..s1 and t0..t1 (as fractions of the edges) seen from O, using the
+// midpoint rule on an n x n grid.
+func rectSolidAngle(O, P, U, V m.Vec3, s0, s1, t0, t1 float64, n int) float64 {
+	N := m.Vec3Cross(U, V)
+	area := float64(m.Vec3Length(N)) * (s1 - s0) * (t1 - t0) / float64(n*n)
+	N = m.Vec3Normalize(N)
+
+	sum := 0.0
+
+	for j := 0; j < n; j++ {
+		for i := 0; i < n; i++ {
+			s := s0 + (s1-s0)*(float64(i)+0.5)/float64(n)
+			t := t0 + (t1-t0)*(float64(j)+0.5)/float64(n)
+
+			var d [3]float64
+
+			for k := range d {
+				d[k] = float64(P[k]) + s*float64(U[k]) + t*float64(V[k]) - float64(O[k])
+			}
+
+			r := math.Sqrt(dot(d, d))
+			sum += math.Abs(d[0]*float64(N[0])+d[1]*float64(N[1])+d[2]*float64(N[2])) / (r * r * r)
+		}
+	}
+
+	return sum * area
+}
+
+var rectTests = []struct {
+	name       string
+	O, P, U, V m.Vec3
+}{
+	{"centred", m.Vec3{0, 0, 0}, m.Vec3{-1, -1, 1}, m.Vec3{2, 0, 0}, m.Vec3{0, 2, 0}},
+	{"offset", m.Vec3{0.3, -0.2, 0.1}, m.Vec3{0.5, 0.2, 2}, m.Vec3{1.5, 0, 0}, m.Vec3{0, 0.7, 0}},
+	{"below", m.Vec3{0.2, 0.1, 0}, m.Vec3{-1, -0.5, -0.5}, m.Vec3{0, 2, 0}, m.Vec3{1.2, 0, 0}},
+	{"rotated", m.Vec3{0, 0, 0}, m.Vec3{0.2, -1, 1}, m.Vec3{1, 1, 0}, m.Vec3{-0.5, 0.5, 1}},
+	{"far", m.Vec3{0, 0, 0}, m.Vec3{10, 20, -30}, m.Vec3{0.1, 0, 0}, m.Vec3{0, 0, 0.3}},
+	{"close", m.Vec3{0.5, 0.5, 0.01}, m.Vec3{0, 0, 0}, m.Vec3{1, 0, 0}, m.Vec3{0, 1, 0}},
+}
+
+func TestSphericalRectSolidAngle(t *testing.T) {
+	r := NewSphericalRect(m.Vec3{0, 0, 0}, m.Vec3{-1, -1, 1}, m.Vec3{2, 0, 0}, m.Vec3{0, 2, 0})
+
+	// Closed form for a square centred in front of O, 4 asin(a^2/(a^2+d^2)).
+	if got, want := r.SolidAngle(), 2*math.Pi/3; math.Abs(got-want) > 1e-5 {
+		t.Errorf("square: solid angle %v, want %v", got, want)
+	}
+
+	for _, test := range rectTests {
+		r := NewSphericalRect(test.O, test.P, test.U, test.V)
+		want := rectSolidAngle(test.O, test.P, test.U, test.V, 0, 1, 0, 1, 1000)
+
+		if got := r.SolidAngle(); math.Abs(got-want) > 1e-3*want {
+			t.Errorf("%v: solid angle %v, numeric %v", test.name, got, want)
+		}
+	}
+
+	// Edge on.
+	r = NewSphericalRect(m.Vec3{0, 0, 0}, m.Vec3{1, -1, 0}, m.Vec3{1, 0, 0}, m.Vec3{0, 2, 0})
+
+	if r.SolidAngle() != 0 {
+		t.Errorf("edge on: solid angle %v", r.SolidAngle())
+	}
+}
+
+// TestSphericalRectSample checks that the samples lie on the rectangle and are uniform in
+// solid angle by comparing a histogram over a grid of cells with each cell's solid angle.
+func TestSphericalRectSample(t *testing.T) {
+	const cells = 4
+	const n = 200000
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for _, test := range rectTests {
+		r := NewSphericalRect(test.O, test.P, test.U, test.V)
+		total := r.SolidAngle()
+
+		var hist [cells][cells]int
+
+		U2 := float64(m.Vec3Dot(test.U, test.U))
+		V2 := float64(m.Vec3Dot(test.V, test.V))
+		N := m.Vec3Normalize(m.Vec3Cross(test.U, test.V))
+
+		for k := 0; k < n; k++ {
+			d := m.Vec3Sub(r.Sample(rnd.Float64(), rnd.Float64()), test.P)
+
+			s := float64(m.Vec3Dot(d, test.U)) / U2
+			u := float64(m.Vec3Dot(d, test.V)) / V2
+
+			if h := math.Abs(float64(m.Vec3Dot(d, N))); h > 1e-4 || s < -1e-4 || s > 1+1e-4 || u < -1e-4 || u > 1+1e-4 {
+				t.Fatalf("%v: sample %v,%v height %v not on the rectangle", test.name, s, u, h)
+			}
+
+			hist[clampIndex(u, cells)][clampIndex(s, cells)]++
+		}
+
+		for j := 0; j < cells; j++ {
+			for i := 0; i < cells; i++ {
+				s0, t0 := float64(i)/cells, float64(j)/cells
+				want := rectSolidAngle(test.O, test.P, test.U, test.V, s0, s0+1.0/cells, t0, t0+1.0/cells, 200) / total
+				got := float64(hist[j][i]) / n
+
+				// Allow 5 standard deviations.
+				if tol := 5 * math.Sqrt(want*(1-want)/n); math.Abs(got-want) > tol+1e-4 {
+					t.Errorf("%v: cell %v,%v has %v of the samples, want %v", test.name, i, j, got, want)
+				}
+			}
+		}
+	}
+}
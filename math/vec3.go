@@ -122,6 +122,24 @@ func Vec3BasisProject(U, V, W, S Vec3) (o Vec3) {
 	return
 }
 
+// Vec3Basis returns unit vectors U and V that form an orthonormal basis with the unit vector W
+// (Duff et al. 2017).
+func Vec3Basis(W Vec3) (U, V Vec3) {
+	sign := float32(1)
+
+	if W[2] < 0 {
+		sign = -1
+	}
+
+	a := -1 / (sign + W[2])
+	b := W[0] * W[1] * a
+
+	U = Vec3{1 + sign*W[0]*W[0]*a, sign * b, -sign * W[0]}
+	V = Vec3{b, sign + W[1]*W[1]*a, -W[1]}
+
+	return
+}
+
 // Vec3BasisExpand calculates the vector S in the basis defined by U,V,W.
 // O := U*S_x + V*S_y + W*S_z
 func Vec3BasisExpand(U, V, W, S Vec3) (o Vec3) {
@@ -32,6 +32,8 @@ const (
 )
 
 var typeInt32 = reflect.TypeOf(int32(0))
+var typeFloat32 = reflect.TypeOf(float32(0))
+var typeString = reflect.TypeOf("")
 var typeUInt32 = reflect.TypeOf(uint32(0))
 var typeVec3 = reflect.TypeOf(m.Vec3{})
 var typeVec2 = reflect.TypeOf(m.Vec2{})
@@ -54,17 +56,47 @@ type parser struct {
 	filename string
 	lex      *Lex
 	rc       *core.RenderContext
+	nerrors  int // Number of errors reported
 }
 
 func init() {
 	Register("Globals", func() (core.Node, error) {
 
-		return &core.Globals{XRes: 256, YRes: 256, MaxGoRoutines: core.MAXGOROUTINES}, nil
+		return core.NewGlobals(), nil
+	})
+
+	Register("PathIntegrator", func() (core.Node, error) {
+		return &core.PathIntegrator{}, nil
+	})
+
+	Register("DirectIntegrator", func() (core.Node, error) {
+		return &core.DirectIntegrator{}, nil
+	})
+
+	Register("BDPTIntegrator", func() (core.Node, error) {
+		return &core.BDPTIntegrator{}, nil
+	})
+
+	Register("MLTIntegrator", func() (core.Node, error) {
+		return core.NewMLTIntegrator(), nil
+	})
+
+	Register("SPPMIntegrator", func() (core.Node, error) {
+		return core.NewSPPMIntegrator(), nil
+	})
+
+	Register("AOIntegrator", func() (core.Node, error) {
+		return core.NewAOIntegrator(), nil
+	})
+
+	Register("DebugIntegrator", func() (core.Node, error) {
+		return core.NewDebugIntegrator(), nil
 	})
 }
 
 // Parse attempts to open filename and parse the contents, adding nodes to rc.  Returns
-// nil on success or an appropriate error.
+// nil on success or an appropriate error.  If any parse errors were reported an error
+// is returned after the whole file has been read.
 func Parse(rc *core.RenderContext, filename string) error {
 
 	f, err := os.Open(filename)
@@ -74,6 +106,8 @@ func Parse(rc *core.RenderContext, filename string) error {
 
 	}
 
+	defer f.Close()
+
 	in := bufio.NewReader(f)
 
 	var l Lex
@@ -85,7 +119,15 @@ func Parse(rc *core.RenderContext, filename string) error {
 
 	//	l.error = parser.error
 
-	return parser.parse()
+	if err := parser.parse(); err != nil {
+		return err
+	}
+
+	if parser.nerrors > 0 {
+		return fmt.Errorf("%v: %v parse error(s)", filename, parser.nerrors)
+	}
+
+	return nil
 
 }
 
@@ -119,6 +161,69 @@ func (p *parser) int32slice(field reflect.Value) error {
 	return nil
 }
 
+func (p *parser) float32slice(field reflect.Value) error {
+	var sym SymType
+
+	count := -1
+
+	if t := p.lex.Lex(&sym); t != TokInt {
+		return errors.New("Expected slice length.")
+	}
+
+	count = int(sym.numInt)
+
+	if t := p.lex.Lex(&sym); t != TokToken || sym.str != "float" {
+		return errors.New("Expected slice type.")
+	}
+
+	s := make([]float32, 0, count)
+
+	for i := 0; i < count; i++ {
+		switch t := p.lex.Lex(&sym); t {
+		case TokFloat:
+			s = append(s, float32(sym.numFloat))
+		case TokInt:
+			s = append(s, float32(sym.numInt))
+		default:
+			return errors.New("Expected float.")
+		}
+	}
+
+	field.Set(reflect.ValueOf(s))
+
+	return nil
+}
+
+func (p *parser) stringslice(field reflect.Value) error {
+	var sym SymType
+
+	count := -1
+
+	if t := p.lex.Lex(&sym); t != TokInt {
+		return errors.New("Expected slice length.")
+	}
+
+	count = int(sym.numInt)
+
+	if t := p.lex.Lex(&sym); t != TokToken || sym.str != "string" {
+		return errors.New("Expected slice type.")
+	}
+
+	s := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		if t := p.lex.Lex(&sym); t != TokString {
+			return errors.New("Expected string.")
+		}
+
+		s = append(s, sym.str)
+	}
+
+	field.Set(reflect.ValueOf(s))
+
+	return nil
+}
+
 func (p *parser) rgb(field reflect.Value) error {
 
 	var sym SymType
@@ -150,7 +255,7 @@ func (p *parser) floatmap(field reflect.Value) error {
 
 	var sym SymType
 
-	if t := p.lex.Lex(&sym); t != TokToken && sym.str != "float" {
+	if t := p.lex.Lex(&sym); t != TokToken || sym.str != "float" {
 		return errors.New("Expected field type.")
 	}
 
@@ -189,6 +294,7 @@ func (p *parser) rgbtex(field reflect.Value) error {
 	}
 
 	v.Filename = sym.str
+	p.rc.AddAsset(v.Filename)
 
 	field.Set(reflect.ValueOf(v))
 
@@ -438,7 +544,7 @@ func (p *parser) float32array(field reflect.Value) error {
 
 	v.ElemsPerKey = int(sym.numInt)
 
-	if t := p.lex.Lex(&sym); t != TokToken && sym.str != "float" {
+	if t := p.lex.Lex(&sym); t != TokToken || sym.str != "float" {
 		return errors.New("Expected array type.")
 	}
 
@@ -548,6 +654,26 @@ func (p *parser) param(field reflect.Value) error {
 				p.errorf("Invalid token for param (expecting length of slice)")
 				p.lex.Skip()
 			}
+		case typeFloat32:
+			switch t := p.lex.Peek(&v); t {
+			case TokInt:
+				if err := p.float32slice(field); err != nil {
+					p.errorf("%v", err)
+				}
+			default:
+				p.errorf("Invalid token for param (expecting length of slice)")
+				p.lex.Skip()
+			}
+		case typeString:
+			switch t := p.lex.Peek(&v); t {
+			case TokInt:
+				if err := p.stringslice(field); err != nil {
+					p.errorf("%v", err)
+				}
+			default:
+				p.errorf("Invalid token for param (expecting length of slice)")
+				p.lex.Skip()
+			}
 		}
 
 	case reflect.Interface:
@@ -664,6 +790,7 @@ func (p *parser) node(name string) (core.Node, error) {
 }
 
 func (p *parser) errorf(msg string, v ...interface{}) {
+	p.nerrors++
 	line := p.lex.LineNumber
 	col := p.lex.ColNumber
 	if err := p.rc.Error(fmt.Errorf("%v:%v:%v: %v", p.filename, line, col, fmt.Sprintf(msg, v...))); err != nil {